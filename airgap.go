@@ -0,0 +1,168 @@
+package canarytail
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Signing requests and detached signatures cross the air gap to an offline machine as frames of text,
+// shown as QR codes or pasted:
+//
+//	CT1:KIND:INDEX/TOTAL:CHECK:DATA
+//
+// DATA is a slice of the deflated JSON payload in base32, and CHECK the start of its SHA-256 hash, in
+// base32 too. Frames only use the alphanumeric charset of QR codes, so they stay small.
+
+// The kinds of payloads sent across the air gap
+const (
+	AirgapRequest   = "REQ"
+	AirgapSignature = "SIG"
+)
+
+// AirgapFrameSize is the number of characters of data in a frame, few enough for a QR code in a terminal
+const AirgapFrameSize = 300
+
+// AirgapMaxFrames is the most frames a payload is split into, far more than a signing request needs
+const AirgapMaxFrames = 256
+
+// airgapMaxPayload is the most bytes of JSON a payload inflates to
+const airgapMaxPayload = 1 << 20
+
+const airgapMagic = "CT1"
+
+var airgapEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func airgapCheck(data string) string {
+	digest := sha256.Sum256([]byte(data))
+	return airgapEncoding.EncodeToString(digest[:])[:8]
+}
+
+// EncodeAirgap encodes a payload in JSON, and splits it into frames
+func EncodeAirgap(kind string, v interface{}) ([]string, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var compressed bytes.Buffer
+	w, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	data := airgapEncoding.EncodeToString(compressed.Bytes())
+	check := airgapCheck(data)
+	total := (len(data) + AirgapFrameSize - 1) / AirgapFrameSize
+	if total > AirgapMaxFrames {
+		return nil, fmt.Errorf("the payload takes %d frames, more than %d", total, AirgapMaxFrames)
+	}
+	frames := make([]string, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * AirgapFrameSize
+		if end > len(data) {
+			end = len(data)
+		}
+		frames = append(frames, fmt.Sprintf("%s:%s:%d/%d:%s:%s", airgapMagic, kind, i+1, total, check, data[i*AirgapFrameSize:end]))
+	}
+	return frames, nil
+}
+
+// IsAirgapFrame tells whether a line is a frame, rather than text around it
+func IsAirgapFrame(line string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line)), airgapMagic+":")
+}
+
+// AirgapDecoder reassembles the frames of a payload of a kind, in any order
+type AirgapDecoder struct {
+	Kind string
+
+	check    string
+	frames   []string
+	received int
+}
+
+// Add adds a frame, and tells whether every frame of the payload was received
+func (d *AirgapDecoder) Add(frame string) (bool, error) {
+	parts := strings.SplitN(strings.ToUpper(strings.TrimSpace(frame)), ":", 5)
+	if len(parts) != 5 || parts[0] != airgapMagic {
+		return false, errors.New("not a frame")
+	}
+	if parts[1] != d.Kind {
+		return false, fmt.Errorf("the frame is a %v, not a %v", parts[1], d.Kind)
+	}
+	counts := strings.SplitN(parts[2], "/", 2)
+	if len(counts) != 2 {
+		return false, fmt.Errorf("invalid frame number %q", parts[2])
+	}
+	index, err := strconv.Atoi(counts[0])
+	if err != nil {
+		return false, fmt.Errorf("invalid frame number %q", parts[2])
+	}
+	total, err := strconv.Atoi(counts[1])
+	if err != nil || total < 1 || index < 1 || index > total {
+		return false, fmt.Errorf("invalid frame number %q", parts[2])
+	}
+	if total > AirgapMaxFrames {
+		return false, fmt.Errorf("the payload has %d frames, more than %d", total, AirgapMaxFrames)
+	}
+
+	if d.frames == nil {
+		d.check, d.frames = parts[3], make([]string, total)
+	}
+	if parts[3] != d.check || total != len(d.frames) {
+		return false, errors.New("the frame belongs to another payload")
+	}
+	if d.frames[index-1] == "" {
+		d.frames[index-1] = parts[4]
+		d.received++
+	}
+	return d.Done(), nil
+}
+
+// Done tells whether every frame of the payload was received
+func (d *AirgapDecoder) Done() bool {
+	return d.frames != nil && d.received == len(d.frames)
+}
+
+// Progress returns the number of frames received, and expected
+func (d *AirgapDecoder) Progress() (int, int) {
+	return d.received, len(d.frames)
+}
+
+// Decode checks the frames against their hash, and decodes the JSON payload into v
+func (d *AirgapDecoder) Decode(v interface{}) error {
+	if !d.Done() {
+		received, total := d.Progress()
+		return fmt.Errorf("received %d of %d frames", received, total)
+	}
+	data := strings.Join(d.frames, "")
+	if airgapCheck(data) != d.check {
+		return errors.New("the frames do not match their hash, scan them again")
+	}
+	compressed, err := airgapEncoding.DecodeString(data)
+	if err != nil {
+		return fmt.Errorf("invalid frames: %v", err)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), airgapMaxPayload+1))
+	if err != nil {
+		return fmt.Errorf("invalid frames: %v", err)
+	}
+	if len(content) > airgapMaxPayload {
+		return fmt.Errorf("the payload inflates to more than %d bytes", airgapMaxPayload)
+	}
+	return json.Unmarshal(content, v)
+}
@@ -0,0 +1,112 @@
+package canarytail_test
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// qrAlphanumeric is the charset QR codes encode compactly
+var qrAlphanumeric = regexp.MustCompile(`^[0-9A-Z $%*+\-./:]+$`)
+
+func TestAirgapRoundTrip(t *testing.T) {
+	keys := rotationKeys(t, 4)
+	canary := testCanary(t, keys[:1], withCosigners(keys[1:4]...))
+	frames, err := canarytail.EncodeAirgap(canarytail.AirgapRequest, canarytail.NewSigningRequest(canary))
+	require.NoError(t, err)
+	require.True(t, len(frames) > 1)
+	for _, frame := range frames {
+		assert.Regexp(t, qrAlphanumeric, frame)
+		assert.True(t, canarytail.IsAirgapFrame(frame))
+	}
+
+	// frames are scanned in any order, twice, and pasted in lower case
+	decoder := &canarytail.AirgapDecoder{Kind: canarytail.AirgapRequest}
+	for i := len(frames) - 1; i > 0; i-- {
+		done, err := decoder.Add(frames[i])
+		require.NoError(t, err)
+		assert.False(t, done)
+	}
+	_, err = decoder.Add(frames[1])
+	require.NoError(t, err)
+	done, err := decoder.Add(strings.ToLower(frames[0]) + "\n")
+	require.NoError(t, err)
+	assert.True(t, done)
+	var request canarytail.SigningRequest
+	require.NoError(t, decoder.Decode(&request))
+	require.NoError(t, request.Verify())
+
+	// the signature crosses back, and is checked as it is merged
+	signature, err := request.Sign(keys[1])
+	require.NoError(t, err)
+	frames, err = canarytail.EncodeAirgap(canarytail.AirgapSignature, signature)
+	require.NoError(t, err)
+	decoder = &canarytail.AirgapDecoder{Kind: canarytail.AirgapSignature}
+	for _, frame := range frames {
+		_, err := decoder.Add(frame)
+		require.NoError(t, err)
+	}
+	var detached canarytail.DetachedSignature
+	require.NoError(t, decoder.Decode(&detached))
+	require.NoError(t, canary.Merge(detached))
+	assert.True(t, canary.ValidateSignatures(keys[1].Public().(ed25519.PublicKey)))
+}
+
+func TestAirgapDecoderRefusesFrames(t *testing.T) {
+	keys := rotationKeys(t, 4)
+	request := canarytail.NewSigningRequest(testCanary(t, keys[:1], withCosigners(keys[1:4]...)))
+	frames, err := canarytail.EncodeAirgap(canarytail.AirgapRequest, request)
+	require.NoError(t, err)
+
+	// frames of another kind or payload
+	decoder := &canarytail.AirgapDecoder{Kind: canarytail.AirgapSignature}
+	_, err = decoder.Add(frames[0])
+	assert.Error(t, err)
+	request.Claim.Release = time.Now().Add(time.Hour).Format(canarytail.TimestampLayout)
+	other, err := canarytail.EncodeAirgap(canarytail.AirgapRequest, request)
+	require.NoError(t, err)
+	decoder = &canarytail.AirgapDecoder{Kind: canarytail.AirgapRequest}
+	_, err = decoder.Add(frames[0])
+	require.NoError(t, err)
+	_, err = decoder.Add(other[1])
+	assert.Error(t, err)
+	assert.Error(t, decoder.Decode(&canarytail.SigningRequest{}))
+
+	// a frame misread by the scanner
+	decoder = &canarytail.AirgapDecoder{Kind: canarytail.AirgapRequest}
+	for i, frame := range frames {
+		if i == 0 {
+			frame = frame[:len(frame)-1] + map[bool]string{true: "B", false: "A"}[strings.HasSuffix(frame, "A")]
+		}
+		_, err := decoder.Add(frame)
+		require.NoError(t, err)
+	}
+	assert.Error(t, decoder.Decode(&canarytail.SigningRequest{}))
+}
+
+func TestAirgapDecoderLimits(t *testing.T) {
+	// a frame claiming more frames than any payload takes is refused before anything is allocated
+	decoder := &canarytail.AirgapDecoder{Kind: canarytail.AirgapRequest}
+	_, err := decoder.Add("CT1:REQ:1/999999999999:AAAAAAAA:AAAA")
+	assert.Error(t, err)
+	_, err = decoder.Add(fmt.Sprintf("CT1:REQ:1/%d:AAAAAAAA:AAAA", canarytail.AirgapMaxFrames+1))
+	assert.Error(t, err)
+
+	// nor does a payload inflate without a bound
+	frames, err := canarytail.EncodeAirgap(canarytail.AirgapRequest, strings.Repeat("A", 2<<20))
+	require.NoError(t, err)
+	for _, frame := range frames {
+		_, err := decoder.Add(frame)
+		require.NoError(t, err)
+	}
+	var payload string
+	assert.Contains(t, decoder.Decode(&payload).Error(), "inflates to more than")
+}
@@ -0,0 +1,172 @@
+package canarytail_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBlockHash = "0000000000000bae09a7a393a8acded75aa67e46cb81f7acaa5ad94f9eacd103"
+
+func newBitcoindServer(t *testing.T, height int, blockTime int64) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "rpc" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		switch req.Method {
+		case "getbestblockhash":
+			fmt.Fprintf(w, `{"result":%q,"error":null,"id":"canarytail"}`, testBlockHash)
+		case "getblockheader":
+			if req.Params[0] != testBlockHash {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `{"result":null,"error":{"code":-5,"message":"Block not found"},"id":"canarytail"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"result":{"hash":%q,"confirmations":10,"height":%d,"time":%d,"bits":"1a0d69d7","previousblockhash":"00"},"error":null,"id":"canarytail"}`,
+				testBlockHash, height, blockTime)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newEsploraServer(t *testing.T, height int, blockTime int64) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blocks/tip/hash", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testBlockHash)
+	})
+	mux.HandleFunc("/block/"+testBlockHash, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id":%q,"height":%d,"timestamp":%d,"bits":437129626,"previousblockhash":"00"}`, testBlockHash, height, blockTime)
+	})
+	mux.HandleFunc("/block/"+testBlockHash+"/status", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"in_best_chain":true,"height":154595}`)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newEsploraChainServer serves the blocks of the given heights, with tip as the tip of the chain
+func newEsploraChainServer(t *testing.T, tip string, heights map[string]int) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blocks/tip/hash", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, tip)
+	})
+	for hash, height := range heights {
+		hash, height := hash, height
+		mux.HandleFunc("/block/"+hash, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"id":%q,"height":%d,"timestamp":%d,"bits":437129626,"previousblockhash":"00"}`, hash, height, 1322131230+height)
+		})
+		mux.HandleFunc("/block/"+hash+"/status", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"in_best_chain":true,"height":%d}`, height)
+		})
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestBitcoindBackend(t *testing.T) {
+	srv := newBitcoindServer(t, 154595, 1322131230)
+	backend, err := canarytail.ParseBlockBackend("bitcoind=http://rpc:secret@" + srv.Listener.Addr().String())
+	require.NoError(t, err)
+
+	hash, err := backend.LatestBlockHash()
+	require.NoError(t, err)
+	assert.Equal(t, testBlockHash, hex.EncodeToString(hash))
+
+	info, err := backend.BlockInfo(hash)
+	require.NoError(t, err)
+	assert.Equal(t, 154595, info.Height)
+	assert.Equal(t, int64(1322131230), info.Time)
+	assert.Equal(t, int64(0x1a0d69d7), info.Bits)
+	assert.True(t, info.MainChain)
+
+	_, err = backend.BlockInfo(make([]byte, 32))
+	assert.Error(t, err)
+}
+
+func TestEsploraBackend(t *testing.T) {
+	srv := newEsploraServer(t, 154595, 1322131230)
+	backend, err := canarytail.ParseBlockBackend("esplora=" + srv.URL)
+	require.NoError(t, err)
+
+	hash, err := backend.LatestBlockHash()
+	require.NoError(t, err)
+	assert.Equal(t, testBlockHash, hex.EncodeToString(hash))
+
+	info, err := backend.BlockInfo(hash)
+	require.NoError(t, err)
+	assert.Equal(t, 154595, info.Height)
+	assert.Equal(t, int64(1322131230), info.Time)
+	assert.True(t, info.MainChain)
+}
+
+func TestQuorumBackend(t *testing.T) {
+	hash, _ := hex.DecodeString(testBlockHash)
+	bitcoind, _ := canarytail.NewBitcoindBackend("http://rpc:secret@" + newBitcoindServer(t, 154595, 1322131230).Listener.Addr().String())
+	esplora := canarytail.NewEsploraBackend(newEsploraServer(t, 154595, 1322131230).URL)
+	liar := canarytail.NewEsploraBackend(newEsploraServer(t, 154595, 1622131230).URL)
+	down := canarytail.NewEsploraBackend("http://127.0.0.1:1")
+
+	t.Run("agreement", func(t *testing.T) {
+		quorum, err := canarytail.NewQuorumBackend(2, bitcoind, esplora, liar)
+		require.NoError(t, err)
+		info, err := quorum.BlockInfo(hash)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1322131230), info.Time)
+
+		latest, err := quorum.LatestBlockHash()
+		require.NoError(t, err)
+		assert.Equal(t, hash, latest)
+	})
+
+	t.Run("disagreement", func(t *testing.T) {
+		quorum, err := canarytail.NewQuorumBackend(2, bitcoind, liar, down)
+		require.NoError(t, err)
+		_, err = quorum.BlockInfo(hash)
+		assert.Error(t, err)
+	})
+
+	t.Run("tips a block apart", func(t *testing.T) {
+		ahead := newEsploraChainServer(t, "02", map[string]int{"01": 100, "02": 101})
+		behind := newEsploraChainServer(t, "01", map[string]int{"01": 100})
+		quorum, err := canarytail.NewQuorumBackend(2, canarytail.NewEsploraBackend(ahead.URL), canarytail.NewEsploraBackend(behind.URL), down)
+		require.NoError(t, err)
+		latest, err := quorum.LatestBlockHash()
+		require.NoError(t, err)
+		assert.Equal(t, "01", hex.EncodeToString(latest), "the tip both backends have")
+
+		quorum, err = canarytail.NewQuorumBackend(2, canarytail.NewEsploraBackend(ahead.URL), canarytail.NewEsploraBackend(ahead.URL), canarytail.NewEsploraBackend(behind.URL))
+		require.NoError(t, err)
+		latest, err = quorum.LatestBlockHash()
+		require.NoError(t, err)
+		assert.Equal(t, "02", hex.EncodeToString(latest), "the highest tip the quorum has")
+
+		forked := newEsploraChainServer(t, "03", map[string]int{"03": 100})
+		quorum, err = canarytail.NewQuorumBackend(2, canarytail.NewEsploraBackend(forked.URL), canarytail.NewEsploraBackend(behind.URL), down)
+		require.NoError(t, err)
+		_, err = quorum.LatestBlockHash()
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid quorum", func(t *testing.T) {
+		_, err := canarytail.NewQuorumBackend(3, bitcoind, esplora)
+		assert.Error(t, err)
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
 // Blockchain
@@ -54,13 +55,78 @@ type BlockInfo struct {
 	Time          int64  `json:"time"`
 	Bits          int64  `json:"bits"`
 	BlockIndex    int    `json:"block_index"`
+	MainChain     bool   `json:"main_chain"`
 	Height        int    `json:"height"`
 }
 
-// GetLastBlockChainBlockHash retrieves the latest block hash from the BlockChain Data API
+// BlockBackend is a source of Bitcoin block data used to pick and verify the freshness block of a canary
+type BlockBackend interface {
+	// Name identifies the backend in error messages
+	Name() string
+	// LatestBlockHash retrieves the hash of the tip of the best chain
+	LatestBlockHash() ([]byte, error)
+	// BlockInfo retrieves the block information for a given block hash
+	BlockInfo(blockHash []byte) (BlockInfo, error)
+}
+
+// DefaultBlockBackend is the backend used by GetLastBlockChainBlockHash and GetBlockInfo
+var DefaultBlockBackend BlockBackend = NewBlockchainInfoBackend("")
+
+// BlockchainInfoBackend retrieves blocks from the BlockChain Data API
+type BlockchainInfoBackend struct {
+	URL string
+}
+
+// NewBlockchainInfoBackend instantiates a BlockchainInfoBackend. An empty url defaults to https://blockchain.info
+func NewBlockchainInfoBackend(url string) *BlockchainInfoBackend {
+	if url == "" {
+		url = "https://blockchain.info"
+	}
+	return &BlockchainInfoBackend{URL: strings.TrimSuffix(url, "/")}
+}
+
+// Name identifies the backend in error messages
+func (b *BlockchainInfoBackend) Name() string {
+	return b.URL
+}
+
+// LatestBlockHash retrieves the latest block hash from the BlockChain Data API
+func (b *BlockchainInfoBackend) LatestBlockHash() ([]byte, error) {
+	content, err := readBlockChainAPI(b.URL + "/q/latesthash")
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(content)))
+}
+
+// BlockInfo retrieves the block information from the BlockChain Data API
+func (b *BlockchainInfoBackend) BlockInfo(blockHash []byte) (BlockInfo, error) {
+	url := fmt.Sprintf("%s/rawblock/%s", b.URL, hex.EncodeToString(blockHash))
+	content, err := readBlockChainAPI(url)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+
+	var blockInfo BlockInfo
+	err = json.Unmarshal(content, &blockInfo)
+	return blockInfo, err
+}
+
+// BlockHeader retrieves the serialized header of a block, the first 80 bytes of the raw block
+func (b *BlockchainInfoBackend) BlockHeader(blockHash []byte) ([]byte, error) {
+	content, err := readBlockChainAPI(fmt.Sprintf("%s/rawblock/%s?format=hex", b.URL, hex.EncodeToString(blockHash)))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < 2*BlockHeaderSize {
+		return nil, fmt.Errorf("the raw block returned by %s is too short", b.Name())
+	}
+	return hex.DecodeString(string(content[:2*BlockHeaderSize]))
+}
+
+// GetLastBlockChainBlockHash retrieves the latest block hash from the DefaultBlockBackend
 func GetLastBlockChainBlockHash() []byte {
-	content, err := readBlockChainAPI("https://blockchain.info/q/latesthash")
-	hash, err := hex.DecodeString(string(content))
+	hash, err := DefaultBlockBackend.LatestBlockHash()
 	if err != nil {
 		return nil
 	}
@@ -77,15 +143,7 @@ func GetLastBlockChainBlockHashFormatted() string {
 	return FormatBlockChainBlockHash(GetLastBlockChainBlockHash())
 }
 
-// GetBlockInfo retrieves the block information from the BlockChain Data API
+// GetBlockInfo retrieves the block information from the DefaultBlockBackend
 func GetBlockInfo(blockHash []byte) (BlockInfo, error) {
-	url := fmt.Sprintf("https://blockchain.info/rawblock/%s", hex.EncodeToString(blockHash))
-	content, err := readBlockChainAPI(url)
-	if err != nil {
-		return BlockInfo{}, err
-	}
-
-	var blockInfo BlockInfo
-	err = json.Unmarshal(content, &blockInfo)
-	return blockInfo, err
+	return DefaultBlockBackend.BlockInfo(blockHash)
 }
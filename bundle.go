@@ -0,0 +1,129 @@
+package canarytail
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Canaries can be signed offline: the author exports a SigningRequest, every signer returns the
+// DetachedSignature of its key, and the author merges them into the canary. No one but the author
+// writes the canary, so signers never race on it.
+
+// SigningRequest is a canary to sign: its claim without signatures, the hash of the claim and the
+// signers yet to sign it
+type SigningRequest struct {
+	Version string      `json:"version"`
+	Claim   CanaryClaim `json:"canary"`
+	Hash    string      `json:"hash"`
+	Signers []PublicKey `json:"signers"`
+	// FreshnessProof is the freshness proof of the canary, so that signers can check its freshness offline
+	FreshnessProof *FreshnessProof `json:"freshness_proof,omitempty"`
+}
+
+// DetachedSignature is what a signer of a SigningRequest returns: the signature set of its key, and of
+// its panic key if it signed with it too
+type DetachedSignature struct {
+	Hash       string                         `json:"hash"`
+	Signatures map[string]*CanarySignatureSet `json:"signatures"`
+}
+
+// ClaimHash is the SHA-256 hash of the version and claim of the canary, in hex. Signatures bound to it
+// can only be merged into a canary with the same claim.
+func (c Canary) ClaimHash() string {
+	signed := struct {
+		Version string      `json:"version"`
+		Claim   CanaryClaim `json:"canary"`
+	}{c.Version, c.Claim}
+	contents, _ := json.Marshal(signed)
+	digest := sha256.Sum256(contents)
+	return hex.EncodeToString(digest[:])
+}
+
+// NewSigningRequest exports the claim of a canary for the signers that have not signed it yet
+func NewSigningRequest(c Canary) *SigningRequest {
+	r := &SigningRequest{Version: c.Version, Claim: c.Claim, Hash: c.ClaimHash(), Signers: []PublicKey{}, FreshnessProof: c.FreshnessProof}
+	for _, k := range c.Claim.PublicKeys {
+		if _, signed := c.Signatures[k.Key]; !signed {
+			r.Signers = append(r.Signers, k)
+		}
+	}
+	return r
+}
+
+// Canary is the unsigned canary of the request
+func (r SigningRequest) Canary() Canary {
+	return Canary{Version: r.Version, Claim: r.Claim, FreshnessProof: r.FreshnessProof}
+}
+
+// Verify checks the hash of the request is the hash of its claim
+func (r SigningRequest) Verify() error {
+	if r.Hash != r.Canary().ClaimHash() {
+		return errors.New("the hash of the signing request does not match its claim")
+	}
+	return nil
+}
+
+// Sign signs the claim of the request with the key of one of its signers, or the panic key
+func (r SigningRequest) Sign(signer crypto.Signer) (*DetachedSignature, error) {
+	if err := r.Verify(); err != nil {
+		return nil, err
+	}
+	pubKey, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("canaries are signed with Ed25519 keys, not %T", signer.Public())
+	}
+	if _, ok := r.Claim.signer(FormatKey(pubKey)); !ok && FormatKey(pubKey) != r.Claim.PanicKey {
+		return nil, errors.New("signer's public key not found in the list of signers")
+	}
+	c := r.Canary()
+	if err := c.Sign(signer); err != nil {
+		return nil, err
+	}
+	return &DetachedSignature{Hash: r.Hash, Signatures: c.Signatures}, nil
+}
+
+// signer finds the signer of the claim whose key or own panic key is key
+func (claim CanaryClaim) signer(key string) (PublicKey, bool) {
+	for _, k := range claim.PublicKeys {
+		if k.Key == key || (k.PanicKey != "" && k.PanicKey == key) {
+			return k, true
+		}
+	}
+	return PublicKey{}, false
+}
+
+// Merge adds the signatures of a detached signature to the canary, once checked against its claim.
+// Only the keys of its signers and the panic keys may sign.
+func (c *Canary) Merge(d DetachedSignature) error {
+	if d.Hash != c.ClaimHash() {
+		return errors.New("the signature is for another claim than the canary's: the canary changed since the signing request")
+	}
+	if len(d.Signatures) == 0 {
+		return errors.New("the detached signature has no signatures")
+	}
+	for key, signatureSet := range d.Signatures {
+		if _, ok := c.Claim.signer(key); !ok && key != c.Claim.PanicKey {
+			return fmt.Errorf("the key %v is not a signer of the canary", key)
+		}
+		pubKey, err := ParsePublicKey(key)
+		if err != nil || len(pubKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid key %q in the detached signature", key)
+		}
+		signed := Canary{Version: c.Version, Claim: c.Claim, Signatures: map[string]*CanarySignatureSet{key: signatureSet}}
+		if signatureSet == nil || !signed.ValidateSignatures(pubKey) {
+			return fmt.Errorf("invalid signature of the key %v", key)
+		}
+	}
+	if c.Signatures == nil {
+		c.Signatures = make(map[string]*CanarySignatureSet)
+	}
+	for key, signatureSet := range d.Signatures {
+		c.Signatures[key] = signatureSet
+	}
+	return nil
+}
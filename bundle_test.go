@@ -0,0 +1,66 @@
+package canarytail_test
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningRequest(t *testing.T) {
+	keys := rotationKeys(t, 5)
+	canary := testCanary(t, keys[:1], withCosigners(keys[1:4]...))
+	request := canarytail.NewSigningRequest(canary)
+	require.NoError(t, request.Verify())
+	assert.Len(t, request.Signers, 3)
+	assert.Equal(t, "alice", request.Signers[0].Name)
+
+	// every signer signs on its own, in any order
+	for _, k := range []int{3, 1, 2} {
+		signature, err := request.Sign(keys[k])
+		require.NoError(t, err)
+		assert.Len(t, signature.Signatures, 1)
+		require.NoError(t, canary.Merge(*signature))
+	}
+	assert.Len(t, canary.Signatures, 4)
+	ok, err := canarytail.NewCanaryValidator(canary).Validate()
+	assert.True(t, ok, "%v", err)
+
+	// only the signers may sign
+	_, err = request.Sign(keys[4])
+	assert.Error(t, err)
+	forged := *request
+	forged.Claim.Codes = []string{"war"}
+	_, err = forged.Sign(keys[2])
+	assert.Error(t, err)
+}
+
+func TestMergeChecksSignatures(t *testing.T) {
+	keys := rotationKeys(t, 4)
+	canary := testCanary(t, keys[:1], withCosigners(keys[1:4]...))
+	request := canarytail.NewSigningRequest(canary)
+	signature, err := request.Sign(keys[2])
+	require.NoError(t, err)
+
+	// a canary changed since the request does not take its signatures
+	changed := canary
+	changed.Claim.Expiry = canary.ExiprationTimestamp().Add(time.Hour).Format(canarytail.TimestampLayout)
+	assert.Error(t, changed.Merge(*signature))
+
+	// nor does it take signatures tampered with, or of another key
+	bobKey := canary.Claim.PublicKeys[2].Key
+	other, err := request.Sign(keys[3])
+	require.NoError(t, err)
+	carolKey := canary.Claim.PublicKeys[3].Key
+	tampered := canarytail.DetachedSignature{Hash: signature.Hash, Signatures: map[string]*canarytail.CanarySignatureSet{bobKey: other.Signatures[carolKey]}}
+	assert.Error(t, canary.Merge(tampered))
+	_, signed := canary.Signatures[bobKey]
+	assert.False(t, signed)
+
+	require.NoError(t, canary.Merge(*signature))
+	assert.True(t, canary.ValidateSignatures(keys[2].Public().(ed25519.PublicKey)))
+}
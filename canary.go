@@ -1,9 +1,16 @@
 package canarytail
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -35,6 +42,11 @@ type CanaryClaim struct {
 	Freshness  string      `json:"freshness"`
 	Codes      []string    `json:"codes"`
 	Mirrors    []string    `json:"mirrors"`
+	// Headlines are the news headlines quoted by the canary, when its Freshness is HeadlinesFreshness
+	Headlines []Headline `json:"headlines,omitempty"`
+	// KeysDestroyed tells the signing keys of the author were destroyed once the canary was signed with
+	// the panic key, so that no clean canary can follow
+	KeysDestroyed bool `json:"keys_destroyed,omitempty"`
 }
 
 // CanarySignature we will keep this as a string for now, in the future it will support several signatures
@@ -51,6 +63,9 @@ type CanarySignatureSet struct {
 	Freshness  CanarySignature `json:"freshness"`
 	Codes      CanarySignature `json:"codes"`
 	Mirrors    CanarySignature `json:"mirrors"`
+	Headlines  CanarySignature `json:"headlines,omitempty"`
+
+	KeysDestroyed CanarySignature `json:"keys_destroyed,omitempty"`
 }
 
 type PublicKey struct {
@@ -62,6 +77,17 @@ type PublicKey struct {
 	Key string `json:"key"`
 	// Required is true if required for verification.
 	Required bool `json:"required"`
+	// PanicKey is the own panic key of the signer, to signal duress in its name, if any.
+	PanicKey string `json:"panickey,omitempty"`
+}
+
+// String formats the signer as the signatures of the public keys sign it. The panic key is only
+// appended when set, so that the signatures of canaries without it remain valid.
+func (k PublicKey) String() string {
+	if k.PanicKey == "" {
+		return fmt.Sprintf("{%s %s %s %t}", k.Role, k.Name, k.Key, k.Required)
+	}
+	return fmt.Sprintf("{%s %s %s %t %s}", k.Role, k.Name, k.Key, k.Required, k.PanicKey)
 }
 
 // StructToMap converts a struct to a map while maintaining the json alias as keys
@@ -81,6 +107,10 @@ type CanaryValidator struct {
 	Canary         Canary
 	Validators     []CanarySignatureValidator
 	PanicValidator CanarySignatureValidator
+	// Revocations are the revocations of signer keys enforced, by default the ones of the canary
+	Revocations []Revocation
+	// Previous is the previous canary, which a canary signed by a delegated key is checked against
+	Previous *Canary
 }
 
 // NewCanaryValidator instantiates a CanaryValidator
@@ -92,6 +122,7 @@ func NewCanaryValidator(canary Canary) (validator *CanaryValidator) {
 			Canary:    canary,
 			PublicKey: canary.Claim.PanicKey,
 		},
+		Revocations: canary.Revocations,
 	}
 
 	// Security level LOW
@@ -111,15 +142,54 @@ func NewCanaryValidator(canary Canary) (validator *CanaryValidator) {
 
 // Validate validates all the registered validators (one per public key in the canary plus the panic key)
 func (v *CanaryValidator) Validate() (bool, error) {
-	signedCount := 0 // Count of listed signers that signed.
-	for _, pubKey := range v.Canary.Claim.PublicKeys {
-		_, ok := v.Canary.Signatures[pubKey.Key]
-		if pubKey.Required && !ok {
-			return false, fmt.Errorf("required signature not found from the signer %q", pubKey.Name)
+	report, err := v.Report()
+	if err != nil {
+		return false, err
+	}
+	signedCount := 0 // Count of listed signers that signed, with keys not revoked.
+	revoked, delegated := make(map[string]bool), make(map[string]bool)
+	// a panic signature trumps anything else wrong with the canary, such as the missing signatures of a
+	// canary tripped by the dead man's switch
+	if ok, _ := v.PanicValidator.Validate(); ok && v.Canary.Claim.KeysDestroyed {
+		return false, fmt.Errorf("The panic key %s was used to sign the canary, and the signing keys were destroyed", v.PanicValidator.PublicKey)
+	} else if ok {
+		return false, fmt.Errorf("The panic key %s was used to sign the canary", v.PanicValidator.PublicKey)
+	}
+	// the author key destroyed after a panic must not sign anymore
+	if p := v.Previous; p != nil && p.Claim.KeysDestroyed && p.ValidateSignatures(p.PanicKey()) {
+		if _, signed := v.Canary.Signatures[p.AuthorKey()]; signed {
+			return false, errors.New("the canary is signed with the author key destroyed after the panic of the previous canary")
+		}
+	}
+	for _, r := range report {
+		if r.Status == SignaturePanic {
+			return false, fmt.Errorf("The signer %q signed the canary with its panic key", r.Signer.Name)
+		}
+	}
+	for _, r := range report {
+		if r.Revocation != nil {
+			revoked[r.Signer.Key] = true
+			if r.Signer.Required {
+				return false, fmt.Errorf("the key of the required signer %q is revoked since %v", r.Signer.Name, r.Revocation.Effective)
+			}
+			continue
+		}
+		if r.Signer.Required && r.Status == SignatureMissing {
+			return false, fmt.Errorf("required signature not found from the signer %q", r.Signer.Name)
 		}
-		if ok {
+		if r.Status != SignatureMissing {
 			signedCount++
 		}
+		// the delegated key signed in place of the signer, within the scope of its delegation
+		if r.Status == SignatureDelegated {
+			delegated[r.Signer.Key] = true
+			if v.Previous == nil {
+				return false, fmt.Errorf("the canary is signed by the delegated key %q: the previous canary is needed to check what it changed", r.Delegation.Name)
+			}
+			if err := v.Canary.CheckDelegatedChanges(*v.Previous); err != nil {
+				return false, err
+			}
+		}
 	}
 	// Checking for min signers.
 	// This only accounts for the listed signers.
@@ -127,13 +197,11 @@ func (v *CanaryValidator) Validate() (bool, error) {
 		return false, fmt.Errorf("min signers criteria not met, required %d from the listed signers, got %d",
 			v.Canary.Claim.MinSigners, signedCount)
 	}
-
-	// check if the panic key has signed
-	if ok, _ := v.PanicValidator.Validate(); ok {
-		return false, fmt.Errorf("The panic key %s was used to sign the canary", v.PanicValidator.PublicKey)
-	}
 	// validate wether all the public keys have signed or not
 	for _, validator := range v.Validators {
+		if revoked[validator.PublicKey] || delegated[validator.PublicKey] {
+			continue
+		}
 		if ok, err := validator.Validate(); !ok {
 			return false, err
 		}
@@ -165,6 +233,55 @@ type Canary struct {
 	Version    string                         `json:"version"`
 	Claim      CanaryClaim                    `json:"canary"`
 	Signatures map[string]*CanarySignatureSet `json:"signatures"` // the key of the map is the public key that signs the signature set
+	// FreshnessProof optionally embeds the header of the freshness block, so it can be checked offline
+	FreshnessProof *FreshnessProof `json:"freshness_proof,omitempty"`
+	// OpenTimestamps optionally holds a base64 encoded OpenTimestamps proof of the canary Digest
+	OpenTimestamps string `json:"opentimestamps,omitempty"`
+	// TimestampToken optionally holds a base64 encoded RFC 3161 timestamp token of the canary Digest
+	TimestampToken string `json:"timestamp_token,omitempty"`
+	// Rotations is the chain of rotations of the author key, oldest first. They are signed on their own.
+	Rotations []KeyRotation `json:"rotations,omitempty"`
+	// Revocations are the revocations of signer keys published with the canary, signed on their own
+	Revocations []Revocation `json:"revocations,omitempty"`
+	// Delegation certifies the key that signed the canary in place of the author, if any
+	Delegation *Delegation `json:"delegation,omitempty"`
+}
+
+// Digest computes the SHA-256 hash of the signed parts of the canary: its version, claims and
+// signatures. Proofs attached to the canary are left out, so they can be added afterwards.
+func (c Canary) Digest() []byte {
+	signed := struct {
+		Version    string                         `json:"version"`
+		Claim      CanaryClaim                    `json:"canary"`
+		Signatures map[string]*CanarySignatureSet `json:"signatures"`
+	}{c.Version, c.Claim, c.Signatures}
+	contents, _ := json.Marshal(signed)
+	digest := sha256.Sum256(contents)
+	return digest[:]
+}
+
+// OpenTimestampsProof parses the OpenTimestamps proof attached to the canary, if any
+func (c Canary) OpenTimestampsProof() (*OpenTimestampsProof, error) {
+	if c.OpenTimestamps == "" {
+		return nil, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(c.OpenTimestamps)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := ParseOpenTimestampsProof(data)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(proof.Digest(), c.Digest()) {
+		return nil, errors.New("the OpenTimestamps proof is not a proof of this canary")
+	}
+	return proof, nil
+}
+
+// SetOpenTimestampsProof attaches an OpenTimestamps proof to the canary
+func (c *Canary) SetOpenTimestampsProof(proof *OpenTimestampsProof) {
+	c.OpenTimestamps = base64.StdEncoding.EncodeToString(proof.Bytes())
 }
 
 // AllCodes lists all Canary codes
@@ -184,8 +301,22 @@ func AllCodes() []string {
 	}
 }
 
-// Sign generates the signatures of all the canary claims for a given public key
-func (c *Canary) Sign(privKey, pubKey []byte) (err error) {
+// CanarySigner is implemented by signers that sign whole canaries rather than single claims, such as
+// the signer daemon, so that they can check what they sign
+type CanarySigner interface {
+	crypto.Signer
+	SignCanary(c *Canary) error
+}
+
+// Sign generates the signatures of all the canary claims with an Ed25519 signer, under its public key
+func (c *Canary) Sign(signer crypto.Signer) (err error) {
+	pubKey, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("canaries are signed with Ed25519 keys, not %T", signer.Public())
+	}
+	if canarySigner, ok := signer.(CanarySigner); ok {
+		return canarySigner.SignCanary(c)
+	}
 	if c.Signatures == nil {
 		c.Signatures = make(map[string]*CanarySignatureSet)
 	}
@@ -195,36 +326,47 @@ func (c *Canary) Sign(privKey, pubKey []byte) (err error) {
 	signatureSet := c.Signatures[pubKeyEncoded]
 
 	// Sign the fields
-	if signatureSet.Domain, err = c.signField(c.Claim.Domain, privKey); err != nil {
+	if signatureSet.Domain, err = c.signField(c.Claim.Domain, signer); err != nil {
 		return
 	}
-	if signatureSet.MinSigners, err = c.signField(c.Claim.MinSigners, privKey); err != nil {
+	if signatureSet.MinSigners, err = c.signField(c.Claim.MinSigners, signer); err != nil {
 		return
 	}
-	if signatureSet.PublicKeys, err = c.signField(c.Claim.PublicKeys, privKey); err != nil {
+	if signatureSet.PublicKeys, err = c.signField(c.Claim.PublicKeys, signer); err != nil {
 		return
 	}
-	if signatureSet.PanicKey, err = c.signField(c.Claim.PanicKey, privKey); err != nil {
+	if signatureSet.PanicKey, err = c.signField(c.Claim.PanicKey, signer); err != nil {
 		return
 	}
-	if signatureSet.Version, err = c.signField(c.Version, privKey); err != nil {
+	if signatureSet.Version, err = c.signField(c.Version, signer); err != nil {
 		return
 	}
-	if signatureSet.Release, err = c.signField(c.Claim.Release, privKey); err != nil {
+	if signatureSet.Release, err = c.signField(c.Claim.Release, signer); err != nil {
 		return
 	}
-	if signatureSet.Expiry, err = c.signField(c.Claim.Expiry, privKey); err != nil {
+	if signatureSet.Expiry, err = c.signField(c.Claim.Expiry, signer); err != nil {
 		return
 	}
-	if signatureSet.Freshness, err = c.signField(c.Claim.Freshness, privKey); err != nil {
+	if signatureSet.Freshness, err = c.signField(c.Claim.Freshness, signer); err != nil {
 		return
 	}
-	if signatureSet.Codes, err = c.signField(c.Claim.Codes, privKey); err != nil {
+	if signatureSet.Codes, err = c.signField(c.Claim.Codes, signer); err != nil {
 		return
 	}
-	if signatureSet.Mirrors, err = c.signField(c.Claim.Mirrors, privKey); err != nil {
+	if signatureSet.Mirrors, err = c.signField(c.Claim.Mirrors, signer); err != nil {
 		return
 	}
+	// only canaries quoting headlines sign them, so that the signatures of other canaries stay the same
+	if len(c.Claim.Headlines) > 0 {
+		if signatureSet.Headlines, err = c.signField(c.Claim.Headlines, signer); err != nil {
+			return
+		}
+	}
+	if c.Claim.KeysDestroyed {
+		if signatureSet.KeysDestroyed, err = c.signField(c.Claim.KeysDestroyed, signer); err != nil {
+			return
+		}
+	}
 	return
 }
 
@@ -279,6 +421,12 @@ func (c *Canary) ValidateSignatures(pubKey []byte) bool {
 	if !c.validateSignature(c.Claim.Mirrors, signatureSet.Mirrors, pubKey) {
 		return false
 	}
+	if (len(c.Claim.Headlines) > 0 || signatureSet.Headlines != "") && !c.validateSignature(c.Claim.Headlines, signatureSet.Headlines, pubKey) {
+		return false
+	}
+	if (c.Claim.KeysDestroyed || signatureSet.KeysDestroyed != "") && !c.validateSignature(c.Claim.KeysDestroyed, signatureSet.KeysDestroyed, pubKey) {
+		return false
+	}
 	return true
 }
 
@@ -297,10 +445,14 @@ func (c *Canary) standardFieldValue(v interface{}) string {
 	}
 }
 
-func (c *Canary) signField(value interface{}, privKey []byte) (CanarySignature, error) {
+func (c *Canary) signField(value interface{}, signer crypto.Signer) (CanarySignature, error) {
 	// represent the value as a string
 	valueStr := c.standardFieldValue(value)
-	signatureRaw := SignString(valueStr, privKey)
+	// Ed25519 signs the message itself, not a digest
+	signatureRaw, err := signer.Sign(rand.Reader, []byte(valueStr), crypto.Hash(0))
+	if err != nil {
+		return "", err
+	}
 	signature := base64.StdEncoding.EncodeToString(signatureRaw)
 	return CanarySignature(signature), nil
 }
@@ -359,38 +511,99 @@ func (c Canary) PanicKey() []byte {
 	return nil
 }
 
+// ValidateOptions tunes how a canary is validated. The zero value validates online against the
+// DefaultBlockBackend, on the Bitcoin main network.
+type ValidateOptions struct {
+	// Offline skips every network lookup. The freshness block is then only checked through the
+	// freshness proof embedded in the canary.
+	Offline bool
+	// Params are the consensus rules the freshness proof is checked against (default: MainNetParams)
+	Params *ChainParams
+	// Headers, when set, are used to verify the Bitcoin attestations of the OpenTimestamps proof
+	// attached to the canary. Pending proofs are accepted. Without them, the attestations are not
+	// verified.
+	Headers HeaderLookup
+	// TSARoots, when set, are the trusted roots of the timestamp authorities. Without them the RFC 3161
+	// timestamp token of the canary is only checked for consistency, not for who signed it.
+	TSARoots *x509.CertPool
+	// Time, when set, is the time the canary is validated at, for instance as read from Roughtime
+	// servers. The canary must not have expired by its latest bound. (default: LocalTime)
+	Time *ClockReading
+	// Drand is the chain info the freshness round is checked against, for canaries using drand
+	// instead of Bitcoin for freshness
+	Drand *DrandChainInfo
+	// FeedArchives are where the headlines quoted by the canary are looked up (default: DefaultFeedArchives)
+	FeedArchives []FeedArchive
+	// PinnedKey, when set, is the author key trusted from an earlier canary, in base64. The author key
+	// of the canary must be that key, or be reached from it through the rotations of the canary.
+	PinnedKey string
+	// Revocations are revocations of signer keys published alongside the canary, enforced on top of
+	// the ones embedded in it
+	Revocations []Revocation
+	// Previous is the canary validated before this one. It is needed to validate a canary signed by a
+	// delegated key, which may only change the fields of its delegation.
+	Previous *Canary
+}
+
 // Validate validates if the Canary claims indicate some sort of issue
 func (c Canary) Validate() (bool, error) {
+	return c.ValidateWithOptions(ValidateOptions{})
+}
+
+// ValidateWithOptions validates if the Canary claims indicate some sort of issue
+func (c Canary) ValidateWithOptions(opts ValidateOptions) (bool, error) {
 	// validate the signatures with the public key
 	validator := NewCanaryValidator(c)
+	validator.Revocations = append(append([]Revocation(nil), c.Revocations...), opts.Revocations...)
+	validator.Previous = opts.Previous
 	if ok, err := validator.Validate(); !ok {
 		return false, err
 	}
 
+	// check the author key was rotated from the pinned key, if any
+	if err := c.VerifyRotations(); err != nil {
+		return false, fmt.Errorf("Could not validate the canary: invalid key rotation: %v", err)
+	}
+	if opts.PinnedKey != "" {
+		if _, err := c.FollowRotations(opts.PinnedKey); err != nil {
+			return false, fmt.Errorf("Could not validate the canary: %v", err)
+		}
+	}
+
+	now := LocalTime()
+	if opts.Time != nil {
+		now = *opts.Time
+	}
+
 	// check if the canary has expired
-	if c.IsExpired() {
+	if c.ExiprationTimestamp().Before(now.Latest) {
 		return false, fmt.Errorf("Could not validate the canary: the canary has expired")
 	}
 
 	// check if the canary has been released in the future
-	if time.Now().Before(c.ReleaseTimestamp()) {
-		return false, fmt.Errorf("Could not validate the canary: the canary is released with a date in the future: %v vs %v", time.Now(), c.ReleaseTimestamp())
+	if now.Latest.Before(c.ReleaseTimestamp()) {
+		return false, fmt.Errorf("Could not validate the canary: the canary is released with a date in the future: %v vs %v", now.Midpoint(), c.ReleaseTimestamp())
 	}
 
-	// check if the reported block exists in the blockchain
-	blockHash, err := hex.DecodeString(c.Claim.Freshness)
-	if err != nil {
-		return false, fmt.Errorf("Could not validate the canary: the block provided seems not to be valid, or there is an issue retrieving the block info: %v", err)
+	if err := c.CheckFreshness(opts); err != nil {
+		return false, fmt.Errorf("Could not validate the canary: %v", err)
 	}
-	blockInfo, err := GetBlockInfo(blockHash)
-	if err != nil {
-		return false, fmt.Errorf("Could not validate the canary: the block provided seems not to be valid, or there is an issue retrieving the block info: %v", err)
+
+	// check the anchoring of the canary in the blockchain, if it is timestamped. Without headers, the
+	// proof is only checked to be a proof of the canary, its attestations are left unchecked.
+	if opts.Headers != nil {
+		if _, err := c.VerifyOpenTimestamps(opts.Headers); err != nil && err != ErrTimestampPending {
+			return false, fmt.Errorf("Could not validate the canary: invalid OpenTimestamps proof: %v", err)
+		}
+	} else if _, err := c.OpenTimestampsProof(); err != nil {
+		return false, fmt.Errorf("Could not validate the canary: invalid OpenTimestamps proof: %v", err)
 	}
 
-	// check block's freshness in the blockchain (compare against Release claim? 1h tolerance?)
-	blockReleasedTime := time.Unix(blockInfo.Time, 0)
-	if c.ReleaseTimestamp().Sub(blockReleasedTime) > time.Hour*1 {
-		return false, fmt.Errorf("Could not validate the canary: the block provided was more than 1h older than the release date of the canary")
+	// check the trusted timestamp of the canary, if it is countersigned by a TSA
+	if c.TimestampToken != "" {
+		if _, err := c.VerifyTimestampToken(opts.TSARoots); err != nil {
+			return false, fmt.Errorf("Could not validate the canary: invalid timestamp token: %v", err)
+		}
 	}
 
 	// check for missing codes in the canary, which will trigger its failure
@@ -402,6 +615,130 @@ func (c Canary) Validate() (bool, error) {
 	return true, nil
 }
 
+// CheckFreshness checks the freshness block of the canary exists, and was released shortly before the
+// canary
+func (c Canary) CheckFreshness(opts ValidateOptions) error {
+	// check if the reported block exists in the blockchain
+	blockReleasedTime, err := c.freshnessBlockTime(opts)
+	if err != nil {
+		return fmt.Errorf("the block provided seems not to be valid, or there is an issue retrieving the block info: %v", err)
+	}
+
+	// check block's freshness in the blockchain (compare against Release claim? 1h tolerance?)
+	maxAge := time.Hour * 1
+	if c.Claim.Freshness == HeadlinesFreshness {
+		maxAge = maxHeadlineAge
+	}
+	if c.ReleaseTimestamp().Sub(blockReleasedTime) > maxAge {
+		return fmt.Errorf("the block provided was more than %v older than the release date of the canary", maxAge)
+	}
+	// block timestamps may run up to 2h ahead, but not more
+	if blockReleasedTime.Sub(c.ReleaseTimestamp()) > maxFutureBlockTime {
+		return errors.New("the block provided is more recent than the release date of the canary")
+	}
+	return nil
+}
+
+// freshnessBlockTime checks the freshness block and returns its timestamp. The embedded freshness proof
+// is checked first, if any; the block backend then only has to confirm it is still in the main chain.
+func (c Canary) freshnessBlockTime(opts ValidateOptions) (time.Time, error) {
+	if IsDrandFreshness(c.Claim.Freshness) {
+		return c.drandFreshnessTime(opts)
+	}
+	if c.Claim.Freshness == HeadlinesFreshness {
+		return c.headlinesFreshnessTime(opts)
+	}
+
+	params := opts.Params
+	if params == nil {
+		params = MainNetParams
+	}
+
+	var blockTime time.Time
+	if c.FreshnessProof != nil {
+		header, _, err := c.FreshnessProof.Verify(c.Claim.Freshness, params)
+		if err != nil {
+			return time.Time{}, err
+		}
+		blockTime = header.Timestamp()
+	} else if opts.Offline {
+		return time.Time{}, ErrNoFreshnessProof
+	}
+	if opts.Offline {
+		return blockTime, nil
+	}
+
+	blockHash, err := hex.DecodeString(c.Claim.Freshness)
+	if err != nil {
+		return time.Time{}, err
+	}
+	blockInfo, err := GetBlockInfo(blockHash)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !blockInfo.MainChain {
+		return time.Time{}, fmt.Errorf("the block %s is not in the main chain", c.Claim.Freshness)
+	}
+	if c.FreshnessProof == nil {
+		blockTime = time.Unix(blockInfo.Time, 0)
+	}
+	return blockTime, nil
+}
+
+// VerifyOpenTimestamps verifies the OpenTimestamps proof of the canary against the block headers and
+// returns the time before which the canary existed. It fails with ErrTimestampPending when the proof
+// has no Bitcoin attestation yet, and returns a zero time when there is no proof.
+func (c Canary) VerifyOpenTimestamps(headers HeaderLookup) (time.Time, error) {
+	proof, err := c.OpenTimestampsProof()
+	if err != nil || proof == nil {
+		return time.Time{}, err
+	}
+	attested, err := proof.Verify(headers)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if attested.Before(c.ReleaseTimestamp().Add(-maxFutureBlockTime)) {
+		return time.Time{}, fmt.Errorf("the canary is attested at %v, before its release", attested)
+	}
+	return attested, nil
+}
+
+// VerifyTimestampToken verifies the RFC 3161 timestamp token of the canary, optionally checking its TSA
+// chains up to roots, and returns the time it attests. That time must fall between the release and the
+// expiry of the canary.
+func (c Canary) VerifyTimestampToken(roots *x509.CertPool) (time.Time, error) {
+	der, err := base64.StdEncoding.DecodeString(c.TimestampToken)
+	if err != nil {
+		return time.Time{}, err
+	}
+	token, err := ParseTimestampToken(der)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := token.VerifyImprint(c.Digest()); err != nil {
+		return time.Time{}, err
+	}
+	if roots != nil {
+		if err := token.VerifyChain(roots); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	genTime, accuracy := token.Info.GenTime, token.Info.Accuracy.Duration()
+	if genTime.Add(accuracy).Before(c.ReleaseTimestamp().Add(-timestampSkew)) {
+		return time.Time{}, fmt.Errorf("the token is dated %v, before the release of the canary", genTime)
+	}
+	if genTime.Add(-accuracy).After(c.ExiprationTimestamp()) {
+		return time.Time{}, fmt.Errorf("the token is dated %v, after the expiry of the canary", genTime)
+	}
+	return genTime, nil
+}
+
+// SetTimestampToken attaches a DER encoded RFC 3161 timestamp token to the canary
+func (c *Canary) SetTimestampToken(der []byte) {
+	c.TimestampToken = base64.StdEncoding.EncodeToString(der)
+}
+
 // Format gets the JSON representation of the canary
 func (c Canary) Format() string {
 	contents, _ := json.MarshalIndent(&c, "", "    ")
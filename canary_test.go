@@ -1,12 +1,15 @@
 package canarytail_test
 
 import (
+	"crypto/ed25519"
+	"fmt"
 	"testing"
 	"time"
 
 	canarytail "github.com/canarytail/client"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestInverseCodes(t *testing.T) {
@@ -65,11 +68,11 @@ func TestCanarySignature(t *testing.T) {
 	c1 := canarytail.Canary{
 		Claim: canarytail.CanaryClaim{
 			Domain:     "test",
-			PublicKeys: []string{canarytail.FormatKey(publicKey)},
+			PublicKeys: []canarytail.PublicKey{{Key: canarytail.FormatKey(publicKey)}},
 		},
 	}
 
-	err = c1.Sign(privateKey, publicKey)
+	err = c1.Sign(privateKey)
 	assert.Nil(t, err)
 
 	assert.True(t, c1.ValidateSignatures(publicKey))
@@ -77,13 +80,74 @@ func TestCanarySignature(t *testing.T) {
 	c2 := canarytail.Canary{
 		Claim: canarytail.CanaryClaim{
 			Domain:     "test",
-			PublicKeys: []string{canarytail.FormatKey(publicKey)},
+			PublicKeys: []canarytail.PublicKey{{Key: canarytail.FormatKey(publicKey)}},
 			Codes:      canarytail.AllCodes(),
 		},
 	}
 
-	err = c2.Sign(privateKey, publicKey)
+	err = c2.Sign(privateKey)
 	assert.Nil(t, err)
 
 	assert.True(t, c2.ValidateSignatures(publicKey))
 }
+
+func TestSignerPanic(t *testing.T) {
+	keys := rotationKeys(t, 5)
+	alicePanic := keys[4]
+	canary := testCanary(t, keys[:4], func(c *canarytail.Canary) {
+		c.Claim.PublicKeys[1].PanicKey = canarytail.FormatKey(alicePanic.Public().(ed25519.PublicKey))
+	})
+	ok, err := canarytail.NewCanaryValidator(canary).Validate()
+	assert.True(t, ok, "%v", err)
+
+	// the cosigner signs with its own panic key, on top of its signing key or in its place
+	require.NoError(t, canary.Sign(alicePanic))
+	validator := canarytail.NewCanaryValidator(canary)
+	report, err := validator.Report()
+	require.NoError(t, err)
+	assert.Equal(t, canarytail.SignaturePanic, report[1].Status)
+	assert.Equal(t, canarytail.SignatureValid, report[2].Status)
+	ok, err = validator.Validate()
+	assert.False(t, ok)
+	assert.Contains(t, err.Error(), `"alice"`)
+}
+
+func TestPublicKeyFormat(t *testing.T) {
+	// the signatures of the signers of canaries without panic keys are unchanged
+	k := canarytail.PublicKey{Role: canarytail.RoleCosigner, Name: "alice", Key: "a2V5", Required: true}
+	assert.Equal(t, "[{cosigner alice a2V5 true}]", fmt.Sprintf("%v", []canarytail.PublicKey{k}))
+	k.PanicKey = "cGFuaWM="
+	assert.Equal(t, "[{cosigner alice a2V5 true cGFuaWM=}]", fmt.Sprintf("%v", []canarytail.PublicKey{k}))
+}
+
+func TestKeysDestroyed(t *testing.T) {
+	keys := rotationKeys(t, 5)
+	panicKey := keys[4]
+	now := time.Now()
+	panicked := testCanary(t, keys[:4], releasedAt(now.Add(-time.Hour)))
+	panicked.Claim.PanicKey = canarytail.FormatKey(panicKey.Public().(ed25519.PublicKey))
+	panicked.Claim.KeysDestroyed = true
+	panicked.Signatures = nil
+	require.NoError(t, panicked.Sign(panicKey))
+	ok, err := canarytail.NewCanaryValidator(panicked).Validate()
+	assert.False(t, ok)
+	assert.Contains(t, err.Error(), "destroyed")
+
+	// the flag is signed
+	forged := panicked
+	forged.Claim.KeysDestroyed = false
+	assert.False(t, forged.ValidateSignatures(panicKey.Public().(ed25519.PublicKey)))
+
+	// a clean canary signed with the destroyed author key is refused
+	clean := testCanary(t, keys[:4], releasedAt(now))
+	validator := canarytail.NewCanaryValidator(clean)
+	ok, err = validator.Validate()
+	assert.True(t, ok, "%v", err)
+	validator.Previous = &panicked
+	ok, err = validator.Validate()
+	assert.False(t, ok)
+	assert.Contains(t, err.Error(), "destroyed")
+
+	// and so is a renewal by a delegated key
+	assert.Error(t, clean.CheckDelegatedChanges(panicked))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	canarytail "github.com/canarytail/client"
+
+	qrcode "github.com/skip2/go-qrcode"
+	"golang.org/x/term"
+)
+
+// airgapOpts carry signing requests and signatures across the air gap, as frames of text shown as QR
+// codes or pasted
+type airgapOpts struct {
+	Airgap bool `name:"airgap" help:"Exchange the signing request and signature with an offline machine as frames of text on the terminal, instead of files"`
+	QR     bool `name:"qr" help:"With --airgap, show the frames as QR codes, one at a time"`
+}
+
+// showFrames prints the frames of a payload, as QR codes if asked to
+func (o airgapOpts) showFrames(kind string, v interface{}) error {
+	frames, err := canarytail.EncodeAirgap(kind, v)
+	if err != nil {
+		return err
+	}
+	if !o.QR {
+		fmt.Println(strings.Join(frames, "\n"))
+		return nil
+	}
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
+	for i, frame := range frames {
+		code, err := qrcode.New(frame, qrcode.Low)
+		if err != nil {
+			return err
+		}
+		fmt.Print(code.ToSmallString(false))
+		fmt.Printf("Frame %d/%d\n", i+1, len(frames))
+		if interactive && i < len(frames)-1 {
+			fmt.Fprint(os.Stderr, "Press Enter once scanned...")
+			if _, err := stdin.ReadString('\n'); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readFrames reads the frames of a payload on stdin, scanned or pasted one per line, and decodes it
+// into v
+func readFrames(kind string, v interface{}) error {
+	fmt.Fprintf(os.Stderr, "Scan or paste the frames of the %v, one per line:\n", map[string]string{
+		canarytail.AirgapRequest:   "signing request",
+		canarytail.AirgapSignature: "signature",
+	}[kind])
+	decoder := &canarytail.AirgapDecoder{Kind: kind}
+	for !decoder.Done() {
+		line, err := stdin.ReadString('\n')
+		if canarytail.IsAirgapFrame(line) {
+			if _, err := decoder.Add(line); err != nil {
+				fmt.Fprintf(os.Stderr, "Skipped the frame: %v\n", err)
+			} else {
+				received, total := decoder.Progress()
+				fmt.Fprintf(os.Stderr, "Received %d/%d frames\n", received, total)
+			}
+		}
+		if err == io.EOF && !decoder.Done() {
+			received, total := decoder.Progress()
+			if total == 0 {
+				return errors.New("no frames on stdin")
+			}
+			return fmt.Errorf("stdin ended after %d of %d frames", received, total)
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return decoder.Decode(v)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	canarytail "github.com/canarytail/client"
+)
+
+func readSigningRequest(path string) (*canarytail.SigningRequest, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	request := &canarytail.SigningRequest{}
+	if err := json.Unmarshal(content, request); err != nil {
+		return nil, fmt.Errorf("invalid signing request in %v: %v", path, err)
+	}
+	return request, request.Verify()
+}
+
+func readDetachedSignature(path string) (*canarytail.DetachedSignature, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signature := &canarytail.DetachedSignature{}
+	if err := json.Unmarshal(content, signature); err != nil {
+		return nil, fmt.Errorf("invalid detached signature in %v: %v", path, err)
+	}
+	return signature, nil
+}
+
+type canaryRequestCmd struct {
+	Domain string `arg name:"DOMAIN" help:"Domain of the canary"`
+	Out    string `name:"out" help:"Write the signing request to FILE (default: canary.DOMAIN.request.json in the current directory)"`
+
+	airgapOpts
+}
+
+func (cmd *canaryRequestCmd) Run(ctx *context) error {
+	dir := canaryDirSafe(cmd.Domain)
+	fileName, err := getLatestCanaryFileName(dir)
+	if err != nil {
+		return err
+	}
+	canary, err := readCanaryFile(path.Join(dir, fileName))
+	if err != nil {
+		return err
+	}
+	request := canarytail.NewSigningRequest(canary)
+	if len(request.Signers) == 0 {
+		return fmt.Errorf("every signer has signed the latest canary of %v", cmd.Domain)
+	}
+
+	if cmd.Airgap {
+		fmt.Printf("The signing request for the claim %v:\n", request.Hash)
+		if err := cmd.showFrames(canarytail.AirgapRequest, request); err != nil {
+			return err
+		}
+		fmt.Printf("Sign it on the offline machine with 'canary sign --airgap', and import the signature with 'canary merge %v --airgap'.\n", cmd.Domain)
+		return nil
+	}
+
+	out := cmd.Out
+	if out == "" {
+		out = fmt.Sprintf("canary.%s.request.json", cmd.Domain)
+	}
+	if err := writeJSONFile(out, request); err != nil {
+		return err
+	}
+	fmt.Printf("The signing request for the claim %v is stored at %v. Send it to:\n", request.Hash, out)
+	for _, k := range request.Signers {
+		required := ""
+		if k.Required {
+			required = " (required)"
+		}
+		fmt.Printf("  %v %v%v\n", k.Name, k.Key, required)
+	}
+	fmt.Printf("and merge the signatures they send back with 'canary merge %v'.\n", cmd.Domain)
+	return nil
+}
+
+// signBundle signs a signing request with the key of DOMAIN, and writes the detached signature
+func (cmd *canarySignCmd) signBundle() error {
+	request := &canarytail.SigningRequest{}
+	var err error
+	if cmd.Airgap {
+		if err := readFrames(canarytail.AirgapRequest, request); err != nil {
+			return err
+		}
+		err = request.Verify()
+	} else {
+		request, err = readSigningRequest(cmd.Path)
+	}
+	if err != nil {
+		return err
+	}
+	if err := cmd.review(request.Canary()); err != nil {
+		return err
+	}
+	role := canarytail.SigningKeyRole
+	if cmd.Panic {
+		role = canarytail.PanicKeyRole
+	}
+	signer, publicSigningKey, err := cmd.signer(request.Claim.Domain, role)
+	if err != nil {
+		return err
+	}
+	// the panic key still signs, but the duress code cannot be dropped from a claim signed apart
+	if s, ok := signer.(duressSigner); ok {
+		signer = s.keepCode()
+	}
+
+	fmt.Printf("Signing the claim %v...\n", request.Hash)
+	signature, err := request.Sign(signer)
+	if err != nil {
+		return err
+	}
+	if err := recordSigned(request.Canary()); err != nil {
+		return err
+	}
+	if cmd.Airgap {
+		fmt.Println("The signature:")
+		if err := cmd.showFrames(canarytail.AirgapSignature, signature); err != nil {
+			return err
+		}
+		fmt.Printf("Import it on the online machine with 'canary merge %v --airgap'.\n", request.Claim.Domain)
+		return nil
+	}
+
+	out := cmd.Out
+	if out == "" {
+		name := canarytail.KeyFingerprint(publicSigningKey)
+		for _, k := range request.Claim.PublicKeys {
+			if k.Key == canarytail.FormatKey(publicSigningKey) && k.Name != "" {
+				name = k.Name
+			}
+		}
+		out = fmt.Sprintf("%s.%s.sig.json", strings.TrimSuffix(cmd.Path, ".json"), strings.NewReplacer("/", "_", ":", "_").Replace(name))
+	}
+	if err := writeJSONFile(out, signature); err != nil {
+		return err
+	}
+	fmt.Printf("The detached signature is stored at %v, send it back to the %s.\n", out, canarytail.RoleAuthor)
+	return nil
+}
+
+type canaryMergeCmd struct {
+	Domain     string   `arg name:"DOMAIN" help:"Domain of the canary"`
+	Signatures []string `arg optional name:"SIGNATURE" help:"Detached signatures written by 'canary sign --bundle'"`
+	Airgap     bool     `name:"airgap" help:"Read the signature of 'canary sign --airgap' on stdin, scanned or pasted"`
+
+	tsaOpts
+}
+
+func (cmd *canaryMergeCmd) Run(ctx *context) error {
+	dir := canaryDirSafe(cmd.Domain)
+	fileName, err := getLatestCanaryFileName(dir)
+	if err != nil {
+		return err
+	}
+	canary, err := readCanaryFile(path.Join(dir, fileName))
+	if err != nil {
+		return err
+	}
+
+	if len(cmd.Signatures) == 0 && !cmd.Airgap {
+		return errors.New("expected detached signatures, or --airgap")
+	}
+	if cmd.Airgap {
+		signature := canarytail.DetachedSignature{}
+		if err := readFrames(canarytail.AirgapSignature, &signature); err != nil {
+			return err
+		}
+		if err := canary.Merge(signature); err != nil {
+			return fmt.Errorf("Could not merge the signature: %v", err)
+		}
+		fmt.Println("Merged the signature read on stdin")
+	}
+	for _, fp := range cmd.Signatures {
+		signature, err := readDetachedSignature(fp)
+		if err != nil {
+			return err
+		}
+		if err := canary.Merge(*signature); err != nil {
+			return fmt.Errorf("Could not merge %v: %v", fp, err)
+		}
+		fmt.Printf("Merged the signature of %v\n", fp)
+	}
+	if err := countersign(&canary, cmd.TSA); err != nil {
+		return err
+	}
+
+	canaryFormatted := canary.Format()
+	if err := writeToFile(path.Join(dir, fileName), canaryFormatted); err != nil {
+		return err
+	}
+	if err := writeToFile(path.Join(dir, canaryLatestFileName(cmd.Domain)), canaryFormatted); err != nil {
+		return err
+	}
+	fmt.Printf("The canary of %v has %d signature(s) of %d signer(s), %d required at least.\n", cmd.Domain, len(canary.Signatures), len(canary.Claim.PublicKeys), canary.Claim.MinSigners)
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	canarytail "github.com/canarytail/client"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -38,3 +40,19 @@ func TestGetLatestCanaryFileName(t *testing.T) {
 		require.Equal(t, exp, act)
 	})
 }
+
+func TestSetFreshnessNegativeProofHeaders(t *testing.T) {
+	canary := canarytail.Canary{}
+	require.Error(t, setFreshness(freshnessOpts{ProofHeaders: -1}, &canary))
+	require.Empty(t, canary.Claim.Freshness, "no block is fetched")
+}
+
+func TestSetFreshnessBackendDown(t *testing.T) {
+	backend := canarytail.DefaultBlockBackend
+	t.Cleanup(func() { canarytail.DefaultBlockBackend = backend })
+	canarytail.DefaultBlockBackend = canarytail.NewEsploraBackend("http://127.0.0.1:1")
+
+	canary := canarytail.Canary{}
+	require.Error(t, setFreshness(freshnessOpts{}, &canary), "no canary without freshness")
+	require.Empty(t, canary.Claim.Freshness)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+)
+
+// deadmanFileName is the state of the dead man's switch of a domain, in $CANARY_HOME/DOMAIN
+const deadmanFileName = "deadman.json"
+
+func writeDeadmanState(d *canarytail.DeadmanSwitch) error {
+	var buf bytes.Buffer
+	if err := d.Encode(&buf); err != nil {
+		return err
+	}
+	return writeToFile(path.Join(canaryDir(d.Domain), deadmanFileName), buf.String())
+}
+
+// runHook runs the command of a hook with the arguments appended, if any
+func runHook(hook string, args ...string) error {
+	command := strings.Fields(hook)
+	if len(command) == 0 {
+		return nil
+	}
+	cmd := exec.Command(command[0], append(command[1:], args...)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+type deadmanServeCmd struct {
+	Domain  string   `arg name:"DOMAIN" help:"Domain of the canary"`
+	Listen  string   `name:"listen" help:"Address to listen for the check-ins of the author on" default:"127.0.0.1:8089"`
+	Window  int      `name:"window" help:"Minutes the author has to check in after the last check-in, before the canary is tripped (default: 10080, one week)" default:"10080"`
+	Grace   int      `name:"grace" help:"Minutes before the deadline the author is warned at (default: 1440, one day)" default:"1440"`
+	Canary  string   `name:"canary" help:"Canary to trip, as a file or URL, e.g. the published canary (default: the latest canary at $CANARY_HOME/DOMAIN)"`
+	Codes   []string `name:"trip-codes" help:"Codes to drop from the tripped canary, on top of signing it with the panic key (e.g. duress)"`
+	Warn    string   `name:"warn" help:"Command run with DOMAIN and the deadline once the grace period starts, e.g. to mail the author"`
+	Publish string   `name:"publish" help:"Command run with the path of the tripped canary, to publish it"`
+
+	freshnessOpts
+	tsaOpts
+}
+
+func (cmd *deadmanServeCmd) Run(ctx *context) error {
+	if cmd.Window <= 0 || cmd.Grace < 0 || cmd.Grace >= cmd.Window {
+		return errors.New("the grace period must be shorter than the window")
+	}
+	for _, code := range cmd.Codes {
+		if !containsString(canarytail.AllCodes(), code) {
+			return fmt.Errorf("unknown code %q, expected some of %v", code, strings.Join(canarytail.AllCodes(), ", "))
+		}
+	}
+	canary, err := cmd.readCanary()
+	if err != nil {
+		return err
+	}
+
+	// the panic key of the domain is unlocked once: moved to the host of the daemon, only the daemon holds it
+	signer, panicKey, err := domainSigner(cmd.Domain, canarytail.PanicKeyRole)
+	if err != nil {
+		return err
+	}
+	if canarytail.FormatKey(panicKey) != canary.Claim.PanicKey {
+		return fmt.Errorf("the panic key %v is not the panic key of the canary of %v", canarytail.KeyFingerprint(panicKey), cmd.Domain)
+	}
+
+	d, err := cmd.loadSwitch(canary)
+	if err != nil {
+		return err
+	}
+	if err := writeDeadmanState(d); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", cmd.Listen)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: d.Handler(func() {
+		fmt.Printf("%v: the author of %v checked in, the canary is tripped at %v\n", time.Now().UTC().Format(canarytail.TimestampLayout), d.Domain, d.Deadline().Format(canarytail.TimestampLayout))
+		if err := writeDeadmanState(d); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not store the state of the switch: %v\n", err)
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	interval := time.Duration(d.WindowMinutes) * time.Minute / 10
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	fmt.Printf("Waiting for the check-ins of the author of %v on %v, the canary is tripped at %v\n", cmd.Domain, listener.Addr(), d.Deadline().Format(canarytail.TimestampLayout))
+	for {
+		select {
+		case <-stop:
+			fmt.Println("Stopped.")
+			return writeDeadmanState(d)
+		case now := <-ticker.C:
+			switch d.Due(now) {
+			case canarytail.DeadmanWarn:
+				deadline := d.Deadline().Format(canarytail.TimestampLayout)
+				fmt.Printf("%v: WARNING: the author of %v has not checked in, the canary is tripped at %v\n", now.UTC().Format(canarytail.TimestampLayout), cmd.Domain, deadline)
+				if err := writeDeadmanState(d); err != nil {
+					return err
+				}
+				if err := runHook(cmd.Warn, cmd.Domain, deadline); err != nil {
+					fmt.Fprintf(os.Stderr, "The warn command failed: %v\n", err)
+				}
+			case canarytail.DeadmanTrip:
+				if err := writeDeadmanState(d); err != nil {
+					return err
+				}
+				return cmd.trip(signer, now, d.WindowMinutes)
+			}
+		}
+	}
+}
+
+// readCanary reads the canary the daemon trips: the published canary, or the latest one of the domain
+func (cmd *deadmanServeCmd) readCanary() (canarytail.Canary, error) {
+	if cmd.Canary != "" {
+		return canarytail.Read(cmd.Canary)
+	}
+	dir := canaryDirSafe(cmd.Domain)
+	fileName, err := getLatestCanaryFileName(dir)
+	if err != nil {
+		return canarytail.Canary{}, fmt.Errorf("no canary of %v to trip: %v", cmd.Domain, err)
+	}
+	return readCanaryFile(path.Join(dir, fileName))
+}
+
+// loadSwitch resumes the switch of the domain, or arms it from now on
+func (cmd *deadmanServeCmd) loadSwitch(canary canarytail.Canary) (*canarytail.DeadmanSwitch, error) {
+	d := &canarytail.DeadmanSwitch{
+		Domain:      cmd.Domain,
+		LastCheckIn: time.Now().UTC().Format(canarytail.TimestampLayout),
+	}
+	fp := path.Join(canaryDir(cmd.Domain), deadmanFileName)
+	content, err := ioutil.ReadFile(fp)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, d); err != nil {
+			return nil, fmt.Errorf("invalid state of the dead man's switch in %v: %v", fp, err)
+		}
+		if d.Tripped {
+			return nil, fmt.Errorf("the dead man's switch of %v tripped the canary: remove %v to arm it again", cmd.Domain, fp)
+		}
+	}
+	// restarting the daemon never defers the deadline, but a rotated author key checks in from now on
+	d.AuthorKey = canary.AuthorKey()
+	if err == nil && d.WindowMinutes > 0 && cmd.Window > d.WindowMinutes {
+		fmt.Printf("The window of the switch of %v stays %d minutes: remove %v to lengthen it.\n", cmd.Domain, d.WindowMinutes, fp)
+		return d, nil
+	}
+	d.WindowMinutes, d.GraceMinutes = cmd.Window, cmd.Grace
+	return d, nil
+}
+
+// trip publishes the canary signed with the panic key, without the trip codes, valid for the window of
+// the switch
+func (cmd *deadmanServeCmd) trip(signer crypto.Signer, now time.Time, window int) error {
+	canary, err := cmd.readCanary()
+	if err != nil {
+		return err
+	}
+	canary.Claim.Release = now.Format(canarytail.TimestampLayout)
+	canary.Claim.Expiry = now.Add(time.Duration(window) * time.Minute).Format(canarytail.TimestampLayout)
+	codes := canary.Claim.Codes[:0]
+	for _, code := range canary.Claim.Codes {
+		if !containsString(cmd.Codes, code) {
+			codes = append(codes, code)
+		}
+	}
+	canary.Claim.Codes = codes
+	if err := setFreshness(cmd.freshnessOpts, &canary); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not refresh the freshness of the tripped canary: %v\n", err)
+	}
+	canary.Signatures, canary.Delegation = nil, nil
+	canary.TimestampToken, canary.OpenTimestamps = "", ""
+	if err := canary.Sign(signer); err != nil {
+		return err
+	}
+	if err := countersign(&canary, cmd.TSA); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not countersign the tripped canary: %v\n", err)
+	}
+
+	dir := canaryDirSafe(cmd.Domain)
+	canaryFormatted := canary.Format()
+	if err := writeToFile(path.Join(dir, canaryFileName(cmd.Domain, now)), canaryFormatted); err != nil {
+		return err
+	}
+	fp := path.Join(dir, canaryLatestFileName(cmd.Domain))
+	if err := writeToFile(fp, canaryFormatted); err != nil {
+		return err
+	}
+	fmt.Printf("%v: the author of %v missed the deadline, the canary signed with the panic key is stored at %q\n", now.UTC().Format(canarytail.TimestampLayout), cmd.Domain, fp)
+	return runHook(cmd.Publish, fp)
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+type deadmanCheckinCmd struct {
+	Domain string `arg name:"DOMAIN" help:"Domain of the canary"`
+	URL    string `name:"url" help:"URL of the dead man's switch daemon" default:"http://127.0.0.1:8089"`
+}
+
+func (cmd *deadmanCheckinCmd) Run(ctx *context) error {
+	if err := refuseSignerDaemon("check-ins"); err != nil {
+		return err
+	}
+	signer, _, err := domainSigner(cmd.Domain, canarytail.SigningKeyRole)
+	if err != nil {
+		return err
+	}
+	checkIn, err := canarytail.NewCheckIn(cmd.Domain, signer, time.Now())
+	if err != nil {
+		return err
+	}
+	status, err := canarytail.SendCheckIn(cmd.URL, checkIn)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Checked in with the dead man's switch of %v, check in again before %v\n", cmd.Domain, status.Deadline().Format(canarytail.TimestampLayout))
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadmanResume(t *testing.T) {
+	t.Setenv("CANARY_HOME", t.TempDir())
+	domain := "mydomain.com"
+	canaryDirSafe(domain)
+	canary := canarytail.Canary{Claim: canarytail.CanaryClaim{Domain: domain}}
+
+	d, err := (&deadmanServeCmd{Domain: domain, Window: 120, Grace: 30}).loadSwitch(canary)
+	require.NoError(t, err)
+	deadline := d.Deadline()
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), deadline, time.Minute)
+	require.NoError(t, writeDeadmanState(d))
+
+	// restarted with a longer window, the switch keeps its deadline
+	d, err = (&deadmanServeCmd{Domain: domain, Window: 240, Grace: 30}).loadSwitch(canary)
+	require.NoError(t, err)
+	assert.Equal(t, 120, d.WindowMinutes)
+	assert.Equal(t, deadline, d.Deadline())
+
+	// a shorter one applies
+	d, err = (&deadmanServeCmd{Domain: domain, Window: 60, Grace: 10}).loadSwitch(canary)
+	require.NoError(t, err)
+	assert.Equal(t, 60, d.WindowMinutes)
+	assert.Equal(t, 10, d.GraceMinutes)
+	assert.Equal(t, deadline.Add(-time.Hour), d.Deadline())
+}
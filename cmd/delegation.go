@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+)
+
+// delegationFileName is the delegation of the delegated key of a domain, in $CANARY_HOME/DOMAIN. It is
+// embedded in the canaries the delegated key renews.
+const delegationFileName = "delegation.json"
+
+func readDelegation(domain string) (*canarytail.Delegation, error) {
+	fp := path.Join(canaryDir(domain), delegationFileName)
+	content, err := ioutil.ReadFile(fp)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no delegation for %v, use 'key delegate' to create one", domain)
+	}
+	if err != nil {
+		return nil, err
+	}
+	delegation := &canarytail.Delegation{}
+	if err := json.Unmarshal(content, delegation); err != nil {
+		return nil, fmt.Errorf("invalid delegation in %v: %v", fp, err)
+	}
+	return delegation, delegation.Verify()
+}
+
+type keyDelegateCmd struct {
+	Domain      string   `arg name:"DOMAIN" help:"Domain of the canary"`
+	Name        string   `name:"name" help:"Name of the delegated key, e.g. the host renewing the canary" default:"renew"`
+	Fields      []string `name:"fields" help:"Claim fields the delegated key may change: release, expiry, freshness, headlines or mirrors" default:"release,expiry,freshness"`
+	Days        int      `name:"days" help:"Number of days until the delegation expires" default:"90"`
+	Key         string   `name:"key" help:"Delegate to this public key, in base64, instead of generating a delegated key pair"`
+	Unencrypted bool     `name:"unencrypted" help:"Store the delegated private key in plain base64 instead of encrypting it under a passphrase"`
+}
+
+func (cmd *keyDelegateCmd) Run(ctx *context) error {
+	if err := refuseSignerDaemon("delegations"); err != nil {
+		return err
+	}
+	dir := canaryDirSafe(cmd.Domain)
+	author, authorKey, err := domainSigner(cmd.Domain, canarytail.SigningKeyRole)
+	if err != nil {
+		return err
+	}
+
+	var publicKey ed25519.PublicKey
+	var privateKey ed25519.PrivateKey
+	if cmd.Key != "" {
+		if publicKey, err = canarytail.ParsePublicKey(cmd.Key); err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid public key %q", cmd.Key)
+		}
+	} else if publicKey, privateKey, err = canarytail.GenerateKeyPair(); err != nil {
+		return fmt.Errorf("Could not generate key pair: %v", err)
+	}
+
+	delegation, err := canarytail.NewDelegation(cmd.Domain, cmd.Name, publicKey, cmd.Fields, time.Now().AddDate(0, 0, cmd.Days))
+	if err != nil {
+		return err
+	}
+	if err := delegation.Sign(author); err != nil {
+		return err
+	}
+
+	// the previous delegated key is kept in the archive, so that the canaries it renewed can be told apart
+	if previous, err := readKeyFile(cmd.Domain, canarytail.DelegateKeyRole); err != nil {
+		return err
+	} else if previous != nil {
+		if _, err := archiveKey(cmd.Domain, canarytail.DelegateKeyRole, "replaced by key delegate"); err != nil {
+			return err
+		}
+	}
+	if privateKey != nil {
+		var passphrase []byte
+		if !cmd.Unencrypted {
+			if passphrase, err = newPassphrase(); err != nil {
+				return err
+			}
+		}
+		if err := writeToFile(path.Join(dir, keyFileNames[canarytail.DelegateKeyRole][0]), canarytail.FormatKey(publicKey)); err != nil {
+			return err
+		}
+		if err := writePrivateKeyFile(path.Join(dir, keyFileNames[canarytail.DelegateKeyRole][1]), privateKey, passphrase); err != nil {
+			return err
+		}
+	}
+	if err := writeJSONFile(path.Join(dir, delegationFileName), delegation); err != nil {
+		return err
+	}
+
+	fmt.Printf("Delegated %v of the canary of %v to %q (%v) until %v, certified by %v\n", delegation.Fields, cmd.Domain, cmd.Name, canarytail.KeyFingerprint(publicKey), delegation.Expiry, canarytail.KeyFingerprint(authorKey))
+	files := []string{delegationFileName}
+	if privateKey != nil {
+		files = append(files, keyFileNames[canarytail.DelegateKeyRole][0], keyFileNames[canarytail.DelegateKeyRole][1])
+	}
+	fmt.Printf("Copy %v from %v to $CANARY_HOME/%v on the renewing host, with the latest canary, and run 'canary renew %v' there.\n", files, dir, cmd.Domain, cmd.Domain)
+	if privateKey != nil {
+		warnStaleDuress(cmd.Domain)
+	}
+	return nil
+}
+
+type canaryRenewCmd struct {
+	Domain string `arg name:"DOMAIN"`
+	Expiry int    `name:"expiry" help:"Expires in # minutes from now, within the expiry of the delegation (default: 1440, one day)" default:"1440"`
+
+	freshnessOpts
+	tsaOpts
+}
+
+func (cmd *canaryRenewCmd) Run(ctx *context) error {
+	dir := canaryDirSafe(cmd.Domain)
+	fileName, err := getLatestCanaryFileName(dir)
+	if err != nil {
+		return err
+	}
+	previous, err := readCanaryFile(path.Join(dir, fileName))
+	if err != nil {
+		return err
+	}
+	delegation, err := readDelegation(cmd.Domain)
+	if err != nil {
+		return err
+	}
+	if delegation.AuthorKey != previous.AuthorKey() {
+		return fmt.Errorf("the delegation to %q is not certified by the author key of the latest canary", delegation.Name)
+	}
+	signer, publicKey, err := domainSigner(cmd.Domain, canarytail.DelegateKeyRole)
+	if err != nil {
+		return err
+	}
+	if canarytail.FormatKey(publicKey) != delegation.Key {
+		return fmt.Errorf("the delegated key of %v is not the key of the delegation to %q", cmd.Domain, delegation.Name)
+	}
+
+	// only the delegated fields are renewed, the rest is left as the previous canary has it
+	canary := previous
+	canaryTime := time.Now()
+	if !delegation.ExpiryTimestamp().After(canaryTime) {
+		return fmt.Errorf("the delegation to %q expired on %v, the author must renew it with 'key delegate'", delegation.Name, delegation.Expiry)
+	}
+	if delegation.Delegates("release") {
+		canary.Claim.Release = canaryTime.Format(canarytail.TimestampLayout)
+	}
+	if delegation.Delegates("expiry") {
+		expiry := canaryTime.Add(time.Duration(cmd.Expiry) * time.Minute)
+		if expiry.After(delegation.ExpiryTimestamp()) {
+			fmt.Printf("The delegation to %q expires on %v, so does the canary.\n", delegation.Name, delegation.Expiry)
+			expiry = delegation.ExpiryTimestamp()
+		}
+		canary.Claim.Expiry = expiry.Format(canarytail.TimestampLayout)
+	}
+	if delegation.Delegates("freshness") {
+		opts := cmd.freshnessOpts
+		if !delegation.Delegates("headlines") {
+			opts.Headlines = 0
+		}
+		headlines := canary.Claim.Headlines
+		if err := setFreshness(opts, &canary); err != nil {
+			return err
+		}
+		if !delegation.Delegates("headlines") {
+			canary.Claim.Headlines = headlines
+		}
+	}
+
+	canary.Delegation = delegation
+	canary.Signatures = nil
+	canary.TimestampToken, canary.OpenTimestamps = "", ""
+	if err := canary.Sign(signer); err != nil {
+		return err
+	}
+	if err := countersign(&canary, cmd.TSA); err != nil {
+		return err
+	}
+
+	// validators check the renewed canary against the previous one, and so does the renewing host
+	validator := canarytail.NewCanaryValidator(canary)
+	validator.Previous = &previous
+	report, err := validator.Report()
+	if err != nil {
+		return err
+	}
+	for _, r := range report {
+		if r.Signer.Role == canarytail.RoleAuthor && r.Status != canarytail.SignatureDelegated {
+			return fmt.Errorf("the renewed canary is not signed in place of the author: %v", r.Status)
+		}
+	}
+	if err := canary.CheckDelegatedChanges(previous); err != nil {
+		return err
+	}
+
+	canaryFormatted := canary.Format()
+	fp := path.Join(dir, canaryFileName(canary.Claim.Domain, canaryTime))
+	if err := writeToFile(fp, canaryFormatted); err != nil {
+		return err
+	}
+	fp = path.Join(dir, canaryLatestFileName(canary.Claim.Domain))
+	if err := writeToFile(fp, canaryFormatted); err != nil {
+		return err
+	}
+	absFp, err := filepath.Abs(fp)
+	if err != nil {
+		absFp = fp
+	}
+	fmt.Printf("Renewed canary has been stored at %q, signed by the delegated key %q\n", absFp, delegation.Name)
+	return nil
+}
+
+// undelegate drops the delegation and signature of the delegated key from a canary the author updates
+func undelegate(canary *canarytail.Canary) {
+	if canary.Delegation == nil {
+		return
+	}
+	delete(canary.Signatures, canary.Delegation.Key)
+	canary.Delegation = nil
+}
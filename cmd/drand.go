@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	canarytail "github.com/canarytail/client"
+)
+
+// defaultDrandURL is the HTTP endpoint of the League of Entropy mainnet
+const defaultDrandURL = "https://api.drand.sh"
+
+func drandChainInfoPath() string {
+	return path.Join(canaryHomeDir(), "drand.json")
+}
+
+func loadDrandChainInfo() (*canarytail.DrandChainInfo, error) {
+	info, err := canarytail.LoadDrandChainInfo(drandChainInfoPath())
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no drand chain info found, use 'drand init' to fetch it")
+	}
+	return info, err
+}
+
+type drandInitCmd struct {
+	URL       string `arg optional name:"URL" help:"HTTP endpoint of the drand beacon (default: https://api.drand.sh)"`
+	ChainHash string `name:"chain-hash" help:"Hash of the drand chain to expect, from a source you trust"`
+}
+
+func (cmd *drandInitCmd) Run(ctx *context) error {
+	url := cmd.URL
+	if url == "" {
+		url = defaultDrandURL
+	}
+	fmt.Printf("Fetching the drand chain info from %v...\n", url)
+	info, err := canarytail.FetchDrandChainInfo(url, cmd.ChainHash)
+	if err != nil {
+		return err
+	}
+	if cmd.ChainHash == "" {
+		fmt.Printf("WARNING: the chain info is trusted as served, check its hash %s against a source you trust.\n", info.Hash)
+	}
+
+	if err := os.MkdirAll(canaryHomeDir(), 0700); err != nil {
+		return err
+	}
+	if err := info.Save(drandChainInfoPath()); err != nil {
+		return err
+	}
+	fmt.Printf("Stored the info of drand chain %s at %q\n", info.Hash, drandChainInfoPath())
+	return nil
+}
+
+type drandStatusCmd struct {
+}
+
+func (cmd *drandStatusCmd) Run(ctx *context) error {
+	info, err := loadDrandChainInfo()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Chain: %s\nURL: %s\nScheme: %s\nPeriod: %ds\nGenesis: %v\n",
+		info.Hash, info.URL, info.SchemeID, info.Period, info.RoundTime(0))
+	return nil
+}
+
+// drandFreshness picks the latest drand round as freshness, with its beacon as freshness proof
+func drandFreshness(cmd freshnessOpts) (string, *canarytail.FreshnessProof, error) {
+	info, err := loadDrandChainInfo()
+	if err != nil {
+		return "", nil, err
+	}
+	beacon, err := canarytail.FetchDrandBeacon(info.URL, 0)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not fetch the latest drand round: %v", err)
+	}
+	if err := info.Verify(beacon); err != nil {
+		return "", nil, err
+	}
+
+	freshness := canarytail.FormatDrandFreshness(beacon)
+	if cmd.NoFreshnessProof {
+		return freshness, nil, nil
+	}
+	return freshness, &canarytail.FreshnessProof{Drand: beacon}, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	canarytail "github.com/canarytail/client"
+
+	"golang.org/x/term"
+)
+
+// duressFileName holds the signing and panic keys of a domain, and its delegated key if any, encrypted
+// under its duress passphrase, in $CANARY_HOME/DOMAIN. It is an encrypted key file like the others.
+const duressFileName = "duress-private.b64"
+
+// duressDropCode is the flag of the duress key file that drops the duress code from the canary
+const duressDropCode = 1
+
+// duressKeys are the keys unlocked by the duress passphrase
+type duressKeys struct {
+	signing, panic, delegate ed25519.PrivateKey
+	dropCode                 bool
+	// dir is the directory of the keys of the domain
+	dir string
+}
+
+// duress is set when the duress passphrase was entered in place of the passphrase of the signing key
+var duress *duressKeys
+
+// openDuress decrypts the duress key file next to the signing key file keyPath, if any. It fails
+// silently, as a wrong passphrase would.
+func openDuress(keyPath string, passphrase []byte) *duressKeys {
+	if path.Base(keyPath) != keyFileNames[canarytail.SigningKeyRole][1] {
+		return nil
+	}
+	content, err := ioutil.ReadFile(path.Join(path.Dir(keyPath), duressFileName))
+	if err != nil {
+		return nil
+	}
+	plain, err := canarytail.DecryptPrivateKey(content, passphrase)
+	if err != nil || (len(plain) != 2*ed25519.PrivateKeySize+1 && len(plain) != 3*ed25519.PrivateKeySize+1) {
+		return nil
+	}
+	keys := &duressKeys{
+		signing:  ed25519.PrivateKey(plain[:ed25519.PrivateKeySize]),
+		panic:    ed25519.PrivateKey(plain[ed25519.PrivateKeySize : 2*ed25519.PrivateKeySize]),
+		dropCode: plain[2*ed25519.PrivateKeySize]&duressDropCode != 0,
+		dir:      path.Dir(keyPath),
+	}
+	if len(plain) == 3*ed25519.PrivateKeySize+1 {
+		keys.delegate = ed25519.PrivateKey(plain[2*ed25519.PrivateKeySize+1:])
+	}
+	// keys replaced since the duress passphrase was set must not sign
+	publicKey, err := readPublicKey(path.Dir(keyPath))
+	if err != nil || !bytes.Equal(publicKey, keys.signing.Public().(ed25519.PublicKey)) {
+		return nil
+	}
+	return keys
+}
+
+// keyFile returns the copy of the panic or delegated key stored at keyPath, so that the keys of the domain
+// read after the signing key resolve under duress as they do under its passphrase. It returns nil for other
+// key files, and for keys replaced since the duress passphrase was set.
+func (k *duressKeys) keyFile(keyPath string) ed25519.PrivateKey {
+	if path.Dir(keyPath) != k.dir {
+		return nil
+	}
+	for role, key := range map[canarytail.KeyRole]ed25519.PrivateKey{canarytail.PanicKeyRole: k.panic, canarytail.DelegateKeyRole: k.delegate} {
+		if key == nil || path.Base(keyPath) != keyFileNames[role][1] {
+			continue
+		}
+		content, err := ioutil.ReadFile(path.Join(k.dir, keyFileNames[role][0]))
+		if err != nil {
+			return nil
+		}
+		publicKey, err := canarytail.ParsePublicKey(strings.TrimSpace(string(content)))
+		if err != nil || !bytes.Equal(publicKey, key.Public().(ed25519.PublicKey)) {
+			return nil
+		}
+		return key
+	}
+	return nil
+}
+
+// warnStaleDuress warns that the duress passphrase of a domain unlocks a replaced signing or delegated
+// key, which does not sign anymore
+func warnStaleDuress(domain string) {
+	if _, err := os.Stat(path.Join(canaryDir(domain), duressFileName)); err == nil {
+		fmt.Printf("The duress passphrase of %v is set for the replaced key: run 'key duress %v' again.\n", domain, domain)
+	}
+}
+
+// duressSigner signs canaries with the signing key as usual, and with the panic key too
+type duressSigner struct {
+	*duressKeys
+}
+
+// keepCode returns the signer, unless it drops the duress code: then the same signer keeping it. A
+// detached signature is bound to the claim of its signing request, which the signer cannot change.
+func (s duressSigner) keepCode() duressSigner {
+	if !s.dropCode {
+		return s
+	}
+	keys := *s.duressKeys
+	keys.dropCode = false
+	return duressSigner{&keys}
+}
+
+func (s duressSigner) Public() crypto.PublicKey {
+	return s.signing.Public()
+}
+
+// Sign signs anything but canaries as the signing key would
+func (s duressSigner) Sign(rand io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.signing.Sign(rand, message, opts)
+}
+
+func (s duressSigner) SignCanary(c *canarytail.Canary) error {
+	if s.dropCode {
+		// the codes may be shared with the claim of a signing request
+		codes := make([]string, 0, len(c.Claim.Codes))
+		for _, code := range c.Claim.Codes {
+			if code != "duress" {
+				codes = append(codes, code)
+			}
+		}
+		c.Claim.Codes = codes
+	}
+	if err := c.Sign(s.signing); err != nil {
+		return err
+	}
+	return c.Sign(s.panic)
+}
+
+// readDuressPassphrase reads the duress passphrase twice on the terminal, or once on stdin
+func readDuressPassphrase() ([]byte, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := stdin.ReadBytes('\n')
+		if err != nil && len(line) == 0 {
+			return nil, fmt.Errorf("Could not read the duress passphrase from stdin: %v", err)
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+	passphrase, err := promptSecret("Duress passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	confirmation, err := promptSecret("Repeat the duress passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, confirmation) {
+		return nil, errors.New("the passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+type keyDuressCmd struct {
+	Domain   string `arg name:"DOMAIN" help:"Domain of the canary"`
+	DropCode bool   `name:"drop-duress-code" help:"Also drop the duress code from canaries signed under the duress passphrase, but for signing requests signed with --bundle or --airgap"`
+	Remove   bool   `name:"remove" help:"Remove the duress passphrase of DOMAIN"`
+}
+
+func (cmd *keyDuressCmd) Run(ctx *context) error {
+	if _, ok := keyStore.(fileKeyStore); !ok {
+		return errors.New("only keys stored in $CANARY_HOME can have a duress passphrase")
+	}
+	dir := canaryDirSafe(cmd.Domain)
+	if cmd.Remove {
+		if err := os.Remove(path.Join(dir, duressFileName)); err != nil {
+			return err
+		}
+		fmt.Printf("Removed the duress passphrase of %v\n", cmd.Domain)
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(path.Join(dir, keyFileNames[canarytail.SigningKeyRole][1]))
+	if err != nil {
+		return err
+	}
+	if !canarytail.IsEncryptedKey(content) {
+		return fmt.Errorf("the private keys of %v are not encrypted, use 'key encrypt' first", cmd.Domain)
+	}
+	signing, err := readPrivateKey(dir)
+	if err != nil {
+		return err
+	}
+	panicKey, err := readPrivateKeyFile(path.Join(dir, keyFileNames[canarytail.PanicKeyRole][1]))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("the panic key of %v is not stored in %v, e.g. split into shares: the duress passphrase needs it", cmd.Domain, dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the delegated key, if any, resolves under duress too
+	delegateKey, err := readPrivateKeyFile(path.Join(dir, keyFileNames[canarytail.DelegateKeyRole][1]))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	passphrase, err := readDuressPassphrase()
+	if err != nil {
+		return err
+	}
+	if len(passphrase) == 0 {
+		return errors.New("the passphrase cannot be empty")
+	}
+	if _, err := canarytail.DecryptPrivateKey(content, passphrase); err != canarytail.ErrWrongPassphrase {
+		return errors.New("the duress passphrase must differ from the passphrase of the keys")
+	}
+
+	var flags byte
+	if cmd.DropCode {
+		flags |= duressDropCode
+	}
+	plain := append(append(append(append([]byte(nil), signing...), panicKey...), flags), delegateKey...)
+	encrypted, err := canarytail.EncryptPrivateKey(plain, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := writeToFile(path.Join(dir, duressFileName), string(encrypted)); err != nil {
+		return err
+	}
+	fmt.Printf("Set the duress passphrase of %v: entered in place of the passphrase of the keys, the canary is signed with the panic key too.\n", cmd.Domain)
+	return nil
+}
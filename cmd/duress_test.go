@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDuressDomain stores encrypted keys for a domain with the passphrase "passphrase" and the duress
+// passphrase "duress", and returns the path of the signing key
+func newDuressDomain(t *testing.T, domain string, dropCode bool) string {
+	t.Setenv("CANARY_HOME", t.TempDir())
+	t.Setenv("CANARY_PASSPHRASE", "passphrase")
+	t.Cleanup(func() { cachedPassphrase, duress = nil, nil })
+	require.NoError(t, (&keyNewCmd{Domain: domain}).Run(nil))
+	require.NoError(t, withStdin(t, "duress\n", (&keyDuressCmd{Domain: domain, DropCode: dropCode}).Run))
+	cachedPassphrase = nil
+	return path.Join(canaryDir(domain), keyFileNames[canarytail.SigningKeyRole][1])
+}
+
+func TestOpenDuress(t *testing.T) {
+	domain := "mydomain.com"
+	keyPath := newDuressDomain(t, domain, true)
+	signingKey, err := readKeyFile(domain, canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	panicKey, err := readKeyFile(domain, canarytail.PanicKeyRole)
+	require.NoError(t, err)
+
+	keys := openDuress(keyPath, []byte("duress"))
+	require.NotNil(t, keys)
+	assert.Equal(t, signingKey, keys.signing.Public())
+	assert.Equal(t, panicKey, keys.panic.Public())
+	assert.True(t, keys.dropCode)
+
+	// the passphrase of the keys, or the key file of another role, open nothing
+	assert.Nil(t, openDuress(keyPath, []byte("passphrase")))
+	assert.Nil(t, openDuress(path.Join(canaryDir(domain), keyFileNames[canarytail.PanicKeyRole][1]), []byte("duress")))
+
+	// nor does the duress passphrase of a replaced signing key
+	require.NoError(t, (&keyNewCmd{Domain: domain, Force: true}).Run(nil))
+	assert.Nil(t, openDuress(keyPath, []byte("duress")))
+}
+
+func TestDecodePrivateKeyUnderDuress(t *testing.T) {
+	domain := "mydomain.com"
+	keyPath := newDuressDomain(t, domain, false)
+	signingKey, err := readKeyFile(domain, canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	content, err := ioutil.ReadFile(keyPath)
+	require.NoError(t, err)
+
+	t.Setenv("CANARY_PASSPHRASE", "wrong")
+	_, err = decodePrivateKey(content, keyPath)
+	assert.Contains(t, err.Error(), "Could not decrypt")
+	assert.Nil(t, duress)
+
+	t.Setenv("CANARY_PASSPHRASE", "passphrase")
+	key, err := decodePrivateKey(content, keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, signingKey, key.Public())
+	assert.Nil(t, duress)
+
+	// the duress passphrase unlocks the same signing key, and the panic key with it
+	cachedPassphrase = nil
+	t.Setenv("CANARY_PASSPHRASE", "duress")
+	key, err = decodePrivateKey(content, keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, signingKey, key.Public())
+	require.NotNil(t, duress)
+	signer, _, err := domainSigner(domain, canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	assert.IsType(t, duressSigner{}, signer)
+}
+
+func TestDuressSignerKeepsCodeOfSigningRequests(t *testing.T) {
+	domain := "mydomain.com"
+	newDuressDomain(t, domain, true)
+	t.Setenv("CANARY_PASSPHRASE", "duress")
+	signer, publicKey, err := domainSigner(domain, canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	require.IsType(t, duressSigner{}, signer)
+	panicKey, err := readKeyFile(domain, canarytail.PanicKeyRole)
+	require.NoError(t, err)
+
+	canary := canarytail.Canary{Claim: canarytail.CanaryClaim{
+		Domain:     domain,
+		MinSigners: 1,
+		PublicKeys: []canarytail.PublicKey{{Role: canarytail.RoleAuthor, Key: canarytail.FormatKey(publicKey), Required: true}},
+		PanicKey:   canarytail.FormatKey(panicKey),
+		Codes:      canarytail.AllCodes(),
+	}}
+
+	// signed in place, the canary drops the duress code
+	dropped := canary
+	require.NoError(t, dropped.Sign(signer))
+	assert.NotContains(t, dropped.Claim.Codes, "duress")
+	assert.Contains(t, canary.Claim.Codes, "duress")
+	assert.True(t, dropped.ValidateSignatures(panicKey))
+
+	// signed apart, it keeps the code for the signature to merge
+	request := canarytail.NewSigningRequest(canary)
+	signature, err := request.Sign(signer.(duressSigner).keepCode())
+	require.NoError(t, err)
+	assert.Contains(t, request.Claim.Codes, "duress")
+	require.NoError(t, canary.Merge(*signature))
+	assert.True(t, canary.ValidateSignatures(publicKey))
+	assert.True(t, canary.ValidateSignatures(panicKey))
+	assert.True(t, signer.(duressSigner).dropCode)
+}
+
+func TestSplitPanicDeletesDuress(t *testing.T) {
+	domain := "mydomain.com"
+	newDuressDomain(t, domain, false)
+	require.NoError(t, (&keySplitPanicCmd{Domain: domain, Shares: 3, Threshold: 2, Delete: true}).Run(nil))
+	for _, name := range []string{keyFileNames[canarytail.PanicKeyRole][1], duressFileName} {
+		_, err := os.Stat(path.Join(canaryDir(domain), name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+}
+
+func TestCanaryUpdateUnderDuress(t *testing.T) {
+	domain := "mydomain.com"
+	t.Setenv("CANARY_HOME", t.TempDir())
+	t.Setenv("CANARY_PASSPHRASE", "passphrase")
+	t.Cleanup(func() { cachedPassphrase, duress = nil, nil })
+	backend := canarytail.DefaultBlockBackend
+	t.Cleanup(func() { canarytail.DefaultBlockBackend = backend })
+	tip := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "0000000000000bae09a7a393a8acded75aa67e46cb81f7acaa5ad94f9eacd103")
+	}))
+	defer tip.Close()
+	canarytail.DefaultBlockBackend = canarytail.NewEsploraBackend(tip.URL)
+
+	require.NoError(t, (&keyNewCmd{Domain: domain}).Run(nil))
+	require.NoError(t, (&keyDelegateCmd{Domain: domain, Name: "renewer", Fields: []string{"expiry"}, Days: 90}).Run(nil))
+	panicKey, err := readPrivateKeyFile(path.Join(canaryDir(domain), keyFileNames[canarytail.PanicKeyRole][1]))
+	require.NoError(t, err)
+	delegateKey, err := readPrivateKeyFile(path.Join(canaryDir(domain), keyFileNames[canarytail.DelegateKeyRole][1]))
+	require.NoError(t, err)
+	opts := canaryOpCmd{Domain: domain, Expiry: 60, freshnessOpts: freshnessOpts{NoFreshnessProof: true}}
+	require.NoError(t, (&canaryNewCmd{opts}).Run(nil))
+	require.NoError(t, withStdin(t, "duress\n", (&keyDuressCmd{Domain: domain}).Run))
+
+	cachedPassphrase = nil
+	t.Setenv("CANARY_PASSPHRASE", "duress")
+	require.NoError(t, (&canaryUpdateCmd{canaryOpCmd: opts}).Run(nil))
+	require.NotNil(t, duress)
+	canary, err := readCanaryFile(path.Join(canaryDir(domain), canaryLatestFileName(domain)))
+	require.NoError(t, err)
+	assert.True(t, canary.ValidateSignatures(panicKey.Public().(ed25519.PublicKey)), "signed with the panic key too")
+
+	// the other keys of the domain read in the same session resolve as they would under the passphrase
+	signer, err := keyStore.Signer(domain, canarytail.PanicKeyRole)
+	require.NoError(t, err)
+	assert.Equal(t, panicKey, signer)
+	signer, err = keyStore.Signer(domain, canarytail.DelegateKeyRole)
+	require.NoError(t, err)
+	assert.Equal(t, delegateKey, signer)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	canarytail "github.com/canarytail/client"
+)
+
+// maxHeaderUpdate bounds how many headers 'headers update' walks back from the source tip, and
+// how many it verifies and appends at once when catching up from further behind
+const maxHeaderUpdate = 10000
+
+type headersOpts struct {
+	Network string `name:"network" help:"Bitcoin network of the header chain: mainnet or regtest (default: mainnet)" default:"mainnet" enum:"mainnet,regtest"`
+}
+
+func (o headersOpts) params() *canarytail.ChainParams {
+	if o.Network == "regtest" {
+		return canarytail.RegressionNetParams
+	}
+	return canarytail.MainNetParams
+}
+
+func headerChainPath(params *canarytail.ChainParams) string {
+	return path.Join(canaryHomeDir(), fmt.Sprintf("headers.%s.dat", params.Name))
+}
+
+func loadHeaderChain(params *canarytail.ChainParams) (*canarytail.HeaderChain, error) {
+	chain, err := canarytail.LoadHeaderChain(headerChainPath(params), params)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no %s header chain found, use 'headers import' to create one", params.Name)
+	}
+	return chain, err
+}
+
+type headersImportCmd struct {
+	headersOpts
+
+	File        string `arg name:"FILE" help:"File with raw concatenated 80-byte block headers"`
+	StartHeight int    `name:"start-height" help:"Height of the first header in FILE when creating a new header chain. The first header is trusted as a checkpoint, so only use headers from a source you trust for it. (default: 0, the genesis block)"`
+}
+
+func (cmd *headersImportCmd) Run(ctx *context) error {
+	params := cmd.params()
+	f, err := os.Open(cmd.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	headers, err := canarytail.ReadHeaders(f)
+	if err != nil {
+		return err
+	}
+
+	var chain *canarytail.HeaderChain
+	if _, err := os.Stat(headerChainPath(params)); os.IsNotExist(err) {
+		fmt.Printf("Creating a new %s header chain anchored at height %d...\n", params.Name, cmd.StartHeight)
+		if chain, err = canarytail.ImportHeaderChain(params, cmd.StartHeight, headers); err != nil {
+			return err
+		}
+	} else {
+		if chain, err = loadHeaderChain(params); err != nil {
+			return err
+		}
+		if _, err := chain.Append(headers); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(canaryHomeDir(), 0700); err != nil {
+		return err
+	}
+	if err := chain.Save(headerChainPath(params)); err != nil {
+		return err
+	}
+	fmt.Printf("Header chain is at height %d (%x)\n", chain.Height(), chain.Tip().Hash())
+	return nil
+}
+
+type headersUpdateCmd struct {
+	headersOpts
+}
+
+func (cmd *headersUpdateCmd) Run(ctx *context) error {
+	params := cmd.params()
+	chain, err := loadHeaderChain(params)
+	if err != nil {
+		return err
+	}
+
+	source, ok := canarytail.DefaultBlockBackend.(canarytail.HeaderSource)
+	if !ok {
+		return errors.New("the block backend does not provide block headers")
+	}
+	fmt.Printf("Fetching new headers from %s...\n", source.Name())
+	added, err := chain.Update(source, maxHeaderUpdate)
+	if added > 0 {
+		// keep the verified batches of a long catch up even if a later one failed
+		if err := chain.Save(headerChainPath(params)); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added %d headers, header chain is at height %d (%x)\n", added, chain.Height(), chain.Tip().Hash())
+	return nil
+}
+
+type headersStatusCmd struct {
+	headersOpts
+}
+
+func (cmd *headersStatusCmd) Run(ctx *context) error {
+	chain, err := loadHeaderChain(cmd.params())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Network: %s\nAnchor height: %d\nHeight: %d\nTip: %x\nTip time: %v\n",
+		chain.Params.Name, chain.StartHeight, chain.Height(), chain.Tip().Hash(), chain.Tip().Timestamp())
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	canarytail "github.com/canarytail/client"
+)
+
+// feedsPath lists the feeds to quote headlines from, one URL per line
+func feedsPath() string {
+	return path.Join(canaryHomeDir(), "feeds")
+}
+
+// readFeeds reads the feeds configured at $CANARY_HOME/feeds, skipping blank lines and comments
+func readFeeds() ([]string, error) {
+	f, err := os.Open(feedsPath())
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no feeds configured, list them in %v or use --feed", feedsPath())
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var feeds []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			feeds = append(feeds, line)
+		}
+	}
+	return feeds, scanner.Err()
+}
+
+// fetchHeadlines quotes the latest headlines of every feed
+func fetchHeadlines(cmd freshnessOpts) ([]canarytail.Headline, error) {
+	feeds := cmd.Feeds
+	if len(feeds) == 0 {
+		var err error
+		if feeds, err = readFeeds(); err != nil {
+			return nil, err
+		}
+	}
+
+	var headlines []canarytail.Headline
+	for _, feed := range feeds {
+		fmt.Printf("Fetching headlines from %v...\n", feed)
+		h, err := canarytail.FetchHeadlines(feed, cmd.Headlines)
+		if err != nil {
+			return nil, fmt.Errorf("Could not fetch headlines: %v", err)
+		}
+		headlines = append(headlines, h...)
+	}
+	return headlines, nil
+}
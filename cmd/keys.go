@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"golang.org/x/term"
+)
+
+// keyFileNames are the public and private key files of a role in $CANARY_HOME/DOMAIN
+var keyFileNames = map[canarytail.KeyRole][2]string{
+	canarytail.SigningKeyRole:  {"public.b64", "private.b64"},
+	canarytail.PanicKeyRole:    {"panic-public.b64", "panic-private.b64"},
+	canarytail.DelegateKeyRole: {"delegate-public.b64", "delegate-private.b64"},
+}
+
+var keyRoles = []canarytail.KeyRole{canarytail.SigningKeyRole, canarytail.PanicKeyRole, canarytail.DelegateKeyRole}
+
+func keyRole(panic bool) canarytail.KeyRole {
+	if panic {
+		return canarytail.PanicKeyRole
+	}
+	return canarytail.SigningKeyRole
+}
+
+// archivedKey is the metadata of an archived key, stored in key.json next to its files in
+// $CANARY_HOME/DOMAIN/archive/TIME-ROLE, so that the canaries it signed can still be told apart
+type archivedKey struct {
+	Role        canarytail.KeyRole `json:"role"`
+	PublicKey   string             `json:"public_key"`
+	Fingerprint string             `json:"fingerprint"`
+	ArchivedAt  string             `json:"archived_at"`
+	Reason      string             `json:"reason,omitempty"`
+}
+
+const archivedKeyFileName = "key.json"
+
+func keyArchiveDir(domain string) string {
+	return path.Join(canaryDir(domain), "archive")
+}
+
+// readKeyFile reads the public key of a role stored for a domain, which is nil if there is none
+func readKeyFile(domain string, role canarytail.KeyRole) (ed25519.PublicKey, error) {
+	content, err := ioutil.ReadFile(path.Join(canaryDir(domain), keyFileNames[role][0]))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := canarytail.ParsePublicKey(strings.TrimSpace(string(content)))
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key in %v", path.Join(canaryDir(domain), keyFileNames[role][0]))
+	}
+	return publicKey, nil
+}
+
+// archiveKey moves the key files of a role of a domain to its archive, with their metadata
+func archiveKey(domain string, role canarytail.KeyRole, reason string) (*archivedKey, error) {
+	publicKey, err := readKeyFile(domain, role)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey == nil {
+		return nil, fmt.Errorf("%v has no %v key to archive", domain, role)
+	}
+
+	now := time.Now().UTC()
+	if err := os.MkdirAll(keyArchiveDir(domain), 0700); err != nil {
+		return nil, err
+	}
+	// the same key may be archived again within a second, e.g. after being imported back
+	name := now.Format("20060102T150405Z") + "-" + string(role)
+	dir := path.Join(keyArchiveDir(domain), name)
+	for i := 2; ; i++ {
+		err := os.Mkdir(dir, 0700)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("Could not create the archive %v: %v", dir, err)
+		}
+		dir = path.Join(keyArchiveDir(domain), fmt.Sprintf("%v-%d", name, i))
+	}
+
+	archived := &archivedKey{
+		Role:        role,
+		PublicKey:   canarytail.FormatKey(publicKey),
+		Fingerprint: canarytail.KeyFingerprint(publicKey),
+		ArchivedAt:  now.Format(canarytail.TimestampLayout),
+		Reason:      reason,
+	}
+	metadata, err := json.MarshalIndent(archived, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeToFile(path.Join(dir, archivedKeyFileName), string(metadata)); err != nil {
+		return nil, err
+	}
+	for _, name := range keyFileNames[role] {
+		err := os.Rename(path.Join(canaryDir(domain), name), path.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Could not archive %v: %v", name, err)
+		}
+	}
+	return archived, nil
+}
+
+// readArchivedKeys reads the metadata of the archived keys of a domain, oldest first
+func readArchivedKeys(domain string) ([]archivedKey, error) {
+	entries, err := ioutil.ReadDir(keyArchiveDir(domain))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var keys []archivedKey
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		metadataPath := path.Join(keyArchiveDir(domain), entry.Name(), archivedKeyFileName)
+		content, err := ioutil.ReadFile(metadataPath)
+		if err != nil {
+			return nil, err
+		}
+		var key archivedKey
+		if err := json.Unmarshal(content, &key); err != nil {
+			return nil, fmt.Errorf("invalid archived key metadata in %v: %v", metadataPath, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// keyStatus tells whether the private key of a role is stored, and how
+func keyStatus(domain string, role canarytail.KeyRole) string {
+	content, err := ioutil.ReadFile(path.Join(canaryDir(domain), keyFileNames[role][1]))
+	switch {
+	case err != nil:
+		return "no private key"
+	case canarytail.IsEncryptedKey(content):
+		return "encrypted"
+	}
+	return "unencrypted"
+}
+
+type keyListCmd struct{}
+
+func (cmd *keyListCmd) Run(ctx *context) error {
+	entries, err := ioutil.ReadDir(canaryHomeDir())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		domain := entry.Name()
+		archived, err := readArchivedKeys(domain)
+		if err != nil {
+			return err
+		}
+		var line []string
+		for _, role := range keyRoles {
+			publicKey, err := readKeyFile(domain, role)
+			if err != nil {
+				return err
+			}
+			if publicKey != nil {
+				line = append(line, fmt.Sprintf("%v %v (%v)", role, canarytail.KeyFingerprint(publicKey), keyStatus(domain, role)))
+			}
+		}
+		if len(line) == 0 && len(archived) == 0 {
+			continue
+		}
+		if len(archived) > 0 {
+			line = append(line, fmt.Sprintf("%d archived", len(archived)))
+		}
+		fmt.Printf("%v\t%v\n", domain, strings.Join(line, ", "))
+	}
+	return nil
+}
+
+type keyShowCmd struct {
+	Domain string `arg name:"DOMAIN" help:"Domain of the canary"`
+}
+
+func (cmd *keyShowCmd) Run(ctx *context) error {
+	archived, err := readArchivedKeys(cmd.Domain)
+	if err != nil {
+		return err
+	}
+	found := len(archived) > 0
+	for _, role := range keyRoles {
+		publicKey, err := readKeyFile(cmd.Domain, role)
+		if err != nil {
+			return err
+		}
+		if publicKey == nil {
+			continue
+		}
+		found = true
+		fmt.Printf("%v key:\n", role)
+		fmt.Printf("  public key:  %v\n", canarytail.FormatKey(publicKey))
+		fmt.Printf("  fingerprint: %v\n", canarytail.KeyFingerprint(publicKey))
+		fmt.Printf("  private key: %v\n", keyStatus(cmd.Domain, role))
+	}
+	if !found {
+		return fmt.Errorf("no keys stored for %v, use 'key new' to create them", cmd.Domain)
+	}
+
+	for _, key := range archived {
+		fmt.Printf("archived %v key:\n", key.Role)
+		fmt.Printf("  public key:  %v\n", key.PublicKey)
+		fmt.Printf("  fingerprint: %v\n", key.Fingerprint)
+		fmt.Printf("  archived at: %v\n", key.ArchivedAt)
+		if key.Reason != "" {
+			fmt.Printf("  reason:      %v\n", key.Reason)
+		}
+	}
+	return nil
+}
+
+type keyArchiveCmd struct {
+	Domain string `arg name:"DOMAIN" help:"Domain of the canary"`
+	Panic  bool   `name:"panic" help:"Archive the panic key instead of the signing key"`
+	Reason string `name:"reason" help:"Why the key is archived, kept with it"`
+}
+
+func (cmd *keyArchiveCmd) Run(ctx *context) error {
+	archived, err := archiveKey(cmd.Domain, keyRole(cmd.Panic), cmd.Reason)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Archived the %v key %v of %v\n", archived.Role, archived.Fingerprint, cmd.Domain)
+	return nil
+}
+
+type keyExportCmd struct {
+	Domain string `arg name:"DOMAIN" help:"Domain of the canary"`
+	Public bool   `name:"public" help:"Print the public key, in base64"`
+	Paper  bool   `name:"paper" help:"Print a paper backup of the private key, as 24 BIP-39 words encoding its seed"`
+	Panic  bool   `name:"panic" help:"Export the panic key instead of the signing key"`
+}
+
+func (cmd *keyExportCmd) Run(ctx *context) error {
+	role := keyRole(cmd.Panic)
+	switch {
+	case cmd.Public && cmd.Paper:
+		return errors.New("choose one of --public and --paper")
+	case cmd.Public:
+		publicKey, err := keyStore.PublicKey(cmd.Domain, role)
+		if err != nil {
+			return err
+		}
+		fmt.Println(canarytail.FormatKey(publicKey))
+		return nil
+	case cmd.Paper:
+		if _, ok := keyStore.(fileKeyStore); !ok {
+			return errors.New("only keys stored in $CANARY_HOME can be exported")
+		}
+		privateKey, err := readPrivateKeyFile(path.Join(canaryDirSafe(cmd.Domain), keyFileNames[role][1]))
+		if err != nil {
+			return err
+		}
+		return printPaperBackup(cmd.Domain, role, privateKey)
+	}
+	return errors.New("choose what to export: --public or --paper")
+}
+
+type keyImportCmd struct {
+	Domain      string `arg name:"DOMAIN" help:"Domain of the canary"`
+	Paper       bool   `name:"paper" help:"Restore the private key from the words of a paper backup, read on stdin"`
+	Panic       bool   `name:"panic" help:"Import the panic key instead of the signing key"`
+	Canary      string `name:"canary" help:"Canary the imported key must be a key of (default: the latest canary in $CANARY_HOME/DOMAIN)"`
+	Unencrypted bool   `name:"unencrypted" help:"Store the private key in plain base64 instead of encrypting it under a passphrase"`
+	Force       bool   `name:"force" help:"Archive the key stored for DOMAIN if it is another key, instead of refusing"`
+}
+
+func (cmd *keyImportCmd) Run(ctx *context) error {
+	role := keyRole(cmd.Panic)
+	dir := canaryDirSafe(cmd.Domain)
+
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		if cmd.Paper {
+			fmt.Fprintln(os.Stderr, "Type the words of the paper backup, then Ctrl-D:")
+		} else {
+			fmt.Fprintln(os.Stderr, "Paste the private key, in base64 or encrypted, then Ctrl-D:")
+		}
+	}
+	input, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	// an encrypted key is stored as it is, under its own passphrase
+	var privateKey ed25519.PrivateKey
+	var encrypted []byte
+	if cmd.Paper {
+		privateKey, err = paperBackupKey(cmd.Domain, input)
+	} else {
+		input = bytes.TrimSpace(input)
+		if canarytail.IsEncryptedKey(input) {
+			encrypted = input
+		}
+		privateKey, err = decodePrivateKey(input, "the imported key")
+		if err == nil && (len(privateKey) != ed25519.PrivateKeySize ||
+			!bytes.Equal(ed25519.NewKeyFromSeed(privateKey.Seed()), privateKey)) {
+			err = errors.New("the imported private key is not a valid Ed25519 private key")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	if err := checkPublishedKey(cmd.Domain, cmd.Canary, role, publicKey); err != nil {
+		return err
+	}
+	existing, err := readKeyFile(cmd.Domain, role)
+	if err != nil {
+		return err
+	}
+	if existing != nil && !bytes.Equal(existing, publicKey) {
+		if !cmd.Force {
+			return fmt.Errorf("%v already has the %v key %v, archive it with 'key archive' or use --force",
+				cmd.Domain, role, canarytail.KeyFingerprint(existing))
+		}
+		if _, err := archiveKey(cmd.Domain, role, "replaced by key import"); err != nil {
+			return err
+		}
+	}
+
+	var passphrase []byte
+	if encrypted == nil && !cmd.Unencrypted {
+		if passphrase, err = newPassphrase(); err != nil {
+			return err
+		}
+	}
+	if err := writeToFile(path.Join(dir, keyFileNames[role][0]), canarytail.FormatKey(publicKey)); err != nil {
+		return err
+	}
+	privatePath := path.Join(dir, keyFileNames[role][1])
+	if encrypted != nil && !cmd.Unencrypted {
+		err = writeToFile(privatePath, string(encrypted))
+	} else {
+		err = writePrivateKeyFile(privatePath, privateKey, passphrase)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported the %v key %v of %v\n", role, canarytail.KeyFingerprint(publicKey), cmd.Domain)
+	return nil
+}
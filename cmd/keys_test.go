@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withStdin runs a command reading input on stdin
+func withStdin(t *testing.T, input string, run func(*context) error) error {
+	fp := path.Join(t.TempDir(), "stdin")
+	require.NoError(t, ioutil.WriteFile(fp, []byte(input), 0600))
+	f, err := os.Open(fp)
+	require.NoError(t, err)
+	defer f.Close()
+	file, reader := os.Stdin, stdin
+	os.Stdin, stdin = f, bufio.NewReader(f)
+	defer func() { os.Stdin, stdin = file, reader }()
+	return run(nil)
+}
+
+func TestKeyArchive(t *testing.T) {
+	t.Setenv("CANARY_HOME", t.TempDir())
+	domain := "mydomain.com"
+	require.NoError(t, (&keyNewCmd{Domain: domain, Unencrypted: true}).Run(nil))
+	signingKey, err := readKeyFile(domain, canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	panicKey, err := readKeyFile(domain, canarytail.PanicKeyRole)
+	require.NoError(t, err)
+
+	// the keys are never overwritten unless asked to
+	err = (&keyNewCmd{Domain: domain, Unencrypted: true}).Run(nil)
+	assert.Contains(t, err.Error(), "already has keys")
+	unchanged, err := readKeyFile(domain, canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	assert.Equal(t, signingKey, unchanged)
+
+	require.NoError(t, (&keyNewCmd{Domain: domain, Unencrypted: true, Force: true}).Run(nil))
+	newKey, err := readKeyFile(domain, canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	assert.NotEqual(t, signingKey, newKey)
+
+	archived, err := readArchivedKeys(domain)
+	require.NoError(t, err)
+	require.Len(t, archived, 2)
+	byRole := map[canarytail.KeyRole]archivedKey{archived[0].Role: archived[0], archived[1].Role: archived[1]}
+	assert.Equal(t, canarytail.FormatKey(signingKey), byRole[canarytail.SigningKeyRole].PublicKey)
+	assert.Equal(t, canarytail.KeyFingerprint(signingKey), byRole[canarytail.SigningKeyRole].Fingerprint)
+	assert.Equal(t, "replaced by key new", byRole[canarytail.SigningKeyRole].Reason)
+	assert.Equal(t, canarytail.FormatKey(panicKey), byRole[canarytail.PanicKeyRole].PublicKey)
+
+	// archived again, maybe within the same second, the keys are kept apart
+	key, err := archiveKey(domain, canarytail.SigningKeyRole, "compromised")
+	require.NoError(t, err)
+	assert.Equal(t, canarytail.FormatKey(newKey), key.PublicKey)
+	gone, err := readKeyFile(domain, canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	assert.Nil(t, gone)
+	_, err = os.Stat(path.Join(canaryDir(domain), keyFileNames[canarytail.SigningKeyRole][1]))
+	assert.True(t, os.IsNotExist(err))
+	archived, err = readArchivedKeys(domain)
+	require.NoError(t, err)
+	require.Len(t, archived, 3)
+	assert.Equal(t, "compromised", archived[2].Reason)
+
+	_, err = archiveKey(domain, canarytail.DelegateKeyRole, "")
+	assert.Contains(t, err.Error(), "no delegate key")
+
+	none, err := readArchivedKeys("other.com")
+	assert.NoError(t, err)
+	assert.Empty(t, none)
+}
+
+func TestKeyImportRefusesOverwrite(t *testing.T) {
+	t.Setenv("CANARY_HOME", t.TempDir())
+	domain := "mydomain.com"
+	require.NoError(t, (&keyNewCmd{Domain: domain, Unencrypted: true}).Run(nil))
+	existing, err := readKeyFile(domain, canarytail.SigningKeyRole)
+	require.NoError(t, err)
+
+	publicKey, privateKey, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+	importKey := func(force bool) error {
+		return withStdin(t, base64.StdEncoding.EncodeToString(privateKey), (&keyImportCmd{Domain: domain, Unencrypted: true, Force: force}).Run)
+	}
+
+	err = importKey(false)
+	assert.Contains(t, err.Error(), "already has the signing key "+canarytail.KeyFingerprint(existing))
+	stored, err := readKeyFile(domain, canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	assert.Equal(t, existing, stored)
+
+	require.NoError(t, importKey(true))
+	stored, err = readKeyFile(domain, canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, stored)
+	archived, err := readArchivedKeys(domain)
+	require.NoError(t, err)
+	require.Len(t, archived, 1)
+	assert.Equal(t, canarytail.FormatKey(existing), archived[0].PublicKey)
+}
+
+func TestCheckPublishedKey(t *testing.T) {
+	signingKey, _, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+	panicKey, _, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+	signerPanicKey, _, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+	canary := canarytail.Canary{Claim: canarytail.CanaryClaim{
+		Domain:     "mydomain.com",
+		PublicKeys: []canarytail.PublicKey{{Role: canarytail.RoleCosigner, Name: "alice", Key: canarytail.FormatKey(signingKey), PanicKey: canarytail.FormatKey(signerPanicKey)}},
+		PanicKey:   canarytail.FormatKey(panicKey),
+	}}
+	uri := path.Join(t.TempDir(), "canary.json")
+	require.NoError(t, ioutil.WriteFile(uri, []byte(canary.Format()), 0600))
+
+	assert.NoError(t, checkPublishedKey(canary.Claim.Domain, uri, canarytail.SigningKeyRole, signingKey))
+	assert.Error(t, checkPublishedKey(canary.Claim.Domain, uri, canarytail.SigningKeyRole, panicKey))
+	// the panic key of the domain, or the own panic key of a signer
+	assert.NoError(t, checkPublishedKey(canary.Claim.Domain, uri, canarytail.PanicKeyRole, panicKey))
+	assert.NoError(t, checkPublishedKey(canary.Claim.Domain, uri, canarytail.PanicKeyRole, signerPanicKey))
+	assert.Error(t, checkPublishedKey(canary.Claim.Domain, uri, canarytail.PanicKeyRole, signingKey))
+}
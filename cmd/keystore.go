@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	canarytail "github.com/canarytail/client"
+)
+
+// keyStore holds the keys canaries are signed with, chosen with --key-store
+var keyStore canarytail.KeyStore = fileKeyStore{}
+
+// fileKeyStore is the default key store, with the keys of a domain in files at $CANARY_HOME/DOMAIN
+type fileKeyStore struct{}
+
+func (fileKeyStore) PublicKey(domain string, role canarytail.KeyRole) (ed25519.PublicKey, error) {
+	switch role {
+	case canarytail.PanicKeyRole:
+		return readPanicPublicKey(canaryDirSafe(domain))
+	case canarytail.DelegateKeyRole:
+		publicKey, err := readKeyFile(domain, role)
+		if err == nil && publicKey == nil {
+			err = fmt.Errorf("no delegated key for %v, use 'key delegate' to create one", domain)
+		}
+		return publicKey, err
+	}
+	return readPublicKey(canaryDirSafe(domain))
+}
+
+func (fileKeyStore) Signer(domain string, role canarytail.KeyRole) (crypto.Signer, error) {
+	switch role {
+	case canarytail.PanicKeyRole, canarytail.DelegateKeyRole:
+		return readPrivateKeyFile(path.Join(canaryDirSafe(domain), keyFileNames[role][1]))
+	}
+	privateKey, err := readPrivateKey(canaryDirSafe(domain))
+	if err == nil && duress != nil && bytes.Equal(privateKey, duress.signing) {
+		return duressSigner{duress}, nil
+	}
+	return privateKey, err
+}
+
+// openKeyStore opens the key store described by spec: file (default), exec:COMMAND [ARGS...],
+// pkcs11:MODULE?token=LABEL or signer[:SOCKET] for the signer daemon
+func openKeyStore(spec string) (canarytail.KeyStore, error) {
+	kind, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+	switch kind {
+	case "", "file":
+		return fileKeyStore{}, nil
+	case "exec":
+		return canarytail.NewCommandKeyStore(strings.Fields(arg)...)
+	case "pkcs11":
+		module, token := arg, ""
+		if i := strings.Index(arg, "?token="); i >= 0 {
+			module, token = arg[:i], arg[i+len("?token="):]
+		}
+		if module == "" || token == "" {
+			return nil, fmt.Errorf("invalid key store %q, expected pkcs11:MODULE?token=LABEL", spec)
+		}
+		pin := os.Getenv("CANARY_PKCS11_PIN")
+		if pin == "" {
+			secret, err := promptSecret(fmt.Sprintf("PIN of the PKCS#11 token %v: ", token))
+			if err != nil {
+				return nil, err
+			}
+			pin = string(secret)
+		}
+		return canarytail.NewPKCS11KeyStore(module, token, pin)
+	case "signer":
+		return canarytail.NewSignerClient(signerSocket(arg)), nil
+	}
+	return nil, fmt.Errorf("unknown key store %q, expected file, exec:COMMAND, pkcs11:MODULE?token=LABEL or signer[:SOCKET]", spec)
+}
+
+// closeKeyStore releases the key store, e.g. logging out of a PKCS#11 token
+func closeKeyStore() {
+	if closer, ok := keyStore.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// refuseSignerDaemon fails early in the commands signing other messages than canaries, which the signer
+// daemon does not sign
+func refuseSignerDaemon(what string) error {
+	if _, ok := keyStore.(*canarytail.SignerClient); ok {
+		return fmt.Errorf("the signer daemon only signs canaries, not %v: use another --key-store", what)
+	}
+	return nil
+}
+
+// domainSigner returns the signer of the key of a domain for a role, with its public key
+func domainSigner(domain string, role canarytail.KeyRole) (crypto.Signer, ed25519.PublicKey, error) {
+	signer, err := keyStore.Signer(domain, role)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKey, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("the %v key of %v is not an Ed25519 key", role, domain)
+	}
+	return signer, publicKey, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	canarytail "github.com/canarytail/client"
+)
+
+// cosignerPanic signs the canary with the panic key of DOMAIN, when it is the own panic key of one of
+// the signers of the canary rather than the panic key of the domain. It tells whether it signed, so that
+// the author's panic updates the canary instead.
+func cosignerPanic(domain, canaryPath, tsaURL string) (bool, error) {
+	dir := canaryDirSafe(domain)
+	explicit := canaryPath != ""
+	if !explicit {
+		fileName, err := getLatestCanaryFileName(dir)
+		if err != nil {
+			return false, nil
+		}
+		canaryPath = path.Join(dir, fileName)
+	}
+	canary, err := readCanaryFile(canaryPath)
+	if err != nil {
+		return false, err
+	}
+	panicKey, err := keyStore.PublicKey(domain, canarytail.PanicKeyRole)
+	if err != nil {
+		return false, err
+	}
+
+	var signer *canarytail.PublicKey
+	for i, k := range canary.Claim.PublicKeys {
+		if k.PanicKey == canarytail.FormatKey(panicKey) {
+			signer = &canary.Claim.PublicKeys[i]
+		}
+	}
+	if signer == nil {
+		if explicit {
+			return false, fmt.Errorf("your panic key %v is not the panic key of a signer of the canary at %v", canarytail.KeyFingerprint(panicKey), canaryPath)
+		}
+		return false, nil
+	}
+
+	panicSigner, _, err := domainSigner(domain, canarytail.PanicKeyRole)
+	if err != nil {
+		return false, err
+	}
+	if err := canary.Sign(panicSigner); err != nil {
+		return false, err
+	}
+	if err := countersign(&canary, tsaURL); err != nil {
+		return false, err
+	}
+	canaryFormatted := canary.Format()
+	if err := writeToFile(canaryPath, canaryFormatted); err != nil {
+		return false, err
+	}
+	if !explicit {
+		if err := writeToFile(path.Join(dir, canaryLatestFileName(domain)), canaryFormatted); err != nil {
+			return false, err
+		}
+	}
+	fmt.Printf("The canary at %q is signed with the panic key of %q. Send it back as if you had signed it: validators will report the panic.\n", canaryPath, signer.Name)
+	return true, nil
+}
+
+// storesKeyFiles tells whether the private keys are files at $CANARY_HOME, which can be destroyed
+func storesKeyFiles() bool {
+	switch s := keyStore.(type) {
+	case fileKeyStore:
+		return true
+	case rebuiltPanicKeyStore:
+		_, ok := s.KeyStore.(fileKeyStore)
+		return ok
+	}
+	return false
+}
+
+// destroyKeys overwrites and deletes the private keys of a domain but its panic key, archived ones
+// included, and the keys of the given cosigners found at $CANARY_HOME
+func destroyKeys(domain string, cosigners []canarytail.PublicKey) error {
+	dir := canaryDir(domain)
+	files := []string{
+		path.Join(dir, keyFileNames[canarytail.SigningKeyRole][1]),
+		path.Join(dir, keyFileNames[canarytail.DelegateKeyRole][1]),
+		path.Join(dir, duressFileName),
+	}
+	archived, err := filepath.Glob(path.Join(keyArchiveDir(domain), "*", "*private.b64"))
+	if err != nil {
+		return err
+	}
+	files = append(files, archived...)
+
+	if len(cosigners) > 0 {
+		dirs, err := ioutil.ReadDir(canaryHomeDir())
+		if err != nil {
+			return err
+		}
+		for _, d := range dirs {
+			cosignerDir := path.Join(canaryHomeDir(), d.Name())
+			publicKey, err := readPublicKey(cosignerDir)
+			if !d.IsDir() || err != nil {
+				continue
+			}
+			for _, k := range cosigners {
+				if k.Key == canarytail.FormatKey(publicKey) {
+					files = append(files, path.Join(cosignerDir, keyFileNames[canarytail.SigningKeyRole][1]), path.Join(cosignerDir, duressFileName))
+				}
+			}
+		}
+	}
+
+	destroyed := make([]string, 0, len(files))
+	for _, fp := range files {
+		if err := shredFile(fp); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Could not destroy %v, the canary says the keys are destroyed: delete it by hand: %v", fp, err)
+		}
+		destroyed = append(destroyed, fp)
+	}
+	fmt.Printf("Destroyed the private keys %v\n", strings.Join(destroyed, ", "))
+	return nil
+}
+
+// shredFile overwrites a file with random bytes before deleting it. Journaling and copy-on-write file
+// systems, and flash storage, may still keep copies of its content.
+func shredFile(fp string) error {
+	f, err := os.OpenFile(fp, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	for pass := 0; pass < 3; pass++ {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := io.CopyN(f, rand.Reader, info.Size()); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(fp)
+}
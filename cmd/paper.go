@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	canarytail "github.com/canarytail/client"
+)
+
+// printPaperBackup prints a sheet with the seed of a private key as words, to be written down
+func printPaperBackup(domain string, role canarytail.KeyRole, privateKey ed25519.PrivateKey) error {
+	words, err := canarytail.EncodeMnemonic(privateKey.Seed())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(paperBackupTitle)
+	fmt.Printf("domain: %v\n", domain)
+	fmt.Printf("key: %v\n", role)
+	fmt.Printf("public key: %v\n", canarytail.FormatKey(privateKey.Public().(ed25519.PublicKey)))
+	fmt.Println("words:")
+	row := ""
+	for i, w := range words {
+		row += fmt.Sprintf("%2d. %-10s", i+1, w)
+		if i%4 == 3 || i == len(words)-1 {
+			fmt.Println(strings.TrimRight(row, " "))
+			row = ""
+		}
+	}
+	fmt.Println()
+	fmt.Println("Anyone with these words can sign canaries for the domain: keep them offline and safe.")
+	fmt.Println("The checksum covers the words only, not the domain: copy it carefully too.")
+	return nil
+}
+
+// paperBackupKey restores the private key from the words of a paper backup of a domain. The domain
+// label of the sheet is not covered by the checksum of the words, which stay plain BIP-39: it only
+// catches restoring the backup of another domain.
+func paperBackupKey(domain string, sheet []byte) (ed25519.PrivateKey, error) {
+	backupDomain, words := parsePaperBackup(sheet)
+	if backupDomain != "" && backupDomain != domain {
+		return nil, fmt.Errorf("the paper backup is for %v, not %v", backupDomain, domain)
+	}
+	seed, err := canarytail.DecodeMnemonic(words)
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("expected the 24 words of an Ed25519 seed, got %d", len(words))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+const paperBackupTitle = "CANARYTAIL PAPER BACKUP"
+
+var wordNumber = regexp.MustCompile(`^\d+\.?$`)
+
+// parsePaperBackup reads the words of a paper backup printed by 'key export --paper', or words alone
+func parsePaperBackup(sheet []byte) (domain string, words []string) {
+	lines := bufio.NewScanner(bytes.NewReader(sheet))
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if strings.HasPrefix(line, "domain:") {
+			domain = strings.TrimSpace(strings.TrimPrefix(line, "domain:"))
+		}
+		// the header and footer of the sheet
+		if line == paperBackupTitle || strings.Contains(line, ":") || strings.HasSuffix(line, ".") {
+			continue
+		}
+		for _, w := range strings.Fields(line) {
+			if !wordNumber.MatchString(w) {
+				words = append(words, w)
+			}
+		}
+	}
+	return
+}
+
+// checkPublishedKey checks a key is the key of a role in a canary: the given one, or else the latest canary
+// of the domain, if any
+func checkPublishedKey(domain, uri string, role canarytail.KeyRole, publicKey ed25519.PublicKey) error {
+	var canary canarytail.Canary
+	var err error
+	if uri != "" {
+		canary, err = canarytail.Read(uri)
+	} else {
+		dir := canaryDirSafe(domain)
+		fileName, latestErr := getLatestCanaryFileName(dir)
+		if latestErr != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: no canary of %v to check the key against, use --canary\n", domain)
+			return nil
+		}
+		canary, err = readCanaryFile(path.Join(dir, fileName))
+	}
+	if err != nil {
+		return err
+	}
+
+	key := canarytail.FormatKey(publicKey)
+	if role == canarytail.PanicKeyRole {
+		if canary.Claim.PanicKey == key {
+			return nil
+		}
+		// or the own panic key of a signer
+		for _, k := range canary.Claim.PublicKeys {
+			if k.PanicKey == key {
+				return nil
+			}
+		}
+		return fmt.Errorf("the imported key %v is not a panic key of the canary", key)
+	}
+	for _, k := range canary.Claim.PublicKeys {
+		if k.Key == key {
+			return nil
+		}
+	}
+	return fmt.Errorf("the imported key %v is not a key of the canary", key)
+}
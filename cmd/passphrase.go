@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	canarytail "github.com/canarytail/client"
+
+	"golang.org/x/term"
+)
+
+// passphraseFD is the file descriptor the passphrase is read from, set with --passphrase-fd
+var passphraseFD = -1
+
+// passphraseReader reads the passphrases from the file descriptor given with --passphrase-fd, one per line
+var passphraseReader *bufio.Reader
+
+// cachedPassphrase avoids asking the passphrase of the signing and panic keys twice
+var cachedPassphrase []byte
+
+// readPassphrase gets the passphrase protecting the private keys: from $CANARY_PASSPHRASE, from the
+// file descriptor given with --passphrase-fd, or else from a prompt on the terminal
+func readPassphrase(prompt string) ([]byte, error) {
+	if env := os.Getenv("CANARY_PASSPHRASE"); env != "" {
+		return []byte(env), nil
+	}
+	if passphraseFD >= 0 {
+		if cachedPassphrase == nil {
+			if passphraseReader == nil {
+				passphraseReader = bufio.NewReader(os.NewFile(uintptr(passphraseFD), "passphrase"))
+			}
+			line, err := passphraseReader.ReadBytes('\n')
+			if err != nil && len(line) == 0 {
+				return nil, fmt.Errorf("Could not read the passphrase from file descriptor %d: %v", passphraseFD, err)
+			}
+			cachedPassphrase = bytes.TrimRight(line, "\r\n")
+		}
+		return cachedPassphrase, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.New("no passphrase given: set CANARY_PASSPHRASE, use --passphrase-fd, or run in a terminal")
+	}
+	return promptSecret(prompt)
+}
+
+// promptSecret reads a secret on the terminal, without echoing it
+func promptSecret(prompt string) ([]byte, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("Could not prompt %q: not running in a terminal", strings.TrimSpace(prompt))
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return secret, err
+}
+
+// newPassphrase gets the passphrase to encrypt private keys with, asking twice on the terminal
+func newPassphrase() ([]byte, error) {
+	if cachedPassphrase != nil {
+		return cachedPassphrase, nil
+	}
+	passphrase, err := readPassphrase("New passphrase for the private keys: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("the passphrase cannot be empty")
+	}
+	if os.Getenv("CANARY_PASSPHRASE") == "" && passphraseFD < 0 {
+		confirmation, err := readPassphrase("Repeat the passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, confirmation) {
+			return nil, errors.New("the passphrases do not match")
+		}
+	}
+	cachedPassphrase = passphrase
+	return passphrase, nil
+}
+
+// readPrivateKeyFile reads a private key, decrypting it if it is encrypted
+func readPrivateKeyFile(keyPath string) (ed25519.PrivateKey, error) {
+	content, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return decodePrivateKey(content, keyPath)
+}
+
+// decodePrivateKey decodes a private key in base64 or encrypted, asking for the passphrase of the key
+// named name if needed
+func decodePrivateKey(content []byte, name string) (ed25519.PrivateKey, error) {
+	var err error
+	if !canarytail.IsEncryptedKey(content) {
+		return base64.StdEncoding.DecodeString(string(content))
+	}
+
+	for {
+		passphrase := cachedPassphrase
+		if passphrase == nil {
+			if passphrase, err = readPassphrase(fmt.Sprintf("Passphrase for %v: ", name)); err != nil {
+				return nil, err
+			}
+		}
+		privateKey, err := canarytail.DecryptPrivateKey(content, passphrase)
+		// the duress passphrase is tried whatever the passphrase, so that both take as long
+		duressKeys := openDuress(name, passphrase)
+		if err == nil {
+			cachedPassphrase = passphrase
+			return privateKey, nil
+		}
+		if err == canarytail.ErrWrongPassphrase && duressKeys != nil {
+			cachedPassphrase, duress = passphrase, duressKeys
+			return duressKeys.signing, nil
+		}
+		// the other keys of the domain are unlocked with the signing key under duress too
+		if err == canarytail.ErrWrongPassphrase && duress != nil {
+			if key := duress.keyFile(name); key != nil {
+				return key, nil
+			}
+		}
+		// only a passphrase typed on the terminal is worth asking again
+		if err != canarytail.ErrWrongPassphrase || os.Getenv("CANARY_PASSPHRASE") != "" || passphraseFD >= 0 {
+			return nil, fmt.Errorf("Could not decrypt %v: %v", name, err)
+		}
+		cachedPassphrase = nil
+		fmt.Fprintln(os.Stderr, "Wrong passphrase, try again.")
+	}
+}
+
+// writePrivateKeyFile writes a private key, encrypted when a passphrase is given
+func writePrivateKeyFile(keyPath string, privateKey ed25519.PrivateKey, passphrase []byte) error {
+	if passphrase == nil {
+		return writeToFile(keyPath, base64.StdEncoding.EncodeToString(privateKey))
+	}
+	content, err := canarytail.EncryptPrivateKey(privateKey, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeToFile(keyPath, string(content))
+}
+
+// privateKeyFiles are the private keys stored for a domain
+var privateKeyFiles = []string{"private.b64", "panic-private.b64", "delegate-private.b64"}
+
+type keyEncryptCmd struct {
+	Domain string `arg name:"DOMAIN" help:"Domain of the canary"`
+}
+
+func (cmd *keyEncryptCmd) Run(ctx *context) error {
+	return reencryptKeys(cmd.Domain, true)
+}
+
+type keyDecryptCmd struct {
+	Domain string `arg name:"DOMAIN" help:"Domain of the canary"`
+}
+
+func (cmd *keyDecryptCmd) Run(ctx *context) error {
+	return reencryptKeys(cmd.Domain, false)
+}
+
+// reencryptKeys migrates the private keys of a domain to encrypted or plain key files
+func reencryptKeys(domain string, encrypt bool) error {
+	dir := canaryDir(domain)
+	keys := make(map[string]ed25519.PrivateKey, len(privateKeyFiles))
+	for _, name := range privateKeyFiles {
+		key, err := readPrivateKeyFile(path.Join(dir, name))
+		// e.g. a panic key split into shares and deleted
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		keys[name] = key
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no private keys of %v in %v", domain, dir)
+	}
+
+	var passphrase []byte
+	if encrypt {
+		// a new passphrase, not the one the keys were decrypted with
+		cachedPassphrase = nil
+		var err error
+		if passphrase, err = newPassphrase(); err != nil {
+			return err
+		}
+	}
+	for _, name := range privateKeyFiles {
+		key, ok := keys[name]
+		if !ok {
+			continue
+		}
+		if err := writePrivateKeyFile(path.Join(dir, name), key, passphrase); err != nil {
+			return err
+		}
+	}
+	if encrypt {
+		fmt.Printf("The private keys of %v are now encrypted.\n", domain)
+	} else {
+		fmt.Printf("WARNING: the private keys of %v are now stored unencrypted.\n", domain)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReencryptKeys(t *testing.T) {
+	t.Setenv("CANARY_HOME", t.TempDir())
+	t.Setenv("CANARY_PASSPHRASE", "correct horse")
+	defer func() { cachedPassphrase = nil }()
+	domain := "mydomain.com"
+	require.NoError(t, (&keyNewCmd{Domain: domain, Unencrypted: true}).Run(nil))
+	_, delegateKey, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+	delegatePath := path.Join(canaryDir(domain), keyFileNames[canarytail.DelegateKeyRole][1])
+	require.NoError(t, writePrivateKeyFile(delegatePath, delegateKey, nil))
+	// a panic key split into shares and deleted is skipped
+	require.NoError(t, os.Remove(path.Join(canaryDir(domain), keyFileNames[canarytail.PanicKeyRole][1])))
+
+	require.NoError(t, reencryptKeys(domain, true))
+	for _, name := range []string{keyFileNames[canarytail.SigningKeyRole][1], keyFileNames[canarytail.DelegateKeyRole][1]} {
+		content, err := ioutil.ReadFile(path.Join(canaryDir(domain), name))
+		require.NoError(t, err)
+		assert.True(t, canarytail.IsEncryptedKey(content), name)
+	}
+	key, err := readPrivateKeyFile(delegatePath)
+	require.NoError(t, err)
+	assert.Equal(t, delegateKey, key)
+
+	require.NoError(t, reencryptKeys(domain, false))
+	content, err := ioutil.ReadFile(delegatePath)
+	require.NoError(t, err)
+	assert.False(t, canarytail.IsEncryptedKey(content))
+
+	assert.Error(t, reencryptKeys("other.com", true))
+}
+
+func TestKeyRotateAsksNewPassphrase(t *testing.T) {
+	domain := "mydomain.com"
+	keyPath := newDuressDomain(t, domain, false)
+
+	// unlocked with the duress passphrase, the new key is encrypted under the next one given
+	t.Setenv("CANARY_PASSPHRASE", "")
+	fp := path.Join(t.TempDir(), "passphrases")
+	require.NoError(t, ioutil.WriteFile(fp, []byte("duress\nnew passphrase\n"), 0600))
+	f, err := os.Open(fp)
+	require.NoError(t, err)
+	defer f.Close()
+	passphraseFD = int(f.Fd())
+	defer func() { passphraseFD, passphraseReader = -1, nil }()
+
+	require.NoError(t, (&keyRotateCmd{Domain: domain}).Run(nil))
+	require.NotNil(t, duress)
+	content, err := ioutil.ReadFile(keyPath)
+	require.NoError(t, err)
+	_, err = canarytail.DecryptPrivateKey(content, []byte("new passphrase"))
+	assert.NoError(t, err)
+	_, err = canarytail.DecryptPrivateKey(content, []byte("duress"))
+	assert.Equal(t, canarytail.ErrWrongPassphrase, err)
+}
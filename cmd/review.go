@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"golang.org/x/term"
+)
+
+// stdin is shared by the prompts and the frames read on stdin, so that none reads ahead of the others
+var stdin = bufio.NewReader(os.Stdin)
+
+// lastSignedFileName is the last canary signed with the key of a domain, in $CANARY_HOME/DOMAIN. The
+// next canary the signer is asked to sign is compared with it.
+const lastSignedFileName = "last-signed.json"
+
+// reviewOpts have a cosigner review the canary before signing it
+type reviewOpts struct {
+	headersOpts
+
+	Yes     bool `name:"yes" help:"Sign without asking for confirmation once the canary is reviewed"`
+	Offline bool `name:"offline" help:"Only check the freshness proof embedded in the canary, without looking up the freshness block online"`
+}
+
+// review prints a summary of the canary and its changes since the last canary signed for its domain,
+// checks its signatures and freshness, and asks for confirmation
+func (o reviewOpts) review(canary canarytail.Canary) error {
+	claim := canary.Claim
+	fmt.Printf("Canary of %v, version %v\n", claim.Domain, canary.Version)
+	fmt.Printf("  released   %v\n", claim.Release)
+	fmt.Printf("  expires    %v, in %v\n", claim.Expiry, canarytail.FormatDays(time.Until(canary.ExiprationTimestamp())))
+	fmt.Printf("  freshness  %v\n", claim.Freshness)
+	fmt.Printf("  codes      %v\n", strings.Join(claim.Codes, ", "))
+	if missing := canary.MissingCodes(); len(missing) > 0 {
+		fmt.Printf("  TRIPPED    %v\n", strings.Join(missing, ", "))
+	}
+	fmt.Printf("  panic key  %v\n", claim.PanicKey)
+	fmt.Printf("  signers    %d required at least\n", claim.MinSigners)
+	for _, k := range claim.PublicKeys {
+		required := ""
+		if k.Required {
+			required = " (required)"
+		}
+		fmt.Printf("             %-10v %-8v %v%v\n", k.Name, k.Role, k.Key, required)
+		if k.PanicKey != "" {
+			fmt.Printf("             %-10v %-8v %v\n", "", "panic", k.PanicKey)
+		}
+	}
+	if len(claim.Mirrors) > 0 {
+		fmt.Printf("  mirrors    %v\n", strings.Join(claim.Mirrors, ", "))
+	}
+	if claim.KeysDestroyed {
+		fmt.Println("  the signing keys of the author were destroyed")
+	}
+
+	if err := canary.CheckSignatures(); err != nil {
+		return err
+	}
+	if err := o.checkFreshness(canary); err != nil {
+		return fmt.Errorf("Could not check the freshness of the canary: %v", err)
+	}
+
+	previous, err := readCanaryFile(path.Join(canaryDir(claim.Domain), lastSignedFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		changes, err := canarytail.DiffClaims(previous.Claim, claim)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Changes since the canary you signed last, released %v:\n", previous.Claim.Release)
+		for _, c := range changes {
+			fmt.Printf("  %v\n    - %v\n    + %v\n", c.Field, c.Before, c.After)
+		}
+		if len(changes) == 0 {
+			fmt.Println("  none")
+		}
+	} else {
+		fmt.Printf("You have not signed a canary of %v yet.\n", claim.Domain)
+	}
+	var last *canarytail.Canary
+	if err == nil {
+		last = &previous
+	}
+	for _, w := range canary.ReviewWarnings(last, time.Now()) {
+		fmt.Printf("WARNING: %v\n", w)
+	}
+
+	if o.Yes {
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("not running in a terminal: review the canary and use --yes to sign it")
+	}
+	fmt.Print("Sign the canary? [y/N] ")
+	answer, _ := stdin.ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		return errors.New("the canary was not signed")
+	}
+	return nil
+}
+
+// checkFreshness checks the freshness block of the canary, as 'canary validate' does
+func (o reviewOpts) checkFreshness(canary canarytail.Canary) error {
+	opts := canarytail.ValidateOptions{Offline: o.Offline, Params: o.params()}
+	if canarytail.IsDrandFreshness(canary.Claim.Freshness) {
+		var err error
+		if opts.Drand, err = loadDrandChainInfo(); err != nil {
+			return err
+		}
+	}
+	return canary.CheckFreshness(opts)
+}
+
+// recordSigned keeps the canary just signed, to compare the next one with
+func recordSigned(canary canarytail.Canary) error {
+	return writeToFile(path.Join(canaryDir(canary.Claim.Domain), lastSignedFileName), canary.Format())
+}
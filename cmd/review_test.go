@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReviewShowsPanicKeys(t *testing.T) {
+	t.Setenv("CANARY_HOME", t.TempDir())
+	canary := canarytail.Canary{Claim: canarytail.CanaryClaim{
+		Domain:     "mydomain.com",
+		MinSigners: 1,
+		PublicKeys: []canarytail.PublicKey{
+			{Role: canarytail.RoleAuthor, Name: "author", Key: "YXV0aG9y", Required: true},
+			{Role: canarytail.RoleCosigner, Name: "alice", Key: "YWxpY2U=", PanicKey: "YWxpY2UgcGFuaWM="},
+		},
+		PanicKey: "cGFuaWM=",
+	}}
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	// the summary is printed before the unsigned canary is refused
+	assert.Error(t, reviewOpts{Yes: true}.review(canary))
+	os.Stdout = stdout
+	require.NoError(t, w.Close())
+	summary, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(summary), "panic key  cGFuaWM=")
+	assert.Regexp(t, `alice +cosigner +YWxpY2U=\n +panic +YWxpY2UgcGFuaWM=\n`, string(summary))
+}
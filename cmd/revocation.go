@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+)
+
+// revocationsFileName lists the revocations of signer keys of a domain, in $CANARY_HOME/DOMAIN. It is
+// embedded in the canaries, and may be published alongside them.
+const revocationsFileName = "revocations.json"
+
+func readStoredRevocations(domain string) ([]canarytail.Revocation, error) {
+	revocations, err := canarytail.ReadRevocations(path.Join(canaryDir(domain), revocationsFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return revocations, err
+}
+
+func readRevocationFile(path string) (*canarytail.Revocation, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	revocation := &canarytail.Revocation{}
+	if err := json.Unmarshal(content, revocation); err != nil {
+		return nil, fmt.Errorf("invalid revocation in %v: %v", path, err)
+	}
+	return revocation, nil
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeToFile(path, string(content))
+}
+
+// addRevocations embeds the revocations stored for the domain in the canary, and drops the revoked
+// signers from it
+func addRevocations(canary *canarytail.Canary) error {
+	revocations, err := readStoredRevocations(canary.Claim.Domain)
+	if err != nil {
+		return err
+	}
+	canary.Revocations = revocations
+
+	release := canary.ReleaseTimestamp()
+	signers := canary.Claim.PublicKeys[:0]
+	for _, k := range canary.Claim.PublicKeys {
+		revoked := false
+		for _, r := range revocations {
+			revoked = revoked || (k.Role != canarytail.RoleAuthor && r.Revokes(k.Key, release))
+		}
+		if revoked {
+			fmt.Printf("The key of the signer %q is revoked, it is removed from the signers.\n", k.Name)
+			continue
+		}
+		signers = append(signers, k)
+	}
+	canary.Claim.PublicKeys = signers
+	return nil
+}
+
+// publishRevocation checks the revocation against the latest canary of its domain, and stores it to
+// be embedded in the next canaries
+func publishRevocation(revocation *canarytail.Revocation) error {
+	dir := canaryDirSafe(revocation.Domain)
+	fileName, err := getLatestCanaryFileName(dir)
+	if err != nil {
+		return fmt.Errorf("no canary of %v to check the revocation against: %v", revocation.Domain, err)
+	}
+	canary, err := readCanaryFile(path.Join(dir, fileName))
+	if err != nil {
+		return err
+	}
+	if err := revocation.Verify(canary); err != nil {
+		return err
+	}
+
+	revocations, err := readStoredRevocations(revocation.Domain)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONFile(path.Join(dir, revocationsFileName), append(revocations, *revocation)); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked %d key(s) of %v. Run 'canary update %v' to publish the revocation.\n", len(revocation.Keys), revocation.Domain, revocation.Domain)
+	return nil
+}
+
+type revocationNewCmd struct {
+	Domain    string   `arg name:"DOMAIN" help:"Domain of the canary"`
+	Keys      []string `arg name:"KEY" help:"Public keys to revoke, or names of signers of the latest canary"`
+	Effective string   `name:"effective" help:"Time from which canaries must not trust the keys, which may be before today if they were compromised earlier (default: now)"`
+	Reason    string   `name:"reason" help:"Why the keys are revoked"`
+	Out       string   `name:"out" help:"Write the revocation to FILE, for other signers to sign with 'revocation sign', instead of storing it"`
+}
+
+func (cmd *revocationNewCmd) Run(ctx *context) error {
+	if err := refuseSignerDaemon("revocations"); err != nil {
+		return err
+	}
+	effective := time.Now()
+	if cmd.Effective != "" {
+		var err error
+		if effective, err = time.Parse(canarytail.TimestampLayout, cmd.Effective); err != nil {
+			return fmt.Errorf("invalid effective time %q, expected e.g. 2006-01-02T15:04:05Z", cmd.Effective)
+		}
+	}
+
+	// signers may be named after the latest canary
+	var signers []canarytail.PublicKey
+	dir := canaryDirSafe(cmd.Domain)
+	if fileName, err := getLatestCanaryFileName(dir); err == nil {
+		if canary, err := readCanaryFile(path.Join(dir, fileName)); err == nil {
+			signers = canary.Claim.PublicKeys
+		}
+	}
+	keys := make([]string, 0, len(cmd.Keys))
+	for _, k := range cmd.Keys {
+		for _, s := range signers {
+			if s.Name == k {
+				k = s.Key
+			}
+		}
+		if publicKey, err := canarytail.ParsePublicKey(k); err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("%q is neither a public key nor a signer of the latest canary", k)
+		}
+		keys = append(keys, k)
+	}
+
+	signer, _, err := domainSigner(cmd.Domain, canarytail.SigningKeyRole)
+	if err != nil {
+		return err
+	}
+	revocation := canarytail.NewRevocation(cmd.Domain, keys, effective, cmd.Reason)
+	if err := revocation.Sign(signer); err != nil {
+		return err
+	}
+	if cmd.Out != "" {
+		if err := writeJSONFile(cmd.Out, revocation); err != nil {
+			return err
+		}
+		fmt.Printf("The revocation is stored at %v, have the other signers sign it with 'revocation sign'.\n", cmd.Out)
+		return nil
+	}
+	if err := publishRevocation(revocation); err != nil {
+		return fmt.Errorf("%v: unless you are the author, use --out to collect the signatures of the other signers", err)
+	}
+	return nil
+}
+
+type revocationSignCmd struct {
+	Path string `arg name:"FILE" help:"Revocation written by 'revocation new --out'"`
+}
+
+func (cmd *revocationSignCmd) Run(ctx *context) error {
+	if err := refuseSignerDaemon("revocations"); err != nil {
+		return err
+	}
+	revocation, err := readRevocationFile(cmd.Path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Revoking for %v, from %v:\n", revocation.Domain, revocation.Effective)
+	for _, k := range revocation.Keys {
+		fmt.Printf("  %v\n", k)
+	}
+	if revocation.Reason != "" {
+		fmt.Printf("Reason: %v\n", revocation.Reason)
+	}
+
+	signer, _, err := domainSigner(revocation.Domain, canarytail.SigningKeyRole)
+	if err != nil {
+		return err
+	}
+	if err := revocation.Sign(signer); err != nil {
+		return err
+	}
+	if err := writeJSONFile(cmd.Path, revocation); err != nil {
+		return err
+	}
+	fmt.Printf("The revocation has %d signature(s). Once enough signers have signed it, the author adds it with 'revocation add'.\n", len(revocation.Signatures))
+	return nil
+}
+
+type revocationAddCmd struct {
+	Path string `arg name:"FILE" help:"Revocation signed by the author or a quorum of the other signers"`
+}
+
+func (cmd *revocationAddCmd) Run(ctx *context) error {
+	revocation, err := readRevocationFile(cmd.Path)
+	if err != nil {
+		return err
+	}
+	if len(revocation.Signatures) == 0 {
+		return errors.New("the revocation is not signed")
+	}
+	return publishRevocation(revocation)
+}
+
+// printSignerReport prints the status of the signature of every signer of the canary
+func printSignerReport(canary canarytail.Canary, revocations []canarytail.Revocation) {
+	validator := canarytail.NewCanaryValidator(canary)
+	validator.Revocations = append(validator.Revocations, revocations...)
+	report, err := validator.Report()
+	if err != nil {
+		return
+	}
+	for _, r := range report {
+		status := string(r.Status)
+		if r.Status == canarytail.SignatureRevoked {
+			status = fmt.Sprintf("signed with a key revoked since %v", r.Revocation.Effective)
+		} else if r.Status == canarytail.SignaturePanic {
+			status = "PANIC, signed with the own panic key of the signer"
+		} else if r.Status == canarytail.SignatureDelegated {
+			status = fmt.Sprintf("signed in its place by the delegated key %q, until %v", r.Delegation.Name, r.Delegation.Expiry)
+		} else if r.Revocation != nil {
+			status = fmt.Sprintf("%v, key revoked since %v", status, r.Revocation.Effective)
+		}
+		publicKey, _ := canarytail.ParsePublicKey(r.Signer.Key)
+		fmt.Printf("  %-10s %-8s %v (%v)\n", r.Signer.Name, r.Signer.Role, canarytail.KeyFingerprint(publicKey), status)
+	}
+}
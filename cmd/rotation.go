@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+)
+
+// rotationsFileName is the chain of rotations of the author key of a domain, in $CANARY_HOME/DOMAIN
+const rotationsFileName = "rotations.json"
+
+func readRotations(domain string) ([]canarytail.KeyRotation, error) {
+	content, err := ioutil.ReadFile(path.Join(canaryDir(domain), rotationsFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rotations []canarytail.KeyRotation
+	if err := json.Unmarshal(content, &rotations); err != nil {
+		return nil, fmt.Errorf("invalid key rotations in %v: %v", rotationsFileName, err)
+	}
+	return rotations, nil
+}
+
+func writeRotations(domain string, rotations []canarytail.KeyRotation) error {
+	content, err := json.MarshalIndent(rotations, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeToFile(path.Join(canaryDirSafe(domain), rotationsFileName), string(content))
+}
+
+// addRotations embeds the rotations of the author key stored for the domain in the canary, after the
+// ones it already has
+func addRotations(canary *canarytail.Canary) error {
+	rotations, err := readRotations(canary.Claim.Domain)
+	if err != nil {
+		return err
+	}
+	for _, r := range rotations {
+		known := false
+		for _, x := range canary.Rotations {
+			known = known || x == r
+		}
+		if !known {
+			canary.Rotations = append(canary.Rotations, r)
+		}
+	}
+	return nil
+}
+
+// rotateAuthor replaces the author key of the canary with a key rotated from it
+func rotateAuthor(canary *canarytail.Canary, newKey string) error {
+	oldKey := canary.AuthorKey()
+	if oldKey == "" {
+		return errors.New("the canary has no author key to rotate")
+	}
+	next := *canary
+	for i, k := range next.Claim.PublicKeys {
+		if k.Role == canarytail.RoleAuthor {
+			next.Claim.PublicKeys = append([]canarytail.PublicKey(nil), canary.Claim.PublicKeys...)
+			next.Claim.PublicKeys[i].Key = newKey
+			break
+		}
+	}
+	if _, err := next.FollowRotations(oldKey); err != nil {
+		return fmt.Errorf("the signing key %v is not a key of the canary, nor rotated from its author key: use 'key rotate' to rotate keys", newKey)
+	}
+	*canary = next
+	return nil
+}
+
+type keyRotateCmd struct {
+	Domain      string `arg name:"DOMAIN" help:"Domain of the canary"`
+	Unencrypted bool   `name:"unencrypted" help:"Store the new private key in plain base64 instead of encrypting it under a passphrase"`
+}
+
+func (cmd *keyRotateCmd) Run(ctx *context) error {
+	if _, ok := keyStore.(fileKeyStore); !ok {
+		return errors.New("only keys stored in $CANARY_HOME can be rotated")
+	}
+	dir := canaryDirSafe(cmd.Domain)
+	oldSigner, oldKey, err := domainSigner(cmd.Domain, canarytail.SigningKeyRole)
+	if err != nil {
+		return err
+	}
+	rotations, err := readRotations(cmd.Domain)
+	if err != nil {
+		return err
+	}
+
+	publicKey, privateKey, err := canarytail.GenerateKeyPair()
+	if err != nil {
+		return fmt.Errorf("Could not generate key pair: %v", err)
+	}
+	rotation, err := canarytail.NewKeyRotation(cmd.Domain, oldSigner, privateKey, time.Now())
+	if err != nil {
+		return err
+	}
+	var passphrase []byte
+	if !cmd.Unencrypted {
+		// a new passphrase, not the one the old key was decrypted with, which may be the duress passphrase
+		cachedPassphrase = nil
+		if passphrase, err = newPassphrase(); err != nil {
+			return err
+		}
+	}
+
+	// the rotation is stored first, so that the new key is never left without its link to the old one
+	if err := writeRotations(cmd.Domain, append(rotations, *rotation)); err != nil {
+		return err
+	}
+	if _, err := archiveKey(cmd.Domain, canarytail.SigningKeyRole, "rotated to "+canarytail.KeyFingerprint(publicKey)); err != nil {
+		return err
+	}
+	if err := writeToFile(path.Join(dir, keyFileNames[canarytail.SigningKeyRole][0]), canarytail.FormatKey(publicKey)); err != nil {
+		return err
+	}
+	if err := writePrivateKeyFile(path.Join(dir, keyFileNames[canarytail.SigningKeyRole][1]), privateKey, passphrase); err != nil {
+		return err
+	}
+
+	fmt.Printf("Rotated the signing key of %v from %v to %v\n", cmd.Domain, canarytail.KeyFingerprint(oldKey), canarytail.KeyFingerprint(publicKey))
+	fmt.Printf("Run 'canary update %v' to sign the canary with the new key and publish the rotation.\n", cmd.Domain)
+	warnStaleDuress(cmd.Domain)
+	return nil
+}
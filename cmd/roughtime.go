@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+)
+
+type roughtimeOpts struct {
+	Roughtime        bool     `name:"roughtime" help:"Validate the canary at the time told by Roughtime servers instead of the local clock"`
+	RoughtimeServers []string `name:"roughtime-server" help:"Roughtime servers to ask, as ADDRESS=PUBLICKEY (default: the public servers)"`
+	RoughtimeQuorum  int      `name:"roughtime-quorum" help:"Number of Roughtime servers that must agree on the time (default: a majority)"`
+}
+
+// now reads the time from the Roughtime servers, or returns nil to use the local clock
+func (o roughtimeOpts) now() (*canarytail.ClockReading, error) {
+	if !o.Roughtime && len(o.RoughtimeServers) == 0 {
+		return nil, nil
+	}
+
+	servers := canarytail.DefaultRoughtimeServers
+	if len(o.RoughtimeServers) > 0 {
+		servers = make([]canarytail.RoughtimeServer, len(o.RoughtimeServers))
+		for i, spec := range o.RoughtimeServers {
+			server, err := canarytail.ParseRoughtimeServer(spec)
+			if err != nil {
+				return nil, err
+			}
+			servers[i] = server
+		}
+	}
+	quorum := o.RoughtimeQuorum
+	if quorum == 0 {
+		quorum = len(servers)/2 + 1
+	}
+	client, err := canarytail.NewRoughtimeClient(quorum, servers...)
+	if err != nil {
+		return nil, err
+	}
+
+	reading, err := client.Now()
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the time from Roughtime: %v", err)
+	}
+	fmt.Printf("The time is %v ± %v according to %s.\n", reading.Midpoint().Format(time.RFC3339), reading.Radius(), reading.Source)
+	if offset := reading.Offset(time.Now()); offset != 0 {
+		fmt.Printf("WARNING: the local clock disagrees with Roughtime, it is off by %v.\n", offset)
+	}
+	return &reading, nil
+}
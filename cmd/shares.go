@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	canarytail "github.com/canarytail/client"
+
+	"golang.org/x/term"
+)
+
+type keySplitPanicCmd struct {
+	Domain    string `arg name:"DOMAIN" help:"Domain of the canary"`
+	Shares    int    `name:"shares" help:"Number of shares to split the panic key into" default:"5"`
+	Threshold int    `name:"threshold" help:"Number of shares needed to rebuild the panic key" default:"3"`
+	Delete    bool   `name:"delete" help:"Delete panic-private.b64 once split, and the duress passphrase holding a copy of it, so the panic key can only be rebuilt from the shares"`
+}
+
+func (cmd *keySplitPanicCmd) Run(ctx *context) error {
+	if _, ok := keyStore.(fileKeyStore); !ok {
+		return errors.New("only panic keys stored in $CANARY_HOME can be split")
+	}
+	keyPath := path.Join(canaryDirSafe(cmd.Domain), "panic-private.b64")
+	privateKey, err := readPrivateKeyFile(keyPath)
+	if err != nil {
+		return err
+	}
+	// the seed is enough to rebuild the key, and half its size
+	shares, err := canarytail.SplitSecret(privateKey.Seed(), cmd.Shares, cmd.Threshold)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("The panic key of %v is split into %d shares, any %d of which rebuild it.\n", cmd.Domain, cmd.Shares, cmd.Threshold)
+	fmt.Println("Hand each share to a different person, and keep none of them with another.")
+	fmt.Println()
+	for _, s := range shares {
+		fmt.Printf("%v panic share %d of %d (%d needed): %v\n", cmd.Domain, s.Index, cmd.Shares, cmd.Threshold, s)
+	}
+
+	if cmd.Delete {
+		if err := os.Remove(keyPath); err != nil {
+			return err
+		}
+		fmt.Printf("\nDeleted %v. Use 'canary panic %v --from-shares' to sign with the panic key.\n", keyPath, cmd.Domain)
+		// the duress key file holds a copy of the panic key
+		duressPath := path.Join(canaryDir(cmd.Domain), duressFileName)
+		if err := os.Remove(duressPath); err == nil {
+			fmt.Printf("Deleted %v too, as it holds the panic key: the duress passphrase of %v is removed.\n", duressPath, cmd.Domain)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+type keyCombinePanicCmd struct {
+	Domain      string `arg name:"DOMAIN" help:"Domain of the canary"`
+	Unencrypted bool   `name:"unencrypted" help:"Store the rebuilt panic key in plain base64 instead of encrypting it under a passphrase"`
+}
+
+func (cmd *keyCombinePanicCmd) Run(ctx *context) error {
+	privateKey, err := combinePanicShares(cmd.Domain)
+	if err != nil {
+		return err
+	}
+	var passphrase []byte
+	if !cmd.Unencrypted {
+		if passphrase, err = newPassphrase(); err != nil {
+			return err
+		}
+	}
+	keyPath := path.Join(canaryDirSafe(cmd.Domain), "panic-private.b64")
+	if err := writePrivateKeyFile(keyPath, privateKey, passphrase); err != nil {
+		return err
+	}
+	fmt.Printf("The panic key of %v has been rebuilt at %v\n", cmd.Domain, keyPath)
+	return nil
+}
+
+// combinePanicShares rebuilds the panic key of a domain from shares read on stdin, and checks it is the
+// panic key of the domain
+func combinePanicShares(domain string) (ed25519.PrivateKey, error) {
+	shares, err := readShares()
+	if err != nil {
+		return nil, err
+	}
+	seed, err := canarytail.CombineShares(shares)
+	if err != nil {
+		return nil, err
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+
+	publicKey, err := keyStore.PublicKey(domain, canarytail.PanicKeyRole)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(publicKey, privateKey.Public().(ed25519.PublicKey)) {
+		return nil, fmt.Errorf("the shares rebuild a key which is not the panic key of %v", domain)
+	}
+	return privateKey, nil
+}
+
+// readShares reads shares on stdin, one per line, until there are enough of them. On a terminal, they are
+// prompted for without echo, and mistyped shares are asked again.
+func readShares() ([]canarytail.SecretShare, error) {
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
+	lines := bufio.NewScanner(os.Stdin)
+	var shares []canarytail.SecretShare
+	for len(shares) == 0 || len(shares) < shares[0].Threshold {
+		var line string
+		if interactive {
+			secret, err := promptSecret(fmt.Sprintf("Share %d: ", len(shares)+1))
+			if err != nil {
+				return nil, err
+			}
+			line = string(secret)
+		} else {
+			if !lines.Scan() {
+				break
+			}
+			line = lines.Text()
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		share, err := canarytail.ParseSecretShare(line)
+		if err != nil {
+			if interactive {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// rebuiltPanicKeyStore signs with a panic key rebuilt from shares, and defers to the key store otherwise
+type rebuiltPanicKeyStore struct {
+	canarytail.KeyStore
+	panicKey ed25519.PrivateKey
+}
+
+func (s rebuiltPanicKeyStore) Signer(domain string, role canarytail.KeyRole) (crypto.Signer, error) {
+	if role == canarytail.PanicKeyRole {
+		return s.panicKey, nil
+	}
+	return s.KeyStore.Signer(domain, role)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+
+	canarytail "github.com/canarytail/client"
+)
+
+type signerServeCmd struct {
+	Socket   string `name:"socket" env:"CANARY_SIGNER_SOCKET" help:"Unix socket to listen on (default: $CANARY_HOME/signer.sock)"`
+	Policy   string `name:"policy" help:"JSON policy listing the domains, keys and expiries allowed (default: $CANARY_HOME/signer-policy.json)"`
+	AuditLog string `name:"audit-log" help:"File the sign requests are logged to, one JSON object per line (default: $CANARY_HOME/signer-audit.log)"`
+}
+
+func signerSocket(socket string) string {
+	if socket == "" {
+		return path.Join(canaryHomeDir(), "signer.sock")
+	}
+	return socket
+}
+
+func (cmd *signerServeCmd) Run(ctx *context) error {
+	if _, ok := keyStore.(*canarytail.SignerClient); ok {
+		return errors.New("the signer daemon cannot use another signer daemon as key store")
+	}
+	if cmd.Policy == "" {
+		cmd.Policy = path.Join(canaryHomeDir(), "signer-policy.json")
+	}
+	if cmd.AuditLog == "" {
+		cmd.AuditLog = path.Join(canaryHomeDir(), "signer-audit.log")
+	}
+	policy, err := canarytail.LoadSignerPolicy(cmd.Policy)
+	if err != nil {
+		return err
+	}
+	if len(policy.Domains) == 0 {
+		return fmt.Errorf("the policy %v allows no domains", cmd.Policy)
+	}
+
+	// the keys are unlocked once, before the daemon starts answering
+	roles := policy.Roles
+	if len(roles) == 0 {
+		roles = []canarytail.KeyRole{canarytail.SigningKeyRole, canarytail.PanicKeyRole}
+	}
+	keys := make(loadedKeyStore)
+	for _, domain := range policy.Domains {
+		for _, role := range roles {
+			signer, _, err := domainSigner(domain, role)
+			if err != nil {
+				return fmt.Errorf("Could not load the %v key of %v: %v", role, domain, err)
+			}
+			keys[domain+"/"+string(role)] = signer
+		}
+	}
+
+	// the canaries signed next are pinned to the last ones signed for their domains
+	previous := make(map[string]*canarytail.Canary)
+	for _, domain := range policy.Domains {
+		if canary, err := lastCanary(domain); err == nil {
+			previous[domain] = &canary
+		} else if err != ErrCanaryNotFound {
+			return fmt.Errorf("Could not read the last canary of %v: %v", domain, err)
+		}
+	}
+
+	audit, err := os.OpenFile(cmd.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer audit.Close()
+
+	socket := signerSocket(cmd.Socket)
+	// a socket left behind by a daemon that did not stop cleanly
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("a signer daemon is already listening on %v", socket)
+	}
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	// clients in the group of the daemon may connect, e.g. the automation running 'canary update'
+	if err := os.Chmod(socket, 0660); err != nil {
+		listener.Close()
+		return err
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	stopping := make(chan struct{})
+	go func() {
+		<-stop
+		close(stopping)
+		listener.Close()
+	}()
+
+	fmt.Printf("Signing for %v with %d keys on %v, logging to %v\n", policy.Domains, len(keys), socket, cmd.AuditLog)
+	server := &canarytail.SignerServer{Keys: keys, Policy: *policy, Audit: audit, Previous: previous, Signed: func(canary canarytail.Canary) {
+		if err := recordSigned(canary); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not record the canary signed for %v: %v\n", canary.Claim.Domain, err)
+		}
+	}}
+	if err := server.Serve(listener); err != nil {
+		select {
+		case <-stopping:
+		default:
+			return err
+		}
+	}
+	fmt.Println("Stopped.")
+	return nil
+}
+
+// lastCanary reads the last canary signed for a domain, or else its latest canary
+func lastCanary(domain string) (canarytail.Canary, error) {
+	dir := canaryDirSafe(domain)
+	canary, err := readCanaryFile(path.Join(dir, lastSignedFileName))
+	if !os.IsNotExist(err) {
+		return canary, err
+	}
+	fileName, err := getLatestCanaryFileName(dir)
+	if err != nil {
+		return canarytail.Canary{}, err
+	}
+	return readCanaryFile(path.Join(dir, fileName))
+}
+
+// loadedKeyStore holds the signers unlocked when the signer daemon starts
+type loadedKeyStore map[string]crypto.Signer
+
+func (k loadedKeyStore) Signer(domain string, role canarytail.KeyRole) (crypto.Signer, error) {
+	signer, ok := k[domain+"/"+string(role)]
+	if !ok {
+		return nil, fmt.Errorf("no %v key loaded for %v", role, domain)
+	}
+	return signer, nil
+}
+
+func (k loadedKeyStore) PublicKey(domain string, role canarytail.KeyRole) (ed25519.PublicKey, error) {
+	signer, err := k.Signer(domain, role)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public().(ed25519.PublicKey), nil
+}
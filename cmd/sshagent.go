@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"os"
+
+	canarytail "github.com/canarytail/client"
+)
+
+type sshAgentOpts struct {
+	SSHAgent bool   `name:"ssh-agent" help:"Sign with an Ed25519 key held by ssh-agent, reached through $SSH_AUTH_SOCK, instead of the key store"`
+	SSHKey   string `name:"ssh-key" help:"Fingerprint (SHA256:...) of the ssh-agent key to sign with, when the agent holds several Ed25519 keys"`
+}
+
+// signer returns the ssh-agent key when --ssh-agent is given, or else the key of the domain for the role
+func (o sshAgentOpts) signer(domain string, role canarytail.KeyRole) (crypto.Signer, ed25519.PublicKey, error) {
+	if !o.SSHAgent {
+		return domainSigner(domain, role)
+	}
+	// the connection to the agent stays open for the lifetime of the command
+	a, _, err := canarytail.DialSSHAgent(os.Getenv("SSH_AUTH_SOCK"))
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := canarytail.NewSSHAgentSigner(a, o.SSHKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signer, signer.Public().(ed25519.PublicKey), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	canarytail "github.com/canarytail/client"
+)
+
+type tsaOpts struct {
+	TSA string `name:"tsa" env:"CANARY_TSA_URL" help:"URL of an RFC 3161 timestamp authority to countersign the canary once signed"`
+}
+
+type canaryTimestampCmd struct {
+	Path      string   `arg name:"canary_path"`
+	Upgrade   bool     `name:"upgrade" help:"Upgrade the pending OpenTimestamps proof already attached to the canary"`
+	Calendars []string `name:"calendar" help:"OpenTimestamps calendar servers to submit the canary to (default: the public calendars)"`
+}
+
+func (cmd *canaryTimestampCmd) Run(ctx *context) error {
+	canary, err := canarytail.ReadFile(cmd.Path)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Upgrade {
+		proof, err := canary.OpenTimestampsProof()
+		if err != nil {
+			return err
+		}
+		if proof == nil {
+			return fmt.Errorf("the canary has no OpenTimestamps proof, run 'canary timestamp %s' first", cmd.Path)
+		}
+		fmt.Printf("Upgrading the OpenTimestamps proof of %v...\n", cmd.Path)
+		changed, err := proof.Upgrade()
+		if err != nil {
+			return err
+		}
+		if !changed {
+			fmt.Println("The proof is still pending, try again later.")
+			return nil
+		}
+		canary.SetOpenTimestampsProof(proof)
+	} else {
+		calendars := cmd.Calendars
+		if len(calendars) == 0 {
+			calendars = canarytail.DefaultOpenTimestampsCalendars
+		}
+		fmt.Printf("Timestamping canary %v...\n", cmd.Path)
+		proof, err := canarytail.StampOpenTimestamps(canary.Digest(), calendars)
+		if err != nil {
+			return err
+		}
+		canary.SetOpenTimestampsProof(proof)
+		fmt.Println("The proof is pending until the calendars commit it to Bitcoin, which takes a few hours. Then run this command with --upgrade.")
+	}
+
+	return writeToFile(cmd.Path, canary.Format())
+}
+
+func printOpenTimestamps(canary canarytail.Canary, headers canarytail.HeaderLookup) {
+	if headers == nil {
+		fmt.Println("WARNING: the OpenTimestamps proof of the canary was not checked against Bitcoin, the block backend provides no headers. Use --spv to check it.")
+		return
+	}
+	attested, err := canary.VerifyOpenTimestamps(headers)
+	if err == canarytail.ErrTimestampPending {
+		fmt.Println("The OpenTimestamps proof is still pending.")
+		return
+	}
+	if err == nil {
+		fmt.Printf("The canary existed before %v, as attested by Bitcoin.\n", attested)
+	}
+}
+
+func printTimestampToken(canary canarytail.Canary, roots *x509.CertPool) {
+	timestamp, err := canary.VerifyTimestampToken(roots)
+	if err != nil {
+		return
+	}
+	if roots == nil {
+		fmt.Printf("The canary is timestamped %v by an unverified timestamp authority, use --tsa-roots to check it.\n", timestamp)
+		return
+	}
+	fmt.Printf("The canary is timestamped %v by a trusted timestamp authority.\n", timestamp)
+}
+
+// countersign drops the timestamps of the canary its new signature invalidated and, if a TSA is given,
+// asks it for a fresh timestamp token
+func countersign(canary *canarytail.Canary, tsaURL string) error {
+	if canary.OpenTimestamps != "" {
+		fmt.Println("The OpenTimestamps proof of the previous signatures was removed, run 'canary timestamp' again.")
+	}
+	canary.OpenTimestamps = ""
+	canary.TimestampToken = ""
+	if tsaURL == "" {
+		return nil
+	}
+
+	token, err := canarytail.RequestTimestamp(tsaURL, canary.Digest())
+	if err != nil {
+		return fmt.Errorf("Could not timestamp the canary with %v: %v", tsaURL, err)
+	}
+	canary.SetTimestampToken(token)
+	return nil
+}
+
+// readCertPool reads PEM encoded certificates from a file
+func readCertPool(path string) (*x509.CertPool, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("Could not find certificates in %v", path)
+	}
+	return pool, nil
+}
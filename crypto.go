@@ -2,6 +2,7 @@ package canarytail
 
 import (
 	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/base64"
 	"math/rand"
 	"time"
@@ -64,3 +65,10 @@ func ParsePrivateKey(privateKey string) (ed25519.PrivateKey, error) {
 func FormatKey(key []byte) string {
 	return base64.StdEncoding.EncodeToString(key)
 }
+
+// KeyFingerprint is a short form of a public key to compare keys by: SHA256: and the unpadded base64 of
+// its SHA-256 hash, like OpenSSH fingerprints
+func KeyFingerprint(publicKey ed25519.PublicKey) string {
+	hash := sha256.Sum256(publicKey)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(hash[:])
+}
@@ -16,10 +16,22 @@ func TestSignature(t *testing.T) {
 	c1 := canarytail.Canary{
 		Claim: canarytail.CanaryClaim{
 			Domain:     "test",
-			PublicKeys: []string{canarytail.FormatKey(publicKey)},
+			PublicKeys: []canarytail.PublicKey{{Key: canarytail.FormatKey(publicKey)}},
 		},
 	}
 	signature := canarytail.SignString(c1.Claim.Domain, privateKey)
 	validated := canarytail.ValidateSignatureString(c1.Claim.Domain, signature, publicKey)
 	assert.True(t, validated)
 }
+
+func TestKeyFingerprint(t *testing.T) {
+	publicKey, _, err := canarytail.GenerateKeyPair()
+	assert.Nil(t, err)
+	otherKey, _, err := canarytail.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	fingerprint := canarytail.KeyFingerprint(publicKey)
+	assert.Regexp(t, `^SHA256:[A-Za-z0-9+/]{43}$`, fingerprint)
+	assert.Equal(t, fingerprint, canarytail.KeyFingerprint(publicKey))
+	assert.NotEqual(t, fingerprint, canarytail.KeyFingerprint(otherKey))
+}
@@ -0,0 +1,229 @@
+package canarytail
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The dead man's switch daemon trips the canary of a domain when its author misses a check-in. Its API
+// is HTTP:
+//
+//	POST /checkin   records the CheckIn in the body, signed by the author
+//	GET  /status    returns the DeadmanSwitch
+
+// CheckIn is a proof that the author of a canary is still free to renew it, signed by its author key
+type CheckIn struct {
+	Domain    string `json:"domain"`
+	Time      string `json:"time"`
+	Key       string `json:"key"`
+	Signature string `json:"signature"`
+}
+
+// CheckInSkew is how far the time of a check-in may be from the time it is received
+const CheckInSkew = 5 * time.Minute
+
+// NewCheckIn signs a check-in for a domain at the given time
+func NewCheckIn(domain string, signer crypto.Signer, t time.Time) (*CheckIn, error) {
+	publicKey, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("check-ins are signed with Ed25519 keys, not %T", signer.Public())
+	}
+	c := &CheckIn{Domain: domain, Time: t.UTC().Format(TimestampLayout), Key: FormatKey(publicKey)}
+	signature, err := signer.Sign(rand.Reader, c.message(), crypto.Hash(0))
+	if err != nil {
+		return nil, fmt.Errorf("Could not sign the check-in: %v", err)
+	}
+	c.Signature = base64.StdEncoding.EncodeToString(signature)
+	return c, nil
+}
+
+// message is what the author signs
+func (c CheckIn) message() []byte {
+	return []byte(strings.Join([]string{"canarytail check-in", c.Domain, c.Time}, "\n"))
+}
+
+// Timestamp parses the time of the check-in
+func (c CheckIn) Timestamp() time.Time {
+	t, _ := time.Parse(TimestampLayout, c.Time)
+	return t
+}
+
+// Verify checks the check-in is signed by the author key
+func (c CheckIn) Verify(authorKey string) error {
+	if c.Key != authorKey {
+		return errors.New("the check-in is not signed by the author key")
+	}
+	if _, err := time.Parse(TimestampLayout, c.Time); err != nil {
+		return fmt.Errorf("invalid time %q in the check-in", c.Time)
+	}
+	publicKey, err := ParsePublicKey(c.Key)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid key in the check-in")
+	}
+	signature, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil || !ed25519.Verify(publicKey, c.message(), signature) {
+		return errors.New("invalid signature of the check-in")
+	}
+	return nil
+}
+
+// DeadmanEvent is what the dead man's switch has to do
+type DeadmanEvent string
+
+// The events of the dead man's switch: warn the author once the grace period before the deadline
+// starts, and trip the canary once the deadline passes
+const (
+	DeadmanNone DeadmanEvent = ""
+	DeadmanWarn DeadmanEvent = "warn"
+	DeadmanTrip DeadmanEvent = "trip"
+)
+
+// DeadmanSwitch is the state of the dead man's switch of a domain. The author must check in within
+// WindowMinutes of the last check-in, or the canary is tripped.
+type DeadmanSwitch struct {
+	Domain    string `json:"domain"`
+	AuthorKey string `json:"author_key"`
+	// WindowMinutes is how long after a check-in the canary is tripped
+	WindowMinutes int `json:"window_minutes"`
+	// GraceMinutes is how long before tripping the canary the author is warned
+	GraceMinutes int    `json:"grace_minutes"`
+	LastCheckIn  string `json:"last_checkin"`
+	Warned       bool   `json:"warned,omitempty"`
+	Tripped      bool   `json:"tripped,omitempty"`
+
+	mu sync.Mutex
+}
+
+// Deadline is the time the canary is tripped at without a check-in
+func (d *DeadmanSwitch) Deadline() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.deadline()
+}
+
+// deadline is Deadline, with the lock held
+func (d *DeadmanSwitch) deadline() time.Time {
+	last, _ := time.Parse(TimestampLayout, d.LastCheckIn)
+	return last.Add(time.Duration(d.WindowMinutes) * time.Minute)
+}
+
+// CheckIn records a check-in of the author received at now. Check-ins older than the last one, or too
+// far from now, are refused so that they cannot be replayed, and so are check-ins after the deadline.
+func (d *DeadmanSwitch) CheckIn(c CheckIn, now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c.Domain != d.Domain {
+		return fmt.Errorf("the check-in is for %v, not %v", c.Domain, d.Domain)
+	}
+	if err := c.Verify(d.AuthorKey); err != nil {
+		return err
+	}
+	t := c.Timestamp()
+	if t.Sub(now) > CheckInSkew || now.Sub(t) > CheckInSkew {
+		return fmt.Errorf("the check-in is from %v, more than %v away from now", c.Time, CheckInSkew)
+	}
+	if last, _ := time.Parse(TimestampLayout, d.LastCheckIn); !t.After(last) {
+		return errors.New("the check-in is not newer than the last one")
+	}
+	if d.Tripped {
+		return errors.New("the canary is already tripped")
+	}
+	// past the deadline the switch trips on its next tick, a late check-in must not rearm it
+	if deadline := d.deadline(); !now.Before(deadline) {
+		return fmt.Errorf("the deadline passed at %v", deadline.Format(TimestampLayout))
+	}
+	d.LastCheckIn, d.Warned = c.Time, false
+	return nil
+}
+
+// Due tells what the switch has to do at now, and records it as done
+func (d *DeadmanSwitch) Due(now time.Time) DeadmanEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deadline := d.deadline()
+	switch {
+	case d.Tripped:
+		return DeadmanNone
+	case !now.Before(deadline):
+		d.Tripped = true
+		return DeadmanTrip
+	case !d.Warned && !now.Before(deadline.Add(-time.Duration(d.GraceMinutes)*time.Minute)):
+		d.Warned = true
+		return DeadmanWarn
+	}
+	return DeadmanNone
+}
+
+// Handler answers the check-ins of the author, and the status of the switch. Received is called with
+// every accepted check-in, e.g. to store the state of the switch.
+func (d *DeadmanSwitch) Handler(received func()) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/checkin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "expected POST", http.StatusMethodNotAllowed)
+			return
+		}
+		var c CheckIn
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, fmt.Sprintf("invalid check-in: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := d.CheckIn(c, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if received != nil {
+			received()
+		}
+		d.Encode(w)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		d.Encode(w)
+	})
+	return mux
+}
+
+// Encode writes the state of the switch in JSON
+func (d *DeadmanSwitch) Encode(w io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	content, err := json.MarshalIndent(d, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// SendCheckIn posts a check-in to the dead man's switch daemon at url, and returns its status
+func SendCheckIn(url string, c *CheckIn) (*DeadmanSwitch, error) {
+	content, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Post(strings.TrimRight(url, "/")+"/checkin", "application/json", strings.NewReader(string(content)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		reason, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("the check-in was refused: %s", strings.TrimSpace(string(reason)))
+	}
+	status := &DeadmanSwitch{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, fmt.Errorf("invalid status of the dead man's switch: %v", err)
+	}
+	return status, nil
+}
@@ -0,0 +1,115 @@
+package canarytail_test
+
+import (
+	"crypto/ed25519"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadmanCheckIn(t *testing.T) {
+	keys := rotationKeys(t, 2)
+	author, other := keys[0], keys[1]
+	now := time.Now()
+	d := &canarytail.DeadmanSwitch{
+		Domain:        "example.com",
+		AuthorKey:     canarytail.FormatKey(author.Public().(ed25519.PublicKey)),
+		WindowMinutes: 60,
+		LastCheckIn:   now.Add(-30 * time.Minute).Format(canarytail.TimestampLayout),
+	}
+
+	checkIn, err := canarytail.NewCheckIn("example.com", author, now)
+	require.NoError(t, err)
+	require.NoError(t, d.CheckIn(*checkIn, now))
+	assert.Equal(t, checkIn.Time, d.LastCheckIn)
+
+	// replayed, stale, forged and misdirected check-ins are refused
+	assert.Error(t, d.CheckIn(*checkIn, now.Add(time.Minute)))
+	stale, err := canarytail.NewCheckIn("example.com", author, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Error(t, d.CheckIn(*stale, now))
+	forged, err := canarytail.NewCheckIn("example.com", other, now.Add(time.Second))
+	require.NoError(t, err)
+	assert.Error(t, d.CheckIn(*forged, now.Add(time.Second)))
+	forged.Key = d.AuthorKey
+	assert.Error(t, d.CheckIn(*forged, now.Add(time.Second)))
+	misdirected, err := canarytail.NewCheckIn("example.org", author, now.Add(time.Second))
+	require.NoError(t, err)
+	assert.Error(t, d.CheckIn(*misdirected, now.Add(time.Second)))
+}
+
+func TestDeadmanDue(t *testing.T) {
+	keys := rotationKeys(t, 1)
+	now := time.Now().Truncate(time.Second)
+	d := &canarytail.DeadmanSwitch{
+		Domain:        "example.com",
+		AuthorKey:     canarytail.FormatKey(keys[0].Public().(ed25519.PublicKey)),
+		WindowMinutes: 60,
+		GraceMinutes:  10,
+		LastCheckIn:   now.Format(canarytail.TimestampLayout),
+	}
+	assert.Equal(t, canarytail.DeadmanNone, d.Due(now.Add(49*time.Minute)))
+	assert.Equal(t, canarytail.DeadmanWarn, d.Due(now.Add(50*time.Minute)))
+	assert.Equal(t, canarytail.DeadmanNone, d.Due(now.Add(55*time.Minute)))
+
+	// a check-in during the grace period defers the deadline, and warns again next time
+	checkIn, err := canarytail.NewCheckIn("example.com", keys[0], now.Add(55*time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, d.CheckIn(*checkIn, now.Add(55*time.Minute)))
+	assert.Equal(t, canarytail.DeadmanNone, d.Due(now.Add(61*time.Minute)))
+	assert.Equal(t, canarytail.DeadmanWarn, d.Due(now.Add(105*time.Minute)))
+	assert.Equal(t, canarytail.DeadmanTrip, d.Due(now.Add(115*time.Minute)))
+	assert.Equal(t, canarytail.DeadmanNone, d.Due(now.Add(116*time.Minute)))
+
+	// once tripped, check-ins do not rearm it
+	late, err := canarytail.NewCheckIn("example.com", keys[0], now.Add(116*time.Minute))
+	require.NoError(t, err)
+	assert.Error(t, d.CheckIn(*late, now.Add(116*time.Minute)))
+}
+
+func TestDeadmanLateCheckIn(t *testing.T) {
+	keys := rotationKeys(t, 1)
+	now := time.Now().Truncate(time.Second)
+	d := &canarytail.DeadmanSwitch{
+		Domain:        "example.com",
+		AuthorKey:     canarytail.FormatKey(keys[0].Public().(ed25519.PublicKey)),
+		WindowMinutes: 60,
+		LastCheckIn:   now.Format(canarytail.TimestampLayout),
+	}
+
+	// a check-in after the deadline, before the switch ticks, does not rearm it
+	late, err := canarytail.NewCheckIn("example.com", keys[0], now.Add(61*time.Minute))
+	require.NoError(t, err)
+	assert.Error(t, d.CheckIn(*late, now.Add(61*time.Minute)))
+	assert.Equal(t, now.Format(canarytail.TimestampLayout), d.LastCheckIn)
+	assert.Equal(t, canarytail.DeadmanTrip, d.Due(now.Add(62*time.Minute)))
+}
+
+func TestDeadmanHandler(t *testing.T) {
+	keys := rotationKeys(t, 1)
+	d := &canarytail.DeadmanSwitch{
+		Domain:        "example.com",
+		AuthorKey:     canarytail.FormatKey(keys[0].Public().(ed25519.PublicKey)),
+		WindowMinutes: 60,
+		LastCheckIn:   time.Now().Add(-time.Minute).Format(canarytail.TimestampLayout),
+	}
+	received := 0
+	server := httptest.NewServer(d.Handler(func() { received++ }))
+	defer server.Close()
+
+	checkIn, err := canarytail.NewCheckIn("example.com", keys[0], time.Now())
+	require.NoError(t, err)
+	status, err := canarytail.SendCheckIn(server.URL, checkIn)
+	require.NoError(t, err)
+	assert.Equal(t, checkIn.Time, status.LastCheckIn)
+	assert.Equal(t, 1, received)
+
+	_, err = canarytail.SendCheckIn(server.URL, checkIn)
+	assert.Error(t, err)
+	assert.Equal(t, 1, received)
+}
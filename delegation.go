@@ -0,0 +1,188 @@
+package canarytail
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DelegableFields are the claim fields a delegated key may be allowed to change, by their JSON names.
+// The signers, panic key and codes of a canary are only ever changed by its author.
+var DelegableFields = []string{"release", "expiry", "freshness", "headlines", "mirrors"}
+
+// Delegation certifies a key to sign canaries in place of the author, such as a key used by a cron job to
+// renew the canary. The delegated key may only change the listed claim fields of the previous canary,
+// and only until the delegation expires.
+type Delegation struct {
+	Domain string `json:"domain"`
+	Name   string `json:"name"`
+	// Key is the delegated public key
+	Key string `json:"key"`
+	// Fields are the claim fields the delegated key may change
+	Fields []string `json:"fields"`
+	Expiry string   `json:"expiry"`
+	// AuthorKey is the key of the author, which signs the delegation
+	AuthorKey string `json:"author_key"`
+	Signature string `json:"signature"`
+}
+
+// NewDelegation instantiates an unsigned delegation of the fields of the canaries of a domain to a key
+func NewDelegation(domain, name string, key ed25519.PublicKey, fields []string, expiry time.Time) (*Delegation, error) {
+	fields = append([]string(nil), fields...)
+	sort.Strings(fields)
+	for _, f := range fields {
+		if !containsString(DelegableFields, f) {
+			return nil, fmt.Errorf("the field %q cannot be delegated, expected some of %v", f, strings.Join(DelegableFields, ", "))
+		}
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("no field delegated")
+	}
+	return &Delegation{
+		Domain: domain,
+		Name:   name,
+		Key:    FormatKey(key),
+		Fields: fields,
+		Expiry: expiry.UTC().Format(TimestampLayout),
+	}, nil
+}
+
+// message is what the author signs
+func (d Delegation) message() []byte {
+	return []byte(strings.Join([]string{"canarytail delegation", d.Domain, d.Name, d.Key, strings.Join(d.Fields, " "), d.Expiry}, "\n"))
+}
+
+// Sign signs the delegation with the key of the author
+func (d *Delegation) Sign(author crypto.Signer) error {
+	publicKey, ok := author.Public().(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("delegations are signed with Ed25519 keys, not %T", author.Public())
+	}
+	signature, err := author.Sign(rand.Reader, d.message(), crypto.Hash(0))
+	if err != nil {
+		return fmt.Errorf("Could not sign the delegation: %v", err)
+	}
+	d.AuthorKey = FormatKey(publicKey)
+	d.Signature = base64.StdEncoding.EncodeToString(signature)
+	return nil
+}
+
+// Delegates tells whether the delegation allows the delegated key to change a claim field
+func (d Delegation) Delegates(field string) bool {
+	return containsString(d.Fields, field)
+}
+
+// ExpiryTimestamp parses the time the delegation expires
+func (d Delegation) ExpiryTimestamp() time.Time {
+	t, _ := time.Parse(TimestampLayout, d.Expiry)
+	return t
+}
+
+// Verify checks the delegation is signed by its author key, and only delegates delegable fields
+func (d Delegation) Verify() error {
+	if _, err := time.Parse(TimestampLayout, d.Expiry); err != nil {
+		return fmt.Errorf("invalid expiry %q in the delegation", d.Expiry)
+	}
+	for _, f := range d.Fields {
+		if !containsString(DelegableFields, f) {
+			return fmt.Errorf("the delegation delegates the field %q, which cannot be delegated", f)
+		}
+	}
+	authorKey, err := ParsePublicKey(d.AuthorKey)
+	if err != nil || len(authorKey) != ed25519.PublicKeySize {
+		return errors.New("invalid author key in the delegation")
+	}
+	signature, err := base64.StdEncoding.DecodeString(d.Signature)
+	if err != nil || !ed25519.Verify(authorKey, d.message(), signature) {
+		return fmt.Errorf("the delegation to %q is not signed by its author", d.Name)
+	}
+	return nil
+}
+
+// delegatedSignature tells whether the canary is signed by a key the author key delegated to, through
+// the delegation of the canary, and returns the revocation of the delegated key if it is revoked
+func (v *CanaryValidator) delegatedSignature(authorKey string) (bool, *Revocation, error) {
+	c := v.Canary
+	d := c.Delegation
+	if d == nil || d.AuthorKey != authorKey {
+		return false, nil, nil
+	}
+	if _, signed := c.Signatures[d.Key]; !signed {
+		return false, nil, nil
+	}
+	if err := d.Verify(); err != nil {
+		return false, nil, err
+	}
+	if d.Domain != c.Claim.Domain {
+		return false, nil, fmt.Errorf("the delegation is for %v, not %v", d.Domain, c.Claim.Domain)
+	}
+	// a delegated canary cannot outlive its delegation
+	if c.ExiprationTimestamp().After(d.ExpiryTimestamp()) {
+		return false, nil, fmt.Errorf("the canary expires after the delegation to %q, on %v", d.Name, d.Expiry)
+	}
+	key, err := ParsePublicKey(d.Key)
+	if err != nil || !c.ValidateSignatures(key) {
+		return false, nil, fmt.Errorf("Signature verification failed for the delegated key %s", d.Key)
+	}
+	revocation, err := v.revocation(d.Key)
+	if err != nil {
+		return false, nil, err
+	}
+	return true, revocation, nil
+}
+
+// CheckDelegatedChanges checks the canary only changes the fields of its delegation compared with the
+// previous canary
+func (c Canary) CheckDelegatedChanges(previous Canary) error {
+	if c.Delegation == nil {
+		return errors.New("the canary is not signed by a delegated key")
+	}
+	if previous.Claim.Domain != c.Claim.Domain {
+		return fmt.Errorf("the previous canary is for %v, not %v", previous.Claim.Domain, c.Claim.Domain)
+	}
+	// a tripped canary is not renewed into a clean one
+	if previous.ValidateSignatures(previous.PanicKey()) {
+		return errors.New("the previous canary is signed with the panic key")
+	}
+	if !previous.ReleaseTimestamp().Before(c.ReleaseTimestamp()) {
+		return errors.New("the previous canary is not released before the canary")
+	}
+	if previous.Version != c.Version {
+		return fmt.Errorf("the delegated key %q changed the version", c.Delegation.Name)
+	}
+	// rotations and revocations are the author's to publish
+	if !reflect.DeepEqual(previous.Rotations, c.Rotations) || !reflect.DeepEqual(previous.Revocations, c.Revocations) {
+		return fmt.Errorf("the delegated key %q changed the rotations or revocations of the canary", c.Delegation.Name)
+	}
+	before, err := StructToMap(previous.Claim)
+	if err != nil {
+		return err
+	}
+	after, err := StructToMap(c.Claim)
+	if err != nil {
+		return err
+	}
+	for field := range mergeKeys(before, after) {
+		if !c.Delegation.Delegates(field) && !reflect.DeepEqual(before[field], after[field]) {
+			return fmt.Errorf("the delegated key %q changed the %v of the canary, outside of its delegation", c.Delegation.Name, field)
+		}
+	}
+	return nil
+}
+
+func mergeKeys(maps ...map[string]interface{}) map[string]bool {
+	keys := make(map[string]bool)
+	for _, m := range maps {
+		for k := range m {
+			keys[k] = true
+		}
+	}
+	return keys
+}
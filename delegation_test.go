@@ -0,0 +1,108 @@
+package canarytail_test
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelegatedCanary(t *testing.T) {
+	keys := rotationKeys(t, 3)
+	author, delegate, other := keys[0], keys[1], keys[2]
+	now := time.Now()
+
+	previous := testCanary(t, keys[:1], releasedAt(now.Add(-24*time.Hour)))
+
+	delegation, err := canarytail.NewDelegation("example.com", "cron", delegate.Public().(ed25519.PublicKey), []string{"release", "expiry", "freshness"}, now.Add(30*24*time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, delegation.Sign(author))
+	require.NoError(t, delegation.Verify())
+	// renew renews the previous canary with the delegated key, as a cron job would
+	renew := func(release time.Time, amend ...func(*canarytail.Canary)) canarytail.Canary {
+		c := previous
+		releasedAt(release)(&c)
+		c.Claim.Freshness = "renewed"
+		c.Signatures = nil
+		c.Delegation = delegation
+		for _, f := range amend {
+			f(&c)
+		}
+		require.NoError(t, c.Sign(delegate))
+		return c
+	}
+
+	canary := renew(now)
+	validator := canarytail.NewCanaryValidator(canary)
+	report, err := validator.Report()
+	require.NoError(t, err)
+	assert.Equal(t, canarytail.SignatureDelegated, report[0].Status)
+
+	// the previous canary is needed to check the scope of the delegation
+	ok, err := validator.Validate()
+	assert.False(t, ok)
+	assert.Error(t, err)
+	validator.Previous = &previous
+	ok, err = validator.Validate()
+	assert.True(t, ok, "%v", err)
+
+	// a tripped code cannot be restored, nor the signers changed
+	tripped := previous
+	tripped.Claim.Codes = canarytail.InverseCodes([]string{"war"})
+	require.NoError(t, tripped.Sign(author))
+	validator.Previous = &tripped
+	ok, err = validator.Validate()
+	assert.False(t, ok)
+	assert.Contains(t, err.Error(), "codes")
+
+	changed := renew(now, func(c *canarytail.Canary) {
+		c.Claim.PublicKeys = append(c.Claim.PublicKeys, canarytail.PublicKey{Role: canarytail.RoleCosigner, Key: canarytail.FormatKey(other.Public().(ed25519.PublicKey))})
+	})
+	assert.Error(t, changed.CheckDelegatedChanges(previous))
+
+	// the canary cannot outlive the delegation
+	late := renew(now.Add(30 * 24 * time.Hour))
+	_, err = canarytail.NewCanaryValidator(late).Report()
+	assert.Error(t, err)
+
+	// nor does a revoked delegated key sign in place of the author
+	revocation := canarytail.NewRevocation("example.com", []string{delegation.Key}, now.Add(-time.Hour), "cron server seized")
+	require.NoError(t, revocation.Sign(author))
+	validator = canarytail.NewCanaryValidator(canary)
+	validator.Previous = &previous
+	validator.Revocations = []canarytail.Revocation{*revocation}
+	report, err = validator.Report()
+	require.NoError(t, err)
+	assert.Equal(t, canarytail.SignatureRevoked, report[0].Status)
+	assert.Equal(t, revocation, report[0].Revocation)
+	ok, err = validator.Validate()
+	assert.False(t, ok)
+	assert.Contains(t, err.Error(), "revoked")
+}
+
+func TestDelegationScope(t *testing.T) {
+	keys := rotationKeys(t, 3)
+	author, delegate, other := keys[0], keys[1], keys[2]
+
+	_, err := canarytail.NewDelegation("example.com", "cron", delegate.Public().(ed25519.PublicKey), []string{"release", "codes"}, time.Now())
+	assert.Error(t, err)
+
+	delegation, err := canarytail.NewDelegation("example.com", "cron", delegate.Public().(ed25519.PublicKey), []string{"release"}, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, delegation.Sign(author))
+
+	// widening the scope breaks the signature of the author
+	widened := *delegation
+	widened.Fields = []string{"expiry", "release"}
+	assert.Error(t, widened.Verify())
+
+	// a delegation signed by another key is not a delegation of the author
+	forged := *delegation
+	require.NoError(t, forged.Sign(other))
+	forged.AuthorKey = delegation.AuthorKey
+	assert.Error(t, forged.Verify())
+}
@@ -0,0 +1,283 @@
+package canarytail
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/circl/ecc/bls12381"
+)
+
+// drand randomness beacons, see https://drand.love/docs/specification/
+//
+// A drand network publishes a BLS signature every period. The signature of a round is deterministic and
+// unpredictable before the round time, so a canary quoting it cannot have been made earlier.
+
+// drandFreshnessPrefix prefixes the Freshness claim of canaries using a drand round as freshness
+const drandFreshnessPrefix = "drand:"
+
+// Known drand signature schemes
+const (
+	DrandSchemeChained   = "pedersen-bls-chained"
+	DrandSchemeUnchained = "pedersen-bls-unchained"
+	DrandSchemeG1        = "bls-unchained-g1-rfc9380"
+)
+
+const (
+	drandDSTG1 = "BLS_SIG_BLS12381G1_XMD:SHA-256_SSWU_RO_NUL_"
+	drandDSTG2 = "BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_NUL_"
+)
+
+// DrandChainInfo is the public information of a drand chain, as served at /info
+type DrandChainInfo struct {
+	PublicKey   HexBytes `json:"public_key"`
+	Period      int64    `json:"period"`
+	GenesisTime int64    `json:"genesis_time"`
+	Hash        string   `json:"hash"`
+	GroupHash   string   `json:"groupHash"`
+	SchemeID    string   `json:"schemeID,omitempty"`
+	// URL is the HTTP endpoint the beacon is fetched from. It is not part of the chain info drand
+	// serves, and only used to look up the rounds of canaries without a freshness proof.
+	URL string `json:"url,omitempty"`
+}
+
+// DrandBeacon is a round of a drand chain, as served at /public/<round>
+type DrandBeacon struct {
+	Round             uint64   `json:"round"`
+	Randomness        HexBytes `json:"randomness"`
+	Signature         HexBytes `json:"signature"`
+	PreviousSignature HexBytes `json:"previous_signature,omitempty"`
+}
+
+// HexBytes is a byte slice marshalled to JSON as a hex string
+type HexBytes []byte
+
+// MarshalJSON encodes the bytes as a hex string
+func (h HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+// UnmarshalJSON decodes the bytes from a hex string
+func (h *HexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*h = b
+	return nil
+}
+
+// FetchDrandChainInfo fetches the chain info of a drand beacon. When chainHash is not empty, the chain
+// info must be the one of that chain.
+func FetchDrandChainInfo(url, chainHash string) (*DrandChainInfo, error) {
+	info := &DrandChainInfo{}
+	if err := drandGet(url+"/info", info); err != nil {
+		return nil, err
+	}
+	if chainHash != "" && !strings.EqualFold(info.Hash, chainHash) {
+		return nil, fmt.Errorf("%s serves the drand chain %s, not %s", url, info.Hash, chainHash)
+	}
+	if _, _, err := info.scheme(); err != nil {
+		return nil, err
+	}
+	info.URL = url
+	return info, nil
+}
+
+// FetchDrandBeacon fetches a round of the drand beacon at url, or the latest one when round is 0
+func FetchDrandBeacon(url string, round uint64) (*DrandBeacon, error) {
+	path := "/public/latest"
+	if round > 0 {
+		path = fmt.Sprintf("/public/%d", round)
+	}
+	beacon := &DrandBeacon{}
+	if err := drandGet(url+path, beacon); err != nil {
+		return nil, err
+	}
+	if round > 0 && beacon.Round != round {
+		return nil, fmt.Errorf("asked for drand round %d, got %d", round, beacon.Round)
+	}
+	return beacon, nil
+}
+
+func drandGet(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Could not retrieve %v, got code %v", url, resp.StatusCode)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+// LoadDrandChainInfo reads chain info saved with Save
+func LoadDrandChainInfo(path string) (*DrandChainInfo, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info := &DrandChainInfo{}
+	if err := json.Unmarshal(content, info); err != nil {
+		return nil, fmt.Errorf("invalid drand chain info in %v: %v", path, err)
+	}
+	return info, nil
+}
+
+// Save writes the chain info to a file
+func (info *DrandChainInfo) Save(path string) error {
+	content, err := json.MarshalIndent(info, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// RoundTime returns the time a round is published at
+func (info *DrandChainInfo) RoundTime(round uint64) time.Time {
+	if round == 0 {
+		return time.Unix(info.GenesisTime, 0)
+	}
+	return time.Unix(info.GenesisTime+int64(round-1)*info.Period, 0)
+}
+
+// scheme tells whether the public key is on G1 (signatures on G2) and if rounds are chained
+func (info *DrandChainInfo) scheme() (keyOnG1, chained bool, err error) {
+	switch info.SchemeID {
+	case "", DrandSchemeChained:
+		return true, true, nil
+	case DrandSchemeUnchained:
+		return true, false, nil
+	case DrandSchemeG1:
+		return false, false, nil
+	}
+	return false, false, fmt.Errorf("unsupported drand scheme %q", info.SchemeID)
+}
+
+// Verify checks the BLS signature of the beacon against the public key of the chain, and that its
+// randomness derives from the signature
+func (info *DrandChainInfo) Verify(beacon *DrandBeacon) error {
+	keyOnG1, chained, err := info.scheme()
+	if err != nil {
+		return err
+	}
+	randomness := sha256.Sum256(beacon.Signature)
+	if !bytes.Equal(randomness[:], beacon.Randomness) {
+		return errors.New("the randomness of the drand beacon does not derive from its signature")
+	}
+
+	h := sha256.New()
+	if chained {
+		h.Write(beacon.PreviousSignature)
+	}
+	round := make([]byte, 8)
+	binary.BigEndian.PutUint64(round, beacon.Round)
+	h.Write(round)
+	msg := h.Sum(nil)
+
+	// e(g1, sig) = e(pk, H(msg)) with the key on G1, e(sig, g2) = e(H(msg), pk) with the key on G2
+	var ok bool
+	if keyOnG1 {
+		pk, sig, hashed := new(bls12381.G1), new(bls12381.G2), new(bls12381.G2)
+		if err := pk.SetBytes(info.PublicKey); err != nil || !pk.IsOnG1() {
+			return errors.New("invalid drand public key")
+		}
+		if err := sig.SetBytes(beacon.Signature); err != nil || !sig.IsOnG2() {
+			return errors.New("invalid drand signature")
+		}
+		hashed.Hash(msg, []byte(drandDSTG2))
+		ok = bls12381.ProdPairFrac([]*bls12381.G1{bls12381.G1Generator(), pk}, []*bls12381.G2{sig, hashed}, []int{1, -1}).IsIdentity()
+	} else {
+		pk, sig, hashed := new(bls12381.G2), new(bls12381.G1), new(bls12381.G1)
+		if err := pk.SetBytes(info.PublicKey); err != nil || !pk.IsOnG2() {
+			return errors.New("invalid drand public key")
+		}
+		if err := sig.SetBytes(beacon.Signature); err != nil || !sig.IsOnG1() {
+			return errors.New("invalid drand signature")
+		}
+		hashed.Hash(msg, []byte(drandDSTG1))
+		ok = bls12381.ProdPairFrac([]*bls12381.G1{sig, hashed}, []*bls12381.G2{bls12381.G2Generator(), pk}, []int{1, -1}).IsIdentity()
+	}
+	if !ok {
+		return fmt.Errorf("invalid signature of drand round %d", beacon.Round)
+	}
+	return nil
+}
+
+// IsDrandFreshness tells whether a Freshness claim is a drand round rather than a Bitcoin block hash
+func IsDrandFreshness(freshness string) bool {
+	return strings.HasPrefix(freshness, drandFreshnessPrefix)
+}
+
+// FormatDrandFreshness formats a drand round as a Freshness claim, in the form drand:ROUND:RANDOMNESS
+func FormatDrandFreshness(beacon *DrandBeacon) string {
+	return fmt.Sprintf("%s%d:%x", drandFreshnessPrefix, beacon.Round, []byte(beacon.Randomness))
+}
+
+// ParseDrandFreshness parses a Freshness claim formatted with FormatDrandFreshness
+func ParseDrandFreshness(freshness string) (uint64, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(freshness, drandFreshnessPrefix), ":")
+	if !IsDrandFreshness(freshness) || len(parts) != 2 {
+		return 0, nil, fmt.Errorf("invalid drand freshness %q, expected drand:ROUND:RANDOMNESS", freshness)
+	}
+	round, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, nil, err
+	}
+	randomness, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return 0, nil, err
+	}
+	return round, randomness, nil
+}
+
+// drandFreshnessTime checks the drand round of the canary against the chain info, and returns the time
+// of the round. The beacon embedded in the freshness proof is used first, then the beacon is fetched
+// unless offline.
+func (c Canary) drandFreshnessTime(opts ValidateOptions) (time.Time, error) {
+	info := opts.Drand
+	if info == nil {
+		return time.Time{}, errors.New("the canary uses drand for freshness, but no drand chain info was given")
+	}
+	round, randomness, err := ParseDrandFreshness(c.Claim.Freshness)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var beacon *DrandBeacon
+	switch {
+	case c.FreshnessProof != nil && c.FreshnessProof.Drand != nil:
+		beacon = c.FreshnessProof.Drand
+	case opts.Offline || info.URL == "":
+		return time.Time{}, ErrNoFreshnessProof
+	default:
+		if beacon, err = FetchDrandBeacon(info.URL, round); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if beacon.Round != round || !bytes.Equal(beacon.Randomness, randomness) {
+		return time.Time{}, fmt.Errorf("the drand beacon is not the one of round %d", round)
+	}
+	if err := info.Verify(beacon); err != nil {
+		return time.Time{}, err
+	}
+	return info.RoundTime(round), nil
+}
@@ -0,0 +1,162 @@
+package canarytail_test
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+	"github.com/cloudflare/circl/ecc/bls12381"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drandServer is a stub drand beacon with 15 minute rounds, the latest published a minute ago
+type drandServer struct {
+	*httptest.Server
+	scheme string
+	key    bls12381.Scalar
+	info   canarytail.DrandChainInfo
+	latest uint64
+}
+
+func newDrandServer(t *testing.T, scheme string) *drandServer {
+	d := &drandServer{scheme: scheme, latest: 10}
+	require.NoError(t, d.key.Random(rand.Reader))
+	d.info = canarytail.DrandChainInfo{
+		Period:      900,
+		GenesisTime: time.Now().Add(-time.Minute).Unix() - 900*int64(d.latest-1),
+		Hash:        "8990e7a9aaed2ffed73dbd7092123d6f289930540d7651336225dc172e51b2ce",
+		SchemeID:    scheme,
+	}
+	if scheme == canarytail.DrandSchemeG1 {
+		pk := new(bls12381.G2)
+		pk.ScalarMult(&d.key, bls12381.G2Generator())
+		d.info.PublicKey = pk.BytesCompressed()
+	} else {
+		pk := new(bls12381.G1)
+		pk.ScalarMult(&d.key, bls12381.G1Generator())
+		d.info.PublicKey = pk.BytesCompressed()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(d.info)
+	})
+	mux.HandleFunc("/public/", func(w http.ResponseWriter, r *http.Request) {
+		round := d.latest
+		if s := strings.TrimPrefix(r.URL.Path, "/public/"); s != "latest" {
+			round, _ = strconv.ParseUint(s, 10, 64)
+		}
+		if round == 0 || round > d.latest {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(d.beacon(round))
+	})
+	d.Server = httptest.NewServer(mux)
+	t.Cleanup(d.Close)
+	return d
+}
+
+// sign computes the signature of a round, chaining it to the previous one for the chained scheme
+func (d *drandServer) sign(round uint64) (sig, previous []byte) {
+	if round == 0 {
+		return []byte("genesis seed"), nil
+	}
+	h := sha256.New()
+	if d.scheme == canarytail.DrandSchemeChained {
+		previous, _ = d.sign(round - 1)
+		h.Write(previous)
+	}
+	binary.Write(h, binary.BigEndian, round)
+	msg := h.Sum(nil)
+
+	if d.scheme == canarytail.DrandSchemeG1 {
+		p := new(bls12381.G1)
+		p.Hash(msg, []byte("BLS_SIG_BLS12381G1_XMD:SHA-256_SSWU_RO_NUL_"))
+		p.ScalarMult(&d.key, p)
+		return p.BytesCompressed(), previous
+	}
+	p := new(bls12381.G2)
+	p.Hash(msg, []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_NUL_"))
+	p.ScalarMult(&d.key, p)
+	return p.BytesCompressed(), previous
+}
+
+func (d *drandServer) beacon(round uint64) *canarytail.DrandBeacon {
+	sig, previous := d.sign(round)
+	randomness := sha256.Sum256(sig)
+	return &canarytail.DrandBeacon{Round: round, Randomness: randomness[:], Signature: sig, PreviousSignature: previous}
+}
+
+func TestDrandFreshness(t *testing.T) {
+	for _, scheme := range []string{canarytail.DrandSchemeChained, canarytail.DrandSchemeG1} {
+		t.Run(scheme, func(t *testing.T) {
+			d := newDrandServer(t, scheme)
+			info, err := canarytail.FetchDrandChainInfo(d.URL, d.info.Hash)
+			require.NoError(t, err)
+			beacon, err := canarytail.FetchDrandBeacon(info.URL, 0)
+			require.NoError(t, err)
+			require.NoError(t, info.Verify(beacon))
+			assert.Equal(t, time.Unix(d.info.GenesisTime+9*900, 0), info.RoundTime(beacon.Round))
+
+			freshness := canarytail.FormatDrandFreshness(beacon)
+			round, _, err := canarytail.ParseDrandFreshness(freshness)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(10), round)
+
+			t.Run("offline with the embedded beacon", func(t *testing.T) {
+				c := testCanary(t, nil, withFreshness(freshness))
+				c.FreshnessProof = &canarytail.FreshnessProof{Drand: beacon}
+				ok, err := c.ValidateWithOptions(canarytail.ValidateOptions{Offline: true, Drand: info})
+				assert.NoError(t, err)
+				assert.True(t, ok)
+			})
+
+			t.Run("online from the beacon", func(t *testing.T) {
+				c := testCanary(t, nil, withFreshness(freshness))
+				ok, err := c.ValidateWithOptions(canarytail.ValidateOptions{Drand: info})
+				assert.NoError(t, err)
+				assert.True(t, ok)
+			})
+
+			t.Run("forged round", func(t *testing.T) {
+				forged := *beacon
+				forged.Signature = d.beacon(9).Signature
+				randomness := sha256.Sum256(forged.Signature)
+				forged.Randomness = randomness[:]
+				assert.Error(t, info.Verify(&forged))
+			})
+
+			t.Run("another chain", func(t *testing.T) {
+				other := newDrandServer(t, scheme)
+				c := testCanary(t, nil, withFreshness(freshness))
+				c.FreshnessProof = &canarytail.FreshnessProof{Drand: beacon}
+				ok, _ := c.ValidateWithOptions(canarytail.ValidateOptions{Offline: true, Drand: &other.info})
+				assert.False(t, ok)
+			})
+
+			t.Run("stale round", func(t *testing.T) {
+				old := d.beacon(2)
+				c := testCanary(t, nil, withFreshness(canarytail.FormatDrandFreshness(old)))
+				c.FreshnessProof = &canarytail.FreshnessProof{Drand: old}
+				ok, err := c.ValidateWithOptions(canarytail.ValidateOptions{Offline: true, Drand: info})
+				assert.False(t, ok)
+				assert.Contains(t, err.Error(), "older than the release date")
+			})
+		})
+	}
+
+	_, err := canarytail.FetchDrandChainInfo(newDrandServer(t, canarytail.DrandSchemeChained).URL, fmt.Sprintf("%064x", 0))
+	assert.Error(t, err, "unexpected chain hash")
+}
@@ -0,0 +1,104 @@
+package canarytail
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// FreshnessProof embeds the header of the freshness block in the canary, so that the block hash, its
+// proof of work and its timestamp can be checked offline. It does not need to be signed: the header
+// hashes to the signed Freshness claim, and every header of the chain links to the next one.
+type FreshnessProof struct {
+	// Header is the hex encoded 80-byte header of the freshness block
+	Header string `json:"header,omitempty"`
+	// Chain optionally holds the hex encoded headers of the blocks preceding the freshness block,
+	// oldest first, adding their proof of work to the one of the freshness block
+	Chain []string `json:"chain,omitempty"`
+	// Drand holds the beacon of the freshness round instead, when the canary uses drand for freshness
+	Drand *DrandBeacon `json:"drand,omitempty"`
+}
+
+// NewFreshnessProof fetches the header of a block, and the headers of as many ancestors, from source
+func NewFreshnessProof(source HeaderSource, blockHash []byte, ancestors int) (*FreshnessProof, error) {
+	if ancestors < 0 {
+		return nil, fmt.Errorf("the number of ancestor headers must not be negative, got %d", ancestors)
+	}
+	proof := &FreshnessProof{Chain: make([]string, 0, ancestors)}
+	hash := blockHash
+	for i := 0; i <= ancestors; i++ {
+		raw, err := source.BlockHeader(hash)
+		if err != nil {
+			return nil, err
+		}
+		h, err := ParseBlockHeader(raw)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(h.Hash(), hash) {
+			return nil, fmt.Errorf("%s returned header %x for block %x", source.Name(), h.Hash(), hash)
+		}
+		if i == 0 {
+			proof.Header = hex.EncodeToString(raw)
+		} else {
+			proof.Chain = append([]string{hex.EncodeToString(raw)}, proof.Chain...)
+		}
+		hash = h.PreviousBlockHash()
+	}
+	if len(proof.Chain) == 0 {
+		proof.Chain = nil
+	}
+	return proof, nil
+}
+
+func parseHexHeader(s string) (BlockHeader, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return BlockHeader{}, err
+	}
+	return ParseBlockHeader(raw)
+}
+
+// Verify checks that the proof is the header of the given freshness block, that every header meets
+// its proof of work under params, that the chain links up to the freshness block and that the proof
+// carries at least the minimum work of params.
+// It returns the freshness block header and the total work of the proof.
+func (p *FreshnessProof) Verify(freshness string, params *ChainParams) (BlockHeader, *big.Int, error) {
+	header, err := parseHexHeader(p.Header)
+	if err != nil {
+		return BlockHeader{}, nil, fmt.Errorf("invalid freshness block header: %v", err)
+	}
+	if !strings.EqualFold(hex.EncodeToString(header.Hash()), freshness) {
+		return BlockHeader{}, nil, fmt.Errorf("the freshness block header hashes to %x, not to the freshness block %s", header.Hash(), freshness)
+	}
+	if err := header.CheckProofOfWork(params.PowLimit); err != nil {
+		return BlockHeader{}, nil, err
+	}
+
+	work := header.Work()
+	next := header
+	for i := len(p.Chain) - 1; i >= 0; i-- {
+		h, err := parseHexHeader(p.Chain[i])
+		if err != nil {
+			return BlockHeader{}, nil, fmt.Errorf("invalid header %d of the freshness proof chain: %v", i, err)
+		}
+		if !bytes.Equal(next.PrevBlock[:], h.hash()) {
+			return BlockHeader{}, nil, fmt.Errorf("header %d of the freshness proof chain does not link to the next one", i)
+		}
+		if err := h.CheckProofOfWork(params.PowLimit); err != nil {
+			return BlockHeader{}, nil, err
+		}
+		work.Add(work, h.Work())
+		next = h
+	}
+	if params.MinProofWork != nil && work.Cmp(params.MinProofWork) < 0 {
+		return BlockHeader{}, nil, fmt.Errorf("the freshness proof carries a work of %v, less than the %v required on %s", work, params.MinProofWork, params.Name)
+	}
+	return header, work, nil
+}
+
+// ErrNoFreshnessProof is returned when validating offline a canary that embeds no freshness proof
+var ErrNoFreshnessProof = errors.New("the canary embeds no freshness proof, its freshness block cannot be checked offline")
@@ -0,0 +1,90 @@
+package canarytail_test
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreshnessProof(t *testing.T) {
+	genesis := regtestGenesis(t)
+	ancestors := mineHeaders(t, genesis, 3, 0)
+	freshnessBlock := mineHeader(t, ancestors[2], uint32(time.Now().Add(-10*time.Minute).Unix()), 0)
+
+	source := &headerSource{headers: make(map[string][]byte), tip: freshnessBlock.Hash()}
+	for _, h := range append(ancestors, freshnessBlock) {
+		source.headers[hex.EncodeToString(h.Hash())] = h.Bytes()
+	}
+
+	proof, err := canarytail.NewFreshnessProof(source, freshnessBlock.Hash(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(freshnessBlock.Bytes()), proof.Header)
+	assert.Equal(t, []string{hex.EncodeToString(ancestors[1].Bytes()), hex.EncodeToString(ancestors[2].Bytes())}, proof.Chain)
+
+	_, err = canarytail.NewFreshnessProof(source, freshnessBlock.Hash(), -1)
+	assert.Error(t, err, "a negative number of ancestors is refused")
+
+	freshness := hex.EncodeToString(freshnessBlock.Hash())
+	opts := canarytail.ValidateOptions{Offline: true, Params: canarytail.RegressionNetParams}
+
+	t.Run("valid offline", func(t *testing.T) {
+		c := testCanary(t, nil, withFreshness(freshness))
+		c.FreshnessProof = proof
+		ok, err := c.ValidateWithOptions(opts)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("no proof", func(t *testing.T) {
+		c := testCanary(t, nil, withFreshness(freshness))
+		ok, err := c.ValidateWithOptions(opts)
+		assert.False(t, ok)
+		assert.Contains(t, err.Error(), canarytail.ErrNoFreshnessProof.Error())
+	})
+
+	t.Run("header of another block", func(t *testing.T) {
+		c := testCanary(t, nil, withFreshness(freshness))
+		c.FreshnessProof = &canarytail.FreshnessProof{Header: hex.EncodeToString(ancestors[2].Bytes())}
+		ok, _ := c.ValidateWithOptions(opts)
+		assert.False(t, ok)
+	})
+
+	t.Run("broken chain", func(t *testing.T) {
+		_, _, err := (&canarytail.FreshnessProof{
+			Header: proof.Header,
+			Chain:  []string{hex.EncodeToString(ancestors[2].Bytes()), hex.EncodeToString(ancestors[1].Bytes())},
+		}).Verify(freshness, canarytail.RegressionNetParams)
+		assert.Error(t, err)
+	})
+
+	t.Run("not enough proof of work for the network", func(t *testing.T) {
+		_, _, err := proof.Verify(freshness, canarytail.MainNetParams)
+		assert.Error(t, err)
+	})
+
+	t.Run("not enough work", func(t *testing.T) {
+		params := *canarytail.RegressionNetParams
+		params.MinProofWork = big.NewInt(6)
+		_, work, err := proof.Verify(freshness, &params)
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), work.Int64())
+
+		// a header mined for the occasion, without the work of its ancestors
+		_, _, err = (&canarytail.FreshnessProof{Header: proof.Header}).Verify(freshness, &params)
+		assert.Error(t, err)
+	})
+
+	t.Run("stale block", func(t *testing.T) {
+		c := testCanary(t, nil, withFreshness(hex.EncodeToString(ancestors[2].Hash())))
+		c.FreshnessProof = &canarytail.FreshnessProof{Header: hex.EncodeToString(ancestors[2].Bytes())}
+		ok, err := c.ValidateWithOptions(opts)
+		assert.False(t, ok)
+		assert.Contains(t, err.Error(), "older than the release date")
+	})
+}
@@ -4,6 +4,10 @@ go 1.14
 
 require (
 	github.com/alecthomas/kong v0.2.11
+	github.com/cloudflare/circl v1.3.7
+	github.com/miekg/pkcs11 v1.1.2
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/stretchr/testify v1.6.1
-	golang.org/x/crypto 0.0.0-20220314234659-1baeb1ce4c0b 
+	golang.org/x/crypto v0.17.0
+	golang.org/x/term v0.15.0
 )
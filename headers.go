@@ -0,0 +1,635 @@
+package canarytail
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+)
+
+// BlockHeaderSize is the size in bytes of a serialized Bitcoin block header
+const BlockHeaderSize = 80
+
+// BlockHeader represents a raw Bitcoin block header
+// https://developer.bitcoin.org/reference/block_chain.html#block-headers
+//
+// Hashes are kept in the internal (little endian) byte order used on the wire. Use Hash and
+// PreviousBlockHash to get them in the usual display order, as used in the Freshness claim.
+type BlockHeader struct {
+	Version    int32
+	PrevBlock  [32]byte
+	MerkleRoot [32]byte
+	Time       uint32
+	Bits       uint32
+	Nonce      uint32
+}
+
+// ParseBlockHeader parses a serialized 80-byte block header
+func ParseBlockHeader(raw []byte) (BlockHeader, error) {
+	var h BlockHeader
+	if len(raw) != BlockHeaderSize {
+		return h, fmt.Errorf("a block header is %d bytes long, got %d", BlockHeaderSize, len(raw))
+	}
+	h.Version = int32(binary.LittleEndian.Uint32(raw[0:4]))
+	copy(h.PrevBlock[:], raw[4:36])
+	copy(h.MerkleRoot[:], raw[36:68])
+	h.Time = binary.LittleEndian.Uint32(raw[68:72])
+	h.Bits = binary.LittleEndian.Uint32(raw[72:76])
+	h.Nonce = binary.LittleEndian.Uint32(raw[76:80])
+	return h, nil
+}
+
+// Bytes serializes the header to its 80-byte wire format
+func (h BlockHeader) Bytes() []byte {
+	raw := make([]byte, BlockHeaderSize)
+	binary.LittleEndian.PutUint32(raw[0:4], uint32(h.Version))
+	copy(raw[4:36], h.PrevBlock[:])
+	copy(raw[36:68], h.MerkleRoot[:])
+	binary.LittleEndian.PutUint32(raw[68:72], h.Time)
+	binary.LittleEndian.PutUint32(raw[72:76], h.Bits)
+	binary.LittleEndian.PutUint32(raw[76:80], h.Nonce)
+	return raw
+}
+
+// Hash computes the block hash (double SHA-256 of the header), in display byte order
+func (h BlockHeader) Hash() []byte {
+	return reverseBytes(h.hash())
+}
+
+// hash computes the block hash in internal byte order
+func (h BlockHeader) hash() []byte {
+	first := sha256.Sum256(h.Bytes())
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// PreviousBlockHash returns the hash of the previous block, in display byte order
+func (h BlockHeader) PreviousBlockHash() []byte {
+	return reverseBytes(h.PrevBlock[:])
+}
+
+// Timestamp returns the time of the block
+func (h BlockHeader) Timestamp() time.Time {
+	return time.Unix(int64(h.Time), 0)
+}
+
+// CheckProofOfWork checks that the header hash meets the target encoded in its bits, and that the
+// target is not easier than powLimit
+func (h BlockHeader) CheckProofOfWork(powLimit *big.Int) error {
+	target := CompactToTarget(h.Bits)
+	if target.Sign() <= 0 {
+		return fmt.Errorf("block %x has a non positive target", h.Hash())
+	}
+	if target.Cmp(powLimit) > 0 {
+		return fmt.Errorf("block %x has a target above the proof of work limit", h.Hash())
+	}
+	hashNum := new(big.Int).SetBytes(h.Hash())
+	if hashNum.Cmp(target) > 0 {
+		return fmt.Errorf("block %x does not meet its proof of work target", h.Hash())
+	}
+	return nil
+}
+
+// Work returns the expected number of hashes needed to mine the header, 2^256 / (target+1)
+func (h BlockHeader) Work() *big.Int {
+	target := CompactToTarget(h.Bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// CompactToTarget converts the compact "bits" representation of a target into a number
+func CompactToTarget(bits uint32) *big.Int {
+	mantissa := int64(bits & 0x007fffff)
+	negative := bits&0x00800000 != 0
+	exponent := uint(bits >> 24)
+
+	var target *big.Int
+	if exponent <= 3 {
+		target = big.NewInt(mantissa >> (8 * (3 - exponent)))
+	} else {
+		target = new(big.Int).Lsh(big.NewInt(mantissa), 8*(exponent-3))
+	}
+	if negative {
+		target = target.Neg(target)
+	}
+	return target
+}
+
+// TargetToCompact converts a target into its compact "bits" representation
+func TargetToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(target.Bits()[0]) << (8 * (3 - exponent))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, 8*(exponent-3)).Bits()[0])
+	}
+	// the mantissa is signed, so avoid setting the sign bit
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	bits := uint32(exponent<<24) | mantissa
+	if target.Sign() < 0 {
+		bits |= 0x00800000
+	}
+	return bits
+}
+
+func reverseBytes(b []byte) []byte {
+	reversed := make([]byte, len(b))
+	for i := range b {
+		reversed[len(b)-1-i] = b[i]
+	}
+	return reversed
+}
+
+// ChainParams defines the consensus rules checked when verifying a header chain
+type ChainParams struct {
+	Name string
+	// GenesisHash is the hash of the block at height 0, in display byte order
+	GenesisHash string
+	// PowLimit is the easiest target allowed
+	PowLimit *big.Int
+	// TargetTimespan is the time a retarget interval should take
+	TargetTimespan time.Duration
+	// TargetSpacing is the time between blocks the difficulty aims at
+	TargetSpacing time.Duration
+	// NoRetargeting disables difficulty adjustments, as on regtest
+	NoRetargeting bool
+	// MinProofWork is the least total work a freshness proof must carry, if set. Offline, a freshness
+	// proof cannot be compared with the best chain: only what it costs to mine tells a real block from
+	// one mined for the occasion.
+	MinProofWork *big.Int
+}
+
+// RetargetInterval is the number of blocks between difficulty adjustments
+func (p *ChainParams) RetargetInterval() int {
+	return int(p.TargetTimespan / p.TargetSpacing)
+}
+
+// MainNetParams are the consensus rules of the Bitcoin main network
+var MainNetParams = &ChainParams{
+	Name:           "mainnet",
+	GenesisHash:    "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+	PowLimit:       CompactToTarget(0x1d00ffff),
+	TargetTimespan: 14 * 24 * time.Hour,
+	TargetSpacing:  10 * time.Minute,
+	// the work of a block at difficulty 2^43, about 8.8 trillion: a block of the main chain has had
+	// more since 2019
+	MinProofWork: new(big.Int).Lsh(big.NewInt(1), 75),
+}
+
+// RegressionNetParams are the consensus rules of the regression test network, useful for synthetic chains
+var RegressionNetParams = &ChainParams{
+	Name:           "regtest",
+	GenesisHash:    "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+	PowLimit:       CompactToTarget(0x207fffff),
+	TargetTimespan: 14 * 24 * time.Hour,
+	TargetSpacing:  10 * time.Minute,
+	NoRetargeting:  true,
+}
+
+// maxFutureBlockTime is how far in the future a block timestamp is allowed to be
+const maxFutureBlockTime = 2 * time.Hour
+
+// medianTimeBlocks is the number of previous blocks used to compute the median time past
+const medianTimeBlocks = 11
+
+var (
+	// ErrUnknownBlock is returned when a block is not part of the header chain
+	ErrUnknownBlock = errors.New("block not found in the header chain")
+	// ErrNotConnected is returned when appended headers do not connect to the header chain
+	ErrNotConnected = errors.New("headers do not connect to the header chain")
+	// ErrLessWork is returned when appended headers form a fork with less work than the best chain
+	ErrLessWork = errors.New("headers form a fork with less work than the best chain")
+)
+
+// HeaderChain is a locally verified chain of Bitcoin block headers, used to check that a freshness
+// block is part of the best chain without trusting any API.
+//
+// The chain starts at an anchor header which is trusted as is: either the genesis block or a
+// checkpoint at a retarget boundary. Every following header is checked for linkage, proof of work,
+// difficulty and timestamp before being accepted. Headers can come from any untrusted source.
+type HeaderChain struct {
+	Params *ChainParams
+	// StartHeight is the height of the anchor header
+	StartHeight int
+
+	headers []BlockHeader
+	index   map[string]int
+	work    []*big.Int // cumulative work, from the anchor
+}
+
+// NewHeaderChain instantiates a header chain anchored at the given header
+func NewHeaderChain(params *ChainParams, startHeight int, anchor BlockHeader) (*HeaderChain, error) {
+	if startHeight < 0 {
+		return nil, fmt.Errorf("invalid start height %d", startHeight)
+	}
+	if startHeight == 0 && hex.EncodeToString(anchor.Hash()) != params.GenesisHash {
+		return nil, fmt.Errorf("the header at height 0 is not the %s genesis block", params.Name)
+	}
+	if !params.NoRetargeting && startHeight%params.RetargetInterval() != 0 {
+		return nil, fmt.Errorf("a checkpoint must be at a multiple of %d blocks, got height %d", params.RetargetInterval(), startHeight)
+	}
+	if err := anchor.CheckProofOfWork(params.PowLimit); err != nil {
+		return nil, err
+	}
+	chain := &HeaderChain{
+		Params:      params,
+		StartHeight: startHeight,
+		index:       make(map[string]int),
+	}
+	chain.push(anchor)
+	return chain, nil
+}
+
+func (c *HeaderChain) push(h BlockHeader) {
+	work := h.Work()
+	if len(c.work) > 0 {
+		work.Add(work, c.work[len(c.work)-1])
+	}
+	c.index[hex.EncodeToString(h.Hash())] = len(c.headers)
+	c.headers = append(c.headers, h)
+	c.work = append(c.work, work)
+}
+
+// truncate drops every header after position n-1
+func (c *HeaderChain) truncate(n int) {
+	for _, h := range c.headers[n:] {
+		delete(c.index, hex.EncodeToString(h.Hash()))
+	}
+	c.headers = c.headers[:n]
+	c.work = c.work[:n]
+}
+
+// Len returns the number of headers in the chain, including the anchor
+func (c *HeaderChain) Len() int {
+	return len(c.headers)
+}
+
+// Height returns the height of the tip of the chain
+func (c *HeaderChain) Height() int {
+	return c.StartHeight + len(c.headers) - 1
+}
+
+// Tip returns the last header of the chain
+func (c *HeaderChain) Tip() BlockHeader {
+	return c.headers[len(c.headers)-1]
+}
+
+// Work returns the cumulative work of the chain since its anchor
+func (c *HeaderChain) Work() *big.Int {
+	return new(big.Int).Set(c.work[len(c.work)-1])
+}
+
+// Header returns the header at the given height of the best chain
+func (c *HeaderChain) Header(height int) (BlockHeader, bool) {
+	i := height - c.StartHeight
+	if i < 0 || i >= len(c.headers) {
+		return BlockHeader{}, false
+	}
+	return c.headers[i], true
+}
+
+// HeaderAt returns the header at the given height of the best chain, so a HeaderChain can be used as a HeaderLookup
+func (c *HeaderChain) HeaderAt(height int) (BlockHeader, error) {
+	h, ok := c.Header(height)
+	if !ok {
+		return BlockHeader{}, fmt.Errorf("height %d is outside the header chain (%d to %d)", height, c.StartHeight, c.Height())
+	}
+	return h, nil
+}
+
+// HeightOf returns the height of the given block hash (display order) in the best chain
+func (c *HeaderChain) HeightOf(blockHash []byte) (int, bool) {
+	i, ok := c.index[hex.EncodeToString(blockHash)]
+	if !ok {
+		return 0, false
+	}
+	return c.StartHeight + i, true
+}
+
+// medianTimePast returns the median time of the medianTimeBlocks headers up to position i
+func (c *HeaderChain) medianTimePast(i int) uint32 {
+	times := make([]uint32, 0, medianTimeBlocks)
+	for j := i; j >= 0 && len(times) < medianTimeBlocks; j-- {
+		times = append(times, c.headers[j].Time)
+	}
+	sort.Slice(times, func(a, b int) bool { return times[a] < times[b] })
+	return times[len(times)/2]
+}
+
+// expectedBits computes the bits a header following position i must have
+func (c *HeaderChain) expectedBits(i int) (uint32, error) {
+	prev := c.headers[i]
+	height := c.StartHeight + i + 1
+	interval := c.Params.RetargetInterval()
+	if c.Params.NoRetargeting || height%interval != 0 {
+		return prev.Bits, nil
+	}
+
+	first := i - (interval - 1)
+	if first < 0 {
+		return 0, fmt.Errorf("cannot check the difficulty adjustment at height %d without the headers since height %d", height, height-interval)
+	}
+	timespan := time.Duration(int64(prev.Time)-int64(c.headers[first].Time)) * time.Second
+	if timespan < c.Params.TargetTimespan/4 {
+		timespan = c.Params.TargetTimespan / 4
+	}
+	if timespan > c.Params.TargetTimespan*4 {
+		timespan = c.Params.TargetTimespan * 4
+	}
+
+	target := CompactToTarget(prev.Bits)
+	target.Mul(target, big.NewInt(int64(timespan/time.Second)))
+	target.Div(target, big.NewInt(int64(c.Params.TargetTimespan/time.Second)))
+	if target.Cmp(c.Params.PowLimit) > 0 {
+		target.Set(c.Params.PowLimit)
+	}
+	return TargetToCompact(target), nil
+}
+
+// check verifies a header that is to follow position i
+func (c *HeaderChain) check(i int, h BlockHeader, now time.Time) error {
+	prev := c.headers[i]
+	if !bytes.Equal(h.PrevBlock[:], prev.hash()) {
+		return fmt.Errorf("block %x does not link to block %x", h.Hash(), prev.Hash())
+	}
+	bits, err := c.expectedBits(i)
+	if err != nil {
+		return err
+	}
+	if h.Bits != bits {
+		return fmt.Errorf("block %x has bits %08x, expected %08x", h.Hash(), h.Bits, bits)
+	}
+	if err := h.CheckProofOfWork(c.Params.PowLimit); err != nil {
+		return err
+	}
+	if h.Time <= c.medianTimePast(i) {
+		return fmt.Errorf("block %x has a timestamp before the median time of the previous blocks", h.Hash())
+	}
+	if h.Timestamp().After(now.Add(maxFutureBlockTime)) {
+		return fmt.Errorf("block %x has a timestamp too far in the future: %v", h.Hash(), h.Timestamp())
+	}
+	return nil
+}
+
+// Append verifies and appends a batch of consecutive headers. The first header must link to a header
+// already in the chain. If it does not link to the tip, the batch is a fork and it only replaces the
+// current best chain when it has more work; otherwise ErrLessWork is returned.
+// It returns the number of headers that were not already in the chain.
+func (c *HeaderChain) Append(headers []BlockHeader) (int, error) {
+	// skip the headers we already know
+	for len(headers) > 0 {
+		if _, ok := c.index[hex.EncodeToString(headers[0].Hash())]; !ok {
+			break
+		}
+		headers = headers[1:]
+	}
+	if len(headers) == 0 {
+		return 0, nil
+	}
+
+	forkPoint, ok := c.index[hex.EncodeToString(headers[0].PreviousBlockHash())]
+	if !ok {
+		return 0, ErrNotConnected
+	}
+
+	// verify the batch on a copy of the chain, truncated at the fork point
+	candidate := &HeaderChain{
+		Params:      c.Params,
+		StartHeight: c.StartHeight,
+		headers:     append([]BlockHeader{}, c.headers...),
+		work:        append([]*big.Int{}, c.work...),
+		index:       make(map[string]int, len(c.index)+len(headers)),
+	}
+	for k, v := range c.index {
+		candidate.index[k] = v
+	}
+	candidate.truncate(forkPoint + 1)
+
+	now := time.Now()
+	for _, h := range headers {
+		if err := candidate.check(candidate.Len()-1, h, now); err != nil {
+			return 0, err
+		}
+		candidate.push(h)
+	}
+
+	if candidate.Work().Cmp(c.Work()) <= 0 {
+		return 0, ErrLessWork
+	}
+	*c = *candidate
+	return len(headers), nil
+}
+
+// Name identifies the header chain in error messages
+func (c *HeaderChain) Name() string {
+	return fmt.Sprintf("local %s header chain", c.Params.Name)
+}
+
+// LatestBlockHash returns the hash of the tip of the header chain
+func (c *HeaderChain) LatestBlockHash() ([]byte, error) {
+	return c.Tip().Hash(), nil
+}
+
+// BlockInfo returns the information of a block of the best chain. It fails with ErrUnknownBlock if the
+// block is not on the best chain, so a HeaderChain can be used as a BlockBackend.
+func (c *HeaderChain) BlockInfo(blockHash []byte) (BlockInfo, error) {
+	height, ok := c.HeightOf(blockHash)
+	if !ok {
+		return BlockInfo{}, ErrUnknownBlock
+	}
+	h, _ := c.Header(height)
+	return BlockInfo{
+		Hash:          hex.EncodeToString(h.Hash()),
+		PreviousBlock: hex.EncodeToString(h.PreviousBlockHash()),
+		Time:          int64(h.Time),
+		Bits:          int64(h.Bits),
+		MainChain:     true,
+		Height:        height,
+	}, nil
+}
+
+// headerChainMagic identifies header chain files
+var headerChainMagic = []byte("CTHC")
+
+// WriteTo serializes the chain: a magic, the start height and the raw headers
+func (c *HeaderChain) WriteTo(w io.Writer) (int64, error) {
+	buf := bufio.NewWriter(w)
+	buf.Write(headerChainMagic)
+	binary.Write(buf, binary.LittleEndian, uint32(c.StartHeight))
+	for _, h := range c.headers {
+		buf.Write(h.Bytes())
+	}
+	if err := buf.Flush(); err != nil {
+		return 0, err
+	}
+	return int64(len(headerChainMagic) + 4 + len(c.headers)*BlockHeaderSize), nil
+}
+
+// Save writes the chain to a file
+func (c *HeaderChain) Save(path string) error {
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadHeaderChain reads a chain saved with Save, verifying every header again
+func LoadHeaderChain(path string, params *ChainParams) (*HeaderChain, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(contents) < len(headerChainMagic)+4 || !bytes.Equal(contents[:len(headerChainMagic)], headerChainMagic) {
+		return nil, fmt.Errorf("%s is not a header chain file", path)
+	}
+	startHeight := int(binary.LittleEndian.Uint32(contents[len(headerChainMagic):]))
+	headers, err := ReadHeaders(bytes.NewReader(contents[len(headerChainMagic)+4:]))
+	if err != nil {
+		return nil, err
+	}
+	return ImportHeaderChain(params, startHeight, headers)
+}
+
+// ImportHeaderChain verifies a list of consecutive headers, the first one being the anchor at startHeight
+func ImportHeaderChain(params *ChainParams, startHeight int, headers []BlockHeader) (*HeaderChain, error) {
+	if len(headers) == 0 {
+		return nil, errors.New("no headers to import")
+	}
+	chain, err := NewHeaderChain(params, startHeight, headers[0])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := chain.Append(headers[1:]); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// ReadHeaders reads raw concatenated 80-byte headers
+func ReadHeaders(r io.Reader) ([]BlockHeader, error) {
+	headers := make([]BlockHeader, 0)
+	raw := make([]byte, BlockHeaderSize)
+	for {
+		_, err := io.ReadFull(r, raw)
+		if err == io.EOF {
+			return headers, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read header %d: %v", len(headers), err)
+		}
+		h, err := ParseBlockHeader(raw)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, h)
+	}
+}
+
+// HeaderSource is an untrusted source of raw block headers used to update a HeaderChain
+type HeaderSource interface {
+	Name() string
+	LatestBlockHash() ([]byte, error)
+	// BlockHeader retrieves the serialized 80-byte header of a block
+	BlockHeader(blockHash []byte) ([]byte, error)
+}
+
+// Update fetches the headers missing between the chain tip and the tip of the source, walking back
+// from the latter at most maxHeaders blocks, and appends them after verification. When the chain is
+// further behind, a source that is also a HeaderLookup is read forward from the chain tip by height
+// instead, in verified batches of maxHeaders headers, until the walk back connects.
+// It returns the number of new headers, which stay appended even when a later batch fails.
+func (c *HeaderChain) Update(source HeaderSource, maxHeaders int) (int, error) {
+	hash, err := source.LatestBlockHash()
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	missing := make([]BlockHeader, 0)
+	for {
+		if _, ok := c.index[hex.EncodeToString(hash)]; ok {
+			break
+		}
+		if len(missing) >= maxHeaders {
+			lookup, ok := source.(HeaderLookup)
+			if !ok {
+				return 0, fmt.Errorf("%s is more than %d headers ahead of the header chain", source.Name(), maxHeaders)
+			}
+			if added, err = c.catchUp(lookup, hash, maxHeaders); err != nil {
+				return added, err
+			}
+			break
+		}
+		raw, err := source.BlockHeader(hash)
+		if err != nil {
+			return 0, err
+		}
+		h, err := ParseBlockHeader(raw)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(h.Hash(), hash) {
+			return 0, fmt.Errorf("%s returned header %x for block %x", source.Name(), h.Hash(), hash)
+		}
+		missing = append(missing, h)
+		hash = h.PreviousBlockHash()
+	}
+
+	// the headers were fetched from the tip backwards
+	for i, j := 0, len(missing)-1; i < j; i, j = i+1, j-1 {
+		missing[i], missing[j] = missing[j], missing[i]
+	}
+	n, err := c.Append(missing)
+	return added + n, err
+}
+
+// catchUp reads the headers after the chain tip from lookup by height and appends them in batches
+// of batchSize until the chain contains the given block hash. It returns the number of new headers.
+func (c *HeaderChain) catchUp(lookup HeaderLookup, blockHash []byte, batchSize int) (int, error) {
+	added := 0
+	for {
+		if _, ok := c.index[hex.EncodeToString(blockHash)]; ok {
+			return added, nil
+		}
+		batch := make([]BlockHeader, batchSize)
+		for i := range batch {
+			h, err := lookup.HeaderAt(c.Height() + 1 + i)
+			if err != nil {
+				return added, err
+			}
+			batch[i] = h
+		}
+		n, err := c.Append(batch)
+		added += n
+		if err != nil {
+			return added, err
+		}
+		if n == 0 {
+			return added, fmt.Errorf("the headers after height %d are already in the header chain", c.Height())
+		}
+	}
+}
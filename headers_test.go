@@ -0,0 +1,251 @@
+package canarytail_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// regtestGenesis returns the genesis block header of the regression test network
+func regtestGenesis(t *testing.T) canarytail.BlockHeader {
+	merkleRoot, _ := hex.DecodeString("3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a")
+	h := canarytail.BlockHeader{Version: 1, Time: 1296688602, Bits: 0x207fffff, Nonce: 2}
+	copy(h.MerkleRoot[:], merkleRoot)
+	require.Equal(t, canarytail.RegressionNetParams.GenesisHash, hex.EncodeToString(h.Hash()))
+	return h
+}
+
+// mineHeader mines a header on top of prev, which is trivial with the regtest proof of work limit
+func mineHeader(t *testing.T, prev canarytail.BlockHeader, blockTime uint32, tag byte) canarytail.BlockHeader {
+	h := canarytail.BlockHeader{Version: 4, Time: blockTime, Bits: prev.Bits}
+	copy(h.PrevBlock[:], reverse(prev.Hash()))
+	h.MerkleRoot[0] = tag
+	for ; h.CheckProofOfWork(canarytail.RegressionNetParams.PowLimit) != nil; h.Nonce++ {
+	}
+	return h
+}
+
+// mineHeaders mines n headers on top of prev, spaced 10 minutes apart
+func mineHeaders(t *testing.T, prev canarytail.BlockHeader, n int, tag byte) []canarytail.BlockHeader {
+	headers := make([]canarytail.BlockHeader, 0, n)
+	for i := 0; i < n; i++ {
+		prev = mineHeader(t, prev, prev.Time+600, tag)
+		headers = append(headers, prev)
+	}
+	return headers
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func TestBlockHeaderRoundTrip(t *testing.T) {
+	genesis := regtestGenesis(t)
+	parsed, err := canarytail.ParseBlockHeader(genesis.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, genesis, parsed)
+
+	_, err = canarytail.ParseBlockHeader(make([]byte, 79))
+	assert.Error(t, err)
+}
+
+func TestCompactTarget(t *testing.T) {
+	for _, bits := range []uint32{0x1d00ffff, 0x1a0d69d7, 0x207fffff, 0x170da8a1} {
+		assert.Equal(t, bits, canarytail.TargetToCompact(canarytail.CompactToTarget(bits)), fmt.Sprintf("%08x", bits))
+	}
+}
+
+func TestHeaderChain(t *testing.T) {
+	genesis := regtestGenesis(t)
+	headers := mineHeaders(t, genesis, 20, 0)
+
+	chain, err := canarytail.ImportHeaderChain(canarytail.RegressionNetParams, 0, append([]canarytail.BlockHeader{genesis}, headers...))
+	require.NoError(t, err)
+	assert.Equal(t, 20, chain.Height())
+
+	info, err := chain.BlockInfo(headers[9].Hash())
+	require.NoError(t, err)
+	assert.Equal(t, 10, info.Height)
+	assert.Equal(t, int64(headers[9].Time), info.Time)
+
+	_, err = chain.BlockInfo(make([]byte, 32))
+	assert.Equal(t, canarytail.ErrUnknownBlock, err)
+
+	t.Run("save and load", func(t *testing.T) {
+		fp := path.Join(t.TempDir(), "headers.dat")
+		require.NoError(t, chain.Save(fp))
+		loaded, err := canarytail.LoadHeaderChain(fp, canarytail.RegressionNetParams)
+		require.NoError(t, err)
+		assert.Equal(t, chain.Height(), loaded.Height())
+		assert.Equal(t, chain.Tip(), loaded.Tip())
+	})
+
+	t.Run("wrong genesis", func(t *testing.T) {
+		_, err := canarytail.ImportHeaderChain(canarytail.RegressionNetParams, 0, headers)
+		assert.Error(t, err)
+	})
+
+	t.Run("not connected", func(t *testing.T) {
+		chain, _ := canarytail.ImportHeaderChain(canarytail.RegressionNetParams, 0, []canarytail.BlockHeader{genesis})
+		_, err := chain.Append(headers[1:])
+		assert.Equal(t, canarytail.ErrNotConnected, err)
+	})
+
+	t.Run("invalid proof of work", func(t *testing.T) {
+		chain, _ := canarytail.ImportHeaderChain(canarytail.RegressionNetParams, 0, []canarytail.BlockHeader{genesis})
+		bad := headers[0]
+		for bad.CheckProofOfWork(canarytail.RegressionNetParams.PowLimit) == nil {
+			bad.Nonce++
+		}
+		_, err := chain.Append([]canarytail.BlockHeader{bad})
+		assert.Error(t, err)
+	})
+
+	t.Run("unexpected bits", func(t *testing.T) {
+		chain, _ := canarytail.ImportHeaderChain(canarytail.RegressionNetParams, 0, []canarytail.BlockHeader{genesis})
+		bad := mineHeader(t, genesis, genesis.Time+600, 0)
+		bad.Bits = 0x1f7fffff
+		_, err := chain.Append([]canarytail.BlockHeader{bad})
+		assert.Error(t, err)
+	})
+
+	t.Run("timestamp before median time past", func(t *testing.T) {
+		chain, _ := canarytail.ImportHeaderChain(canarytail.RegressionNetParams, 0, append([]canarytail.BlockHeader{genesis}, headers...))
+		_, err := chain.Append([]canarytail.BlockHeader{mineHeader(t, chain.Tip(), headers[5].Time, 0)})
+		assert.Error(t, err)
+	})
+
+	t.Run("timestamp in the future", func(t *testing.T) {
+		chain, _ := canarytail.ImportHeaderChain(canarytail.RegressionNetParams, 0, append([]canarytail.BlockHeader{genesis}, headers...))
+		_, err := chain.Append([]canarytail.BlockHeader{mineHeader(t, chain.Tip(), uint32(time.Now().Add(3*time.Hour).Unix()), 0)})
+		assert.Error(t, err)
+	})
+}
+
+func TestHeaderChainFork(t *testing.T) {
+	genesis := regtestGenesis(t)
+	headers := mineHeaders(t, genesis, 10, 0)
+	chain, err := canarytail.ImportHeaderChain(canarytail.RegressionNetParams, 0, append([]canarytail.BlockHeader{genesis}, headers...))
+	require.NoError(t, err)
+
+	// a shorter fork is rejected
+	shortFork := mineHeaders(t, headers[4], 3, 1)
+	_, err = chain.Append(shortFork)
+	assert.Equal(t, canarytail.ErrLessWork, err)
+	assert.Equal(t, headers[9], chain.Tip())
+
+	// a longer one becomes the best chain
+	longFork := mineHeaders(t, headers[4], 8, 2)
+	added, err := chain.Append(longFork)
+	require.NoError(t, err)
+	assert.Equal(t, 8, added)
+	assert.Equal(t, 13, chain.Height())
+
+	_, err = chain.BlockInfo(headers[9].Hash())
+	assert.Equal(t, canarytail.ErrUnknownBlock, err, "blocks of the old chain are no longer on the best chain")
+	_, err = chain.BlockInfo(longFork[0].Hash())
+	assert.NoError(t, err)
+}
+
+// headerSource serves headers from memory, as an untrusted source would
+type headerSource struct {
+	headers map[string][]byte
+	tip     []byte
+}
+
+func (s *headerSource) Name() string { return "test source" }
+
+func (s *headerSource) LatestBlockHash() ([]byte, error) { return s.tip, nil }
+
+func (s *headerSource) BlockHeader(blockHash []byte) ([]byte, error) {
+	raw, ok := s.headers[hex.EncodeToString(blockHash)]
+	if !ok {
+		return nil, fmt.Errorf("unknown block %x", blockHash)
+	}
+	return raw, nil
+}
+
+func TestHeaderChainUpdate(t *testing.T) {
+	genesis := regtestGenesis(t)
+	headers := mineHeaders(t, genesis, 30, 0)
+	source := &headerSource{headers: make(map[string][]byte), tip: headers[29].Hash()}
+	for _, h := range headers {
+		source.headers[hex.EncodeToString(h.Hash())] = h.Bytes()
+	}
+
+	chain, err := canarytail.ImportHeaderChain(canarytail.RegressionNetParams, 0, append([]canarytail.BlockHeader{genesis}, headers[:10]...))
+	require.NoError(t, err)
+
+	_, err = chain.Update(source, 5)
+	assert.Error(t, err, "the source is further ahead than allowed")
+
+	added, err := chain.Update(source, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 20, added)
+	assert.True(t, bytes.Equal(headers[29].Hash(), chain.Tip().Hash()))
+
+	// a source lying about a header is caught
+	source.tip = bytes.Repeat([]byte{1}, 32)
+	source.headers[hex.EncodeToString(source.tip)] = headers[0].Bytes()
+	_, err = chain.Update(source, 100)
+	assert.Error(t, err)
+}
+
+// heightSource also serves its headers by height, as a HeaderLookup
+type heightSource struct {
+	headerSource
+	best []canarytail.BlockHeader
+}
+
+func (s *heightSource) HeaderAt(height int) (canarytail.BlockHeader, error) {
+	if height < 0 || height >= len(s.best) {
+		return canarytail.BlockHeader{}, fmt.Errorf("no block at height %d", height)
+	}
+	return s.best[height], nil
+}
+
+func TestHeaderChainUpdateCatchUp(t *testing.T) {
+	genesis := regtestGenesis(t)
+	headers := mineHeaders(t, genesis, 30, 0)
+	source := &heightSource{
+		headerSource: headerSource{headers: make(map[string][]byte), tip: headers[29].Hash()},
+		best:         append([]canarytail.BlockHeader{genesis}, headers...),
+	}
+	for _, h := range headers {
+		source.headers[hex.EncodeToString(h.Hash())] = h.Bytes()
+	}
+
+	chain, err := canarytail.ImportHeaderChain(canarytail.RegressionNetParams, 0, []canarytail.BlockHeader{genesis})
+	require.NoError(t, err)
+
+	// 30 headers behind, caught up forward by height in batches of 4
+	added, err := chain.Update(source, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 30, added)
+	assert.Equal(t, 30, chain.Height())
+	assert.True(t, bytes.Equal(headers[29].Hash(), chain.Tip().Hash()))
+
+	// the verified batches stay appended when a later one fails
+	more := mineHeaders(t, headers[29], 10, 0)
+	source.tip = more[9].Hash()
+	for _, h := range more {
+		source.headers[hex.EncodeToString(h.Hash())] = h.Bytes()
+	}
+	source.best = append(source.best, more[:6]...)
+	added, err = chain.Update(source, 4)
+	assert.Error(t, err)
+	assert.Equal(t, 4, added)
+	assert.Equal(t, 34, chain.Height())
+}
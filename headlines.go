@@ -0,0 +1,313 @@
+package canarytail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HeadlinesFreshness is the Freshness claim of canaries quoting news headlines, listed in the Headlines
+// claim, instead of a Bitcoin block
+const HeadlinesFreshness = "headlines"
+
+// maxHeadlineAge is how long before the release of a canary the headlines it quotes may be published
+const maxHeadlineAge = 24 * time.Hour
+
+// Headline is an item of an RSS or Atom feed quoted by a canary
+type Headline struct {
+	Feed      string `json:"feed"`
+	Title     string `json:"title"`
+	Link      string `json:"link"`
+	Published string `json:"published"`
+	// Hash is the hex encoded SHA-256 hash of the content of the item
+	Hash string `json:"hash"`
+}
+
+// String is the value of the headline signed as part of the Headlines claim
+func (h Headline) String() string {
+	return fmt.Sprintf("{%s %s %s %s %s}", h.Feed, h.Title, h.Link, h.Published, h.Hash)
+}
+
+// PublishedTimestamp parses the publication date of the headline
+func (h Headline) PublishedTimestamp() time.Time {
+	t, _ := time.Parse(TimestampLayout, h.Published)
+	return t
+}
+
+// FeedItem is an item of an RSS or Atom feed
+type FeedItem struct {
+	Title     string
+	Link      string
+	Published time.Time
+	Content   string
+}
+
+// Headline quotes the item of a feed
+func (item FeedItem) Headline(feed string) Headline {
+	return Headline{
+		Feed:      feed,
+		Title:     item.Title,
+		Link:      item.Link,
+		Published: item.Published.UTC().Format(TimestampLayout),
+		Hash:      hashFeedContent(item.Content),
+	}
+}
+
+func hashFeedContent(content string) string {
+	hash := sha256.Sum256([]byte(strings.TrimSpace(content)))
+	return hex.EncodeToString(hash[:])
+}
+
+type rssFeed struct {
+	Items []struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		PubDate     string `xml:"pubDate"`
+		Description string `xml:"description"`
+		Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	} `xml:"channel>item"`
+}
+
+type atomFeed struct {
+	Entries []struct {
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+		Content   string `xml:"content"`
+		Summary   string `xml:"summary"`
+	} `xml:"entry"`
+}
+
+// rssDateLayouts are the date formats found in the wild in RSS feeds
+var rssDateLayouts = []string{time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", time.RFC3339}
+
+func parseRSSDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range rssDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid feed date %q", s)
+}
+
+// ParseFeed parses the items of an RSS 2.0 or Atom feed, most recent first. Items without a date
+// are skipped.
+func ParseFeed(data []byte) ([]FeedItem, error) {
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid feed: %v", err)
+	}
+
+	var items []FeedItem
+	switch root.XMLName.Local {
+	case "rss":
+		var feed rssFeed
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return nil, fmt.Errorf("invalid RSS feed: %v", err)
+		}
+		for _, i := range feed.Items {
+			published, err := parseRSSDate(i.PubDate)
+			if err != nil {
+				continue
+			}
+			content := i.Content
+			if content == "" {
+				content = i.Description
+			}
+			items = append(items, FeedItem{Title: strings.TrimSpace(i.Title), Link: strings.TrimSpace(i.Link), Published: published, Content: content})
+		}
+	case "feed":
+		var feed atomFeed
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return nil, fmt.Errorf("invalid Atom feed: %v", err)
+		}
+		for _, e := range feed.Entries {
+			date := e.Published
+			if date == "" {
+				date = e.Updated
+			}
+			published, err := time.Parse(time.RFC3339, strings.TrimSpace(date))
+			if err != nil {
+				continue
+			}
+			link := ""
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					link = l.Href
+					break
+				}
+			}
+			content := e.Content
+			if content == "" {
+				content = e.Summary
+			}
+			items = append(items, FeedItem{Title: strings.TrimSpace(e.Title), Link: strings.TrimSpace(link), Published: published, Content: content})
+		}
+	default:
+		return nil, fmt.Errorf("unknown feed format <%s>, expected RSS or Atom", root.XMLName.Local)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Published.After(items[j].Published) })
+	return items, nil
+}
+
+// FeedArchive fetches a feed as it was around a given time
+type FeedArchive interface {
+	Name() string
+	Feed(url string, at time.Time) ([]byte, error)
+}
+
+// LiveFeed fetches the feed as it is now
+type LiveFeed struct{}
+
+// Name identifies the archive in error messages
+func (LiveFeed) Name() string {
+	return "live feed"
+}
+
+// Feed fetches the feed, whatever the time
+func (LiveFeed) Feed(url string, at time.Time) ([]byte, error) {
+	return fetchFeed(url)
+}
+
+// WaybackMachine fetches the snapshot of a feed closest to a time from the Internet Archive
+type WaybackMachine struct {
+	URL string
+}
+
+// NewWaybackMachine instantiates a WaybackMachine, using https://web.archive.org by default
+func NewWaybackMachine(url string) *WaybackMachine {
+	if url == "" {
+		url = "https://web.archive.org"
+	}
+	return &WaybackMachine{URL: strings.TrimSuffix(url, "/")}
+}
+
+// Name identifies the archive in error messages
+func (w *WaybackMachine) Name() string {
+	return w.URL
+}
+
+// Feed fetches the snapshot of the feed closest to at
+func (w *WaybackMachine) Feed(url string, at time.Time) ([]byte, error) {
+	// the id_ suffix asks for the feed as archived, without the Wayback Machine banner
+	return fetchFeed(fmt.Sprintf("%s/web/%sid_/%s", w.URL, at.UTC().Format("20060102150405"), url))
+}
+
+// DefaultFeedArchives are where headlines are looked up: in the feed itself, then in its snapshots
+var DefaultFeedArchives = []FeedArchive{LiveFeed{}, NewWaybackMachine("")}
+
+func fetchFeed(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Could not retrieve feed %v, got code %v", url, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// FetchHeadlines quotes the n most recent items of a feed
+func FetchHeadlines(feed string, n int) ([]Headline, error) {
+	data, err := fetchFeed(feed)
+	if err != nil {
+		return nil, err
+	}
+	items, err := ParseFeed(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("the feed %v has no dated items", feed)
+	}
+	if n > len(items) {
+		n = len(items)
+	}
+	headlines := make([]Headline, n)
+	for i := range headlines {
+		headlines[i] = items[i].Headline(feed)
+	}
+	return headlines, nil
+}
+
+// VerifyHeadline looks the headline up in its feed through the archives, and checks the item has the
+// same title and content. Headlines are often edited after they are quoted: an item that does not
+// match in an archive is looked up in the next ones, as is a missing item.
+func VerifyHeadline(h Headline, archives []FeedArchive) error {
+	var failures []string
+	for _, archive := range archives {
+		data, err := archive.Feed(h.Feed, h.PublishedTimestamp())
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", archive.Name(), err))
+			continue
+		}
+		items, err := ParseFeed(data)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", archive.Name(), err))
+			continue
+		}
+		failure := "not found"
+		for _, item := range items {
+			if item.Link != h.Link {
+				continue
+			}
+			if item.Title != h.Title || hashFeedContent(item.Content) != h.Hash {
+				failure = "the item does not match"
+				continue
+			}
+			if !item.Published.Equal(h.PublishedTimestamp()) {
+				failure = fmt.Sprintf("the item was published %v, not %v", item.Published, h.Published)
+				continue
+			}
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", archive.Name(), failure))
+	}
+	return fmt.Errorf("Could not find the headline %q in %v (%s)", h.Title, h.Feed, strings.Join(failures, "; "))
+}
+
+// headlinesFreshnessTime checks every headline of the canary exists in its feed and was published in the
+// day before the release, and returns when the most recent one was published
+func (c Canary) headlinesFreshnessTime(opts ValidateOptions) (time.Time, error) {
+	if len(c.Claim.Headlines) == 0 {
+		return time.Time{}, errors.New("the canary quotes no headlines")
+	}
+	if opts.Offline {
+		return time.Time{}, errors.New("headlines can only be checked online, against their feeds")
+	}
+	archives := opts.FeedArchives
+	if archives == nil {
+		archives = DefaultFeedArchives
+	}
+
+	var latest time.Time
+	for _, h := range c.Claim.Headlines {
+		published := h.PublishedTimestamp()
+		if c.ReleaseTimestamp().Sub(published) > maxHeadlineAge {
+			return time.Time{}, fmt.Errorf("the headline %q was published more than %v before the release", h.Title, maxHeadlineAge)
+		}
+		if err := VerifyHeadline(h, archives); err != nil {
+			return time.Time{}, err
+		}
+		if published.After(latest) {
+			latest = published
+		}
+	}
+	return latest, nil
+}
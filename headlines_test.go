@@ -0,0 +1,192 @@
+package canarytail_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// feedServer serves an RSS and an Atom feed, and a Wayback Machine stand-in archiving them
+type feedServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	items    []canarytail.FeedItem
+	archived []canarytail.FeedItem
+}
+
+func (f *feedServer) rss(items []canarytail.FeedItem) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?><rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/"><channel><title>News</title>`)
+	for _, i := range items {
+		fmt.Fprintf(&b, `<item><title>%s</title><link>%s</link><pubDate>%s</pubDate><content:encoded><![CDATA[%s]]></content:encoded></item>`,
+			i.Title, i.Link, i.Published.Format(time.RFC1123Z), i.Content)
+	}
+	b.WriteString(`</channel></rss>`)
+	return b.String()
+}
+
+func (f *feedServer) atom(items []canarytail.FeedItem) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?><feed xmlns="http://www.w3.org/2005/Atom"><title>News</title>`)
+	for _, i := range items {
+		fmt.Fprintf(&b, `<entry><title>%s</title><link rel="alternate" href="%s"/><published>%s</published><summary>%s</summary></entry>`,
+			i.Title, i.Link, i.Published.Format(time.RFC3339), i.Content)
+	}
+	b.WriteString(`</feed>`)
+	return b.String()
+}
+
+func newFeedServer(t *testing.T) *feedServer {
+	now := time.Now().Truncate(time.Second)
+	f := &feedServer{items: []canarytail.FeedItem{
+		{Title: "Older news", Link: "https://news.example.com/1", Published: now.Add(-3 * time.Hour), Content: "Something happened."},
+		{Title: "Latest news", Link: "https://news.example.com/2", Published: now.Add(-10 * time.Minute), Content: "Something else happened."},
+	}}
+	f.archived = f.items
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rss", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		fmt.Fprint(w, f.rss(f.items))
+	})
+	mux.HandleFunc("/atom", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		fmt.Fprint(w, f.atom(f.items))
+	})
+	mux.HandleFunc("/web/", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/atom") {
+			fmt.Fprint(w, f.atom(f.archived))
+		} else {
+			fmt.Fprint(w, f.rss(f.archived))
+		}
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestParseFeed(t *testing.T) {
+	f := newFeedServer(t)
+	for _, feed := range []string{f.rss(f.items), f.atom(f.items)} {
+		items, err := canarytail.ParseFeed([]byte(feed))
+		require.NoError(t, err)
+		require.Len(t, items, 2)
+		assert.Equal(t, "Latest news", items[0].Title)
+		assert.Equal(t, "https://news.example.com/2", items[0].Link)
+		assert.True(t, f.items[1].Published.Equal(items[0].Published))
+		assert.Equal(t, "Something else happened.", items[0].Content)
+	}
+
+	_, err := canarytail.ParseFeed([]byte(`<html></html>`))
+	assert.Error(t, err)
+}
+
+func TestHeadlinesFreshness(t *testing.T) {
+	f := newFeedServer(t)
+	rss, err := canarytail.FetchHeadlines(f.URL+"/rss", 1)
+	require.NoError(t, err)
+	atom, err := canarytail.FetchHeadlines(f.URL+"/atom", 2)
+	require.NoError(t, err)
+	require.Len(t, atom, 2)
+	headlines := append(rss, atom...)
+
+	quote := func(headlines ...canarytail.Headline) func(*canarytail.Canary) {
+		return func(c *canarytail.Canary) { c.Claim.Headlines = headlines }
+	}
+	live := canarytail.ValidateOptions{FeedArchives: []canarytail.FeedArchive{canarytail.LiveFeed{}}}
+	archived := canarytail.ValidateOptions{FeedArchives: []canarytail.FeedArchive{canarytail.LiveFeed{}, canarytail.NewWaybackMachine(f.URL)}}
+
+	c := testCanary(t, nil, withFreshness(canarytail.HeadlinesFreshness), quote(headlines...))
+	ok, err := c.ValidateWithOptions(live)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	t.Run("headlines are signed", func(t *testing.T) {
+		tampered := c
+		tampered.Claim.Headlines = headlines[:1]
+		ok, _ := tampered.ValidateWithOptions(live)
+		assert.False(t, ok)
+		tampered.Claim.Headlines = nil
+		ok, _ = tampered.ValidateWithOptions(live)
+		assert.False(t, ok)
+	})
+
+	t.Run("only in the archive", func(t *testing.T) {
+		f.mu.Lock()
+		f.items = f.items[1:]
+		f.mu.Unlock()
+		defer func() {
+			f.mu.Lock()
+			f.items = f.archived
+			f.mu.Unlock()
+		}()
+
+		ok, err := c.ValidateWithOptions(live)
+		assert.False(t, ok)
+		assert.Contains(t, err.Error(), "Older news")
+		ok, err = c.ValidateWithOptions(archived)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("edited since", func(t *testing.T) {
+		f.mu.Lock()
+		f.items = append([]canarytail.FeedItem{}, f.archived...)
+		f.items[0].Title = "Older news, updated"
+		f.mu.Unlock()
+		defer func() {
+			f.mu.Lock()
+			f.items = f.archived
+			f.mu.Unlock()
+		}()
+
+		ok, err := c.ValidateWithOptions(live)
+		assert.False(t, ok)
+		assert.Contains(t, err.Error(), "does not match")
+		ok, err = c.ValidateWithOptions(archived)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("edited content", func(t *testing.T) {
+		edited := headlines[0]
+		edited.Hash = strings.Repeat("0", 64)
+		c := testCanary(t, nil, withFreshness(canarytail.HeadlinesFreshness), quote(edited))
+		ok, err := c.ValidateWithOptions(live)
+		assert.False(t, ok)
+		assert.Contains(t, err.Error(), "does not match")
+	})
+
+	t.Run("stale headlines", func(t *testing.T) {
+		c := testCanary(t, nil, withFreshness(canarytail.HeadlinesFreshness), quote(headlines...), func(c *canarytail.Canary) {
+			c.Claim.Release = time.Now().Add(25 * time.Hour).Format(canarytail.TimestampLayout)
+			c.Claim.Expiry = time.Now().Add(27 * time.Hour).Format(canarytail.TimestampLayout)
+		})
+		later := time.Now().Add(26 * time.Hour)
+		opts := live
+		opts.Time = &canarytail.ClockReading{Earliest: later, Latest: later}
+		ok, err := c.ValidateWithOptions(opts)
+		assert.False(t, ok)
+		assert.Contains(t, err.Error(), "before the release")
+	})
+
+	t.Run("no headlines", func(t *testing.T) {
+		c := testCanary(t, nil, withFreshness(canarytail.HeadlinesFreshness))
+		ok, _ := c.ValidateWithOptions(live)
+		assert.False(t, ok)
+		assert.NotContains(t, c.Format(), "headlines\": [")
+	})
+}
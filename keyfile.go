@@ -0,0 +1,111 @@
+package canarytail
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Private keys at rest are encrypted with XChaCha20-Poly1305, under a key derived from a passphrase
+// with scrypt. The encrypted key is stored as a JSON envelope with the parameters needed to decrypt it.
+
+const (
+	keyFileVersion = 1
+	keyFileKDF     = "scrypt"
+	keyFileCipher  = "xchacha20-poly1305"
+
+	// scrypt parameters recommended for interactive logins as of 2017
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrWrongPassphrase is returned when an encrypted key cannot be decrypted with the passphrase
+var ErrWrongPassphrase = errors.New("wrong passphrase, or corrupted key file")
+
+// EncryptedKey is the envelope of an encrypted private key
+type EncryptedKey struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Cipher     string `json:"cipher"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// additionalData binds the parameters of the envelope to the ciphertext
+func (e *EncryptedKey) additionalData() []byte {
+	return []byte(fmt.Sprintf("canarytail key v%d %s %x %d %d %d %s", e.Version, e.KDF, e.Salt, e.N, e.R, e.P, e.Cipher))
+}
+
+func (e *EncryptedKey) aead(passphrase []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, e.Salt, e.N, e.R, e.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// EncryptPrivateKey encrypts a private key under a passphrase and returns the JSON envelope
+func EncryptPrivateKey(privateKey, passphrase []byte) ([]byte, error) {
+	e := &EncryptedKey{
+		Version: keyFileVersion,
+		KDF:     keyFileKDF,
+		Salt:    make([]byte, 16),
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Cipher:  keyFileCipher,
+		Nonce:   make([]byte, chacha20poly1305.NonceSizeX),
+	}
+	if _, err := rand.Read(e.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(e.Nonce); err != nil {
+		return nil, err
+	}
+	aead, err := e.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	e.Ciphertext = aead.Seal(nil, e.Nonce, privateKey, e.additionalData())
+	return json.MarshalIndent(e, "", "    ")
+}
+
+// DecryptPrivateKey decrypts a private key encrypted with EncryptPrivateKey
+func DecryptPrivateKey(data, passphrase []byte) ([]byte, error) {
+	var e EncryptedKey
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("invalid encrypted key: %v", err)
+	}
+	if e.Version != keyFileVersion || e.KDF != keyFileKDF || e.Cipher != keyFileCipher {
+		return nil, fmt.Errorf("unsupported encrypted key: version %d, %s, %s", e.Version, e.KDF, e.Cipher)
+	}
+	aead, err := e.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Nonce) != chacha20poly1305.NonceSizeX {
+		return nil, errors.New("invalid encrypted key nonce")
+	}
+	privateKey, err := aead.Open(nil, e.Nonce, e.Ciphertext, e.additionalData())
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return privateKey, nil
+}
+
+// IsEncryptedKey tells whether the content of a key file is an encrypted envelope rather than a plain
+// base64 encoded key
+func IsEncryptedKey(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
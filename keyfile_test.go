@@ -0,0 +1,63 @@
+package canarytail_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptPrivateKey(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	passphrase := []byte("correct horse battery staple")
+
+	data, err := canarytail.EncryptPrivateKey(privateKey, passphrase)
+	require.NoError(t, err)
+	assert.True(t, canarytail.IsEncryptedKey(data))
+	assert.False(t, bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(privateKey))))
+	assert.False(t, canarytail.IsEncryptedKey([]byte(base64.StdEncoding.EncodeToString(privateKey))))
+
+	decrypted, err := canarytail.DecryptPrivateKey(data, passphrase)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(privateKey), decrypted)
+
+	_, err = canarytail.DecryptPrivateKey(data, []byte("wrong"))
+	assert.Equal(t, canarytail.ErrWrongPassphrase, err)
+
+	t.Run("tampered parameters", func(t *testing.T) {
+		var e canarytail.EncryptedKey
+		require.NoError(t, json.Unmarshal(data, &e))
+		e.Salt[0] ^= 1
+		tampered, err := json.Marshal(e)
+		require.NoError(t, err)
+		_, err = canarytail.DecryptPrivateKey(tampered, passphrase)
+		assert.Equal(t, canarytail.ErrWrongPassphrase, err)
+	})
+
+	t.Run("tampered ciphertext", func(t *testing.T) {
+		var e canarytail.EncryptedKey
+		require.NoError(t, json.Unmarshal(data, &e))
+		e.Ciphertext[0] ^= 1
+		tampered, err := json.Marshal(e)
+		require.NoError(t, err)
+		_, err = canarytail.DecryptPrivateKey(tampered, passphrase)
+		assert.Equal(t, canarytail.ErrWrongPassphrase, err)
+	})
+
+	t.Run("unsupported cipher", func(t *testing.T) {
+		var e canarytail.EncryptedKey
+		require.NoError(t, json.Unmarshal(data, &e))
+		e.Cipher = "rot13"
+		other, err := json.Marshal(e)
+		require.NoError(t, err)
+		_, err = canarytail.DecryptPrivateKey(other, passphrase)
+		assert.Contains(t, err.Error(), "unsupported")
+	})
+}
@@ -0,0 +1,110 @@
+package canarytail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// KeyRole tells which of the keys of a domain is used
+type KeyRole string
+
+// The keys of a domain: canaries are signed with the signing key, and with the panic key to trip them.
+// A delegated key renews them in place of the signing key, within the scope of its Delegation.
+const (
+	SigningKeyRole  KeyRole = "signing"
+	PanicKeyRole    KeyRole = "panic"
+	DelegateKeyRole KeyRole = "delegate"
+)
+
+// KeyStore holds the keys canaries are signed with. Backends such as hardware tokens or external
+// commands sign without handing out the private key.
+type KeyStore interface {
+	// PublicKey returns the public key of a domain, without unlocking the private key
+	PublicKey(domain string, role KeyRole) (ed25519.PublicKey, error)
+	// Signer returns an Ed25519 signer for a domain, to be passed to Canary.Sign
+	Signer(domain string, role KeyRole) (crypto.Signer, error)
+}
+
+// CommandKeyStore signs canaries by running an external command:
+//
+//	COMMAND public DOMAIN ROLE     prints the base64 encoded public key
+//	COMMAND sign DOMAIN ROLE       reads the message on stdin and prints its base64 encoded signature
+type CommandKeyStore struct {
+	Command []string
+}
+
+// NewCommandKeyStore instantiates a CommandKeyStore running a command with its arguments
+func NewCommandKeyStore(command ...string) (*CommandKeyStore, error) {
+	if len(command) == 0 {
+		return nil, errors.New("no key store command given")
+	}
+	return &CommandKeyStore{Command: command}, nil
+}
+
+func (s *CommandKeyStore) run(stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command(s.Command[0], append(s.Command[1:], args...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Could not run the key store command %v %v: %v %s", s.Command[0], args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+}
+
+// PublicKey asks the command for the public key of a domain
+func (s *CommandKeyStore) PublicKey(domain string, role KeyRole) (ed25519.PublicKey, error) {
+	publicKey, err := s.run(nil, "public", domain, string(role))
+	if err != nil {
+		return nil, err
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("the key store command returned an invalid public key for %v", domain)
+	}
+	return publicKey, nil
+}
+
+// Signer returns a signer running the command for every signature
+func (s *CommandKeyStore) Signer(domain string, role KeyRole) (crypto.Signer, error) {
+	publicKey, err := s.PublicKey(domain, role)
+	if err != nil {
+		return nil, err
+	}
+	return &commandSigner{store: s, domain: domain, role: role, publicKey: publicKey}, nil
+}
+
+type commandSigner struct {
+	store     *CommandKeyStore
+	domain    string
+	role      KeyRole
+	publicKey ed25519.PublicKey
+}
+
+func (s *commandSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+func (s *commandSigner) Sign(rand io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	signature, err := s.store.run(message, "sign", s.domain, string(s.role))
+	if err != nil {
+		return nil, err
+	}
+	return verifiedSignature(s.publicKey, message, signature)
+}
+
+// verifiedSignature checks a signature made outside of the process, so that a misbehaving backend
+// cannot produce a canary that fails to validate
+func verifiedSignature(publicKey ed25519.PublicKey, message, signature []byte) ([]byte, error) {
+	if !ed25519.Verify(publicKey, message, signature) {
+		return nil, errors.New("the key store returned an invalid signature")
+	}
+	return signature, nil
+}
@@ -0,0 +1,82 @@
+package canarytail_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestKeyStoreCommandHelper is the key store command run by TestCommandKeyStore, holding the key given in
+// $CANARYTAIL_TEST_KEY for the signing role only
+func TestKeyStoreCommandHelper(t *testing.T) {
+	encoded := os.Getenv("CANARYTAIL_TEST_KEY")
+	if encoded == "" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) != 4 || args[3] != string(canarytail.SigningKeyRole) {
+		fmt.Fprintln(os.Stderr, "no such key")
+		os.Exit(1)
+	}
+	privateKey, _ := base64.StdEncoding.DecodeString(encoded)
+	switch args[1] {
+	case "public":
+		fmt.Println(canarytail.FormatKey(ed25519.PrivateKey(privateKey).Public().(ed25519.PublicKey)))
+	case "sign":
+		message, _ := ioutil.ReadAll(os.Stdin)
+		if os.Getenv("CANARYTAIL_TEST_BAD_SIGNATURE") != "" {
+			message = append(message, '!')
+		}
+		fmt.Println(canarytail.FormatKey(ed25519.Sign(privateKey, message)))
+	}
+	os.Exit(0)
+}
+
+func TestCommandKeyStore(t *testing.T) {
+	publicKey, privateKey, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+	os.Setenv("CANARYTAIL_TEST_KEY", canarytail.FormatKey(privateKey))
+	defer os.Unsetenv("CANARYTAIL_TEST_KEY")
+
+	store, err := canarytail.NewCommandKeyStore(os.Args[0], "-test.run=TestKeyStoreCommandHelper", "--")
+	require.NoError(t, err)
+	storedKey, err := store.PublicKey("test", canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, storedKey)
+
+	signer, err := store.Signer("test", canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	now := time.Now()
+	c := canarytail.Canary{
+		Version: canarytail.StandardVersion,
+		Claim: canarytail.CanaryClaim{
+			Domain:     "test",
+			MinSigners: 1,
+			PublicKeys: []canarytail.PublicKey{{Role: canarytail.RoleAuthor, Key: canarytail.FormatKey(publicKey), Required: true}},
+			Release:    now.Format(canarytail.TimestampLayout),
+			Expiry:     now.Add(time.Hour).Format(canarytail.TimestampLayout),
+			Codes:      canarytail.AllCodes(),
+		},
+	}
+	require.NoError(t, c.Sign(signer))
+	assert.True(t, c.ValidateSignatures(publicKey))
+
+	_, err = store.Signer("test", canarytail.PanicKeyRole)
+	assert.Contains(t, err.Error(), "no such key")
+
+	os.Setenv("CANARYTAIL_TEST_BAD_SIGNATURE", "1")
+	defer os.Unsetenv("CANARYTAIL_TEST_BAD_SIGNATURE")
+	assert.Error(t, c.Sign(signer))
+}
@@ -0,0 +1,69 @@
+package canarytail
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EncodeMnemonic encodes entropy of 16 to 32 bytes, in steps of 4, as BIP-39 words: the bits of the
+// entropy followed by the first bits of its SHA-256 hash as checksum, 11 bits per word
+func EncodeMnemonic(entropy []byte) ([]string, error) {
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return nil, fmt.Errorf("invalid mnemonic entropy of %d bytes", len(entropy))
+	}
+	hash := sha256.Sum256(entropy)
+	data := append(append([]byte{}, entropy...), hash[0])
+	bits := len(entropy)*8 + len(entropy)/4
+
+	words := make([]string, bits/11)
+	for i := range words {
+		index := 0
+		for b := i * 11; b < (i+1)*11; b++ {
+			index = index<<1 | int(data[b/8]>>(7-b%8)&1)
+		}
+		words[i] = bip39English[index]
+	}
+	return words, nil
+}
+
+// DecodeMnemonic decodes words encoded with EncodeMnemonic, checking their checksum. Words may be
+// abbreviated to their first 4 letters, which are unique in the list.
+func DecodeMnemonic(words []string) ([]byte, error) {
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("expected 12 to 24 words in steps of 3, got %d", len(words))
+	}
+	bits := len(words) * 11
+	data := make([]byte, (bits+7)/8)
+	for i, w := range words {
+		index, err := mnemonicWordIndex(w)
+		if err != nil {
+			return nil, fmt.Errorf("word %d: %v", i+1, err)
+		}
+		for b := 0; b < 11; b++ {
+			if index>>(10-b)&1 == 1 {
+				bit := i*11 + b
+				data[bit/8] |= 1 << (7 - bit%8)
+			}
+		}
+	}
+
+	entropy := data[:bits*32/33/8]
+	checksumBits := uint(len(entropy) / 4)
+	hash := sha256.Sum256(entropy)
+	if data[len(entropy)]>>(8-checksumBits) != hash[0]>>(8-checksumBits) {
+		return nil, errors.New("the checksum of the words does not match, check they were copied correctly")
+	}
+	return entropy, nil
+}
+
+func mnemonicWordIndex(word string) (int, error) {
+	word = strings.ToLower(strings.TrimSpace(word))
+	for i, w := range bip39English {
+		if w == word || (len(word) >= 4 && strings.HasPrefix(w, word)) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not in the word list", word)
+}
@@ -0,0 +1,47 @@
+package canarytail_test
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMnemonic(t *testing.T) {
+	// test vectors of BIP-39
+	vectors := []struct {
+		entropy string
+		words   string
+	}{
+		{"00000000000000000000000000000000", "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"},
+		{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f", "legal winner thank year wave sausage worth useful legal winner thank yellow"},
+		{"8080808080808080808080808080808080808080808080808080808080808080", "letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic bless"},
+		{"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote"},
+	}
+	for _, v := range vectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		words, err := canarytail.EncodeMnemonic(entropy)
+		require.NoError(t, err)
+		assert.Equal(t, v.words, strings.Join(words, " "))
+
+		decoded, err := canarytail.DecodeMnemonic(strings.Fields(v.words))
+		require.NoError(t, err)
+		assert.Equal(t, entropy, decoded)
+	}
+
+	// words can be abbreviated to 4 letters
+	decoded, err := canarytail.DecodeMnemonic(strings.Fields("lega winn than year wave saus wort usef lega winn than yell"))
+	require.NoError(t, err)
+	assert.Equal(t, "7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f", hex.EncodeToString(decoded))
+
+	_, err = canarytail.DecodeMnemonic(strings.Fields("legal winner thank year wave sausage worth useful legal winner thank zoo"))
+	assert.Contains(t, err.Error(), "checksum")
+	_, err = canarytail.DecodeMnemonic(strings.Fields("legal winner thank year wave sausage worth useful legal winner thank canary"))
+	assert.Contains(t, err.Error(), "word 12")
+	_, err = canarytail.EncodeMnemonic(make([]byte, 33))
+	assert.Error(t, err)
+}
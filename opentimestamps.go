@@ -0,0 +1,572 @@
+package canarytail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ripemd160"
+	"golang.org/x/crypto/sha3"
+)
+
+// OpenTimestamps proofs, see https://github.com/opentimestamps/python-opentimestamps
+//
+// A proof is a tree of operations starting from the digest being timestamped. Every path of the tree
+// ends in an attestation: either a pending one, naming the calendar server to ask for an upgrade, or a
+// Bitcoin one, stating that the message computed along the path is the merkle root of a block.
+
+// DefaultOpenTimestampsCalendars are the public calendar servers used to stamp canaries
+var DefaultOpenTimestampsCalendars = []string{
+	"https://alice.btc.calendar.opentimestamps.org",
+	"https://bob.btc.calendar.opentimestamps.org",
+	"https://finney.calendar.eternitywall.com",
+}
+
+// otsHeaderMagic starts every detached timestamp file
+var otsHeaderMagic = []byte("\x00OpenTimestamps\x00\x00Proof\x00\xbf\x89\xe2\xe8\x84\xe8\x92\x94")
+
+const (
+	otsMajorVersion = 1
+
+	otsTagAttestation = 0x00
+	otsTagFork        = 0xff
+
+	otsOpSHA1      = 0x02
+	otsOpRIPEMD160 = 0x03
+	otsOpSHA256    = 0x08
+	otsOpKeccak256 = 0x67
+	otsOpAppend    = 0xf0
+	otsOpPrepend   = 0xf1
+	otsOpReverse   = 0xf2
+	otsOpHexlify   = 0xf3
+
+	otsMaxMsgLength     = 4096
+	otsMaxPayloadLength = 8192
+	otsMaxDepth         = 256
+)
+
+var (
+	otsBitcoinAttestationTag = [8]byte{0x05, 0x88, 0x96, 0x0d, 0x73, 0xd7, 0x19, 0x01}
+	otsPendingAttestationTag = [8]byte{0x83, 0xdf, 0xe3, 0x0d, 0x2e, 0xf9, 0x0c, 0x8e}
+)
+
+// ErrTimestampPending is returned when verifying a proof that has no Bitcoin attestation yet
+var ErrTimestampPending = errors.New("the timestamp is still pending, upgrade it once its calendars have committed it to Bitcoin")
+
+// OTSOp is an operation of an OpenTimestamps proof
+type OTSOp struct {
+	Tag byte
+	// Arg is the argument of the append and prepend operations
+	Arg []byte
+}
+
+// Apply computes the result of the operation on msg
+func (op OTSOp) Apply(msg []byte) ([]byte, error) {
+	var result []byte
+	switch op.Tag {
+	case otsOpSHA1:
+		sum := sha1.Sum(msg)
+		result = sum[:]
+	case otsOpRIPEMD160:
+		h := ripemd160.New()
+		h.Write(msg)
+		result = h.Sum(nil)
+	case otsOpSHA256:
+		sum := sha256.Sum256(msg)
+		result = sum[:]
+	case otsOpKeccak256:
+		h := sha3.NewLegacyKeccak256()
+		h.Write(msg)
+		result = h.Sum(nil)
+	case otsOpAppend:
+		result = append(append([]byte{}, msg...), op.Arg...)
+	case otsOpPrepend:
+		result = append(append([]byte{}, op.Arg...), msg...)
+	case otsOpReverse:
+		result = reverseBytes(msg)
+	case otsOpHexlify:
+		result = []byte(hex.EncodeToString(msg))
+	default:
+		return nil, fmt.Errorf("unknown OpenTimestamps operation 0x%02x", op.Tag)
+	}
+	if len(result) > otsMaxMsgLength {
+		return nil, fmt.Errorf("OpenTimestamps operation 0x%02x produced a message over %d bytes", op.Tag, otsMaxMsgLength)
+	}
+	return result, nil
+}
+
+func (op OTSOp) hasArg() bool {
+	return op.Tag == otsOpAppend || op.Tag == otsOpPrepend
+}
+
+func (op OTSOp) equal(other OTSOp) bool {
+	return op.Tag == other.Tag && bytes.Equal(op.Arg, other.Arg)
+}
+
+// OTSAttestation attests that the message of the timestamp it belongs to existed at some point
+type OTSAttestation struct {
+	Tag     [8]byte
+	Payload []byte
+}
+
+// BitcoinHeight returns the block height of a Bitcoin attestation
+func (a OTSAttestation) BitcoinHeight() (int, bool) {
+	if a.Tag != otsBitcoinAttestationTag {
+		return 0, false
+	}
+	height, err := readVarUint(bytes.NewReader(a.Payload))
+	if err != nil {
+		return 0, false
+	}
+	return int(height), true
+}
+
+// PendingURI returns the calendar URI of a pending attestation
+func (a OTSAttestation) PendingURI() (string, bool) {
+	if a.Tag != otsPendingAttestationTag {
+		return "", false
+	}
+	uri, err := readVarBytes(bytes.NewReader(a.Payload), otsMaxPayloadLength)
+	if err != nil {
+		return "", false
+	}
+	return string(uri), true
+}
+
+func (a OTSAttestation) equal(other OTSAttestation) bool {
+	return a.Tag == other.Tag && bytes.Equal(a.Payload, other.Payload)
+}
+
+// OTSBranch is an operation of a timestamp and the timestamp of its result
+type OTSBranch struct {
+	Op        OTSOp
+	Timestamp *OTSTimestamp
+}
+
+// OTSTimestamp is a node of an OpenTimestamps proof: a message, its attestations and the operations
+// applied to it
+type OTSTimestamp struct {
+	Msg          []byte
+	Attestations []OTSAttestation
+	Branches     []OTSBranch
+}
+
+// add applies op to the message of the timestamp and returns the timestamp of the result, reusing an
+// existing branch with the same operation
+func (t *OTSTimestamp) add(op OTSOp) (*OTSTimestamp, error) {
+	for _, b := range t.Branches {
+		if b.Op.equal(op) {
+			return b.Timestamp, nil
+		}
+	}
+	msg, err := op.Apply(t.Msg)
+	if err != nil {
+		return nil, err
+	}
+	child := &OTSTimestamp{Msg: msg}
+	t.Branches = append(t.Branches, OTSBranch{Op: op, Timestamp: child})
+	return child, nil
+}
+
+// merge adds the attestations and branches of other, a timestamp of the same message
+func (t *OTSTimestamp) merge(other *OTSTimestamp) {
+	for _, a := range other.Attestations {
+		found := false
+		for _, existing := range t.Attestations {
+			found = found || existing.equal(a)
+		}
+		if !found {
+			t.Attestations = append(t.Attestations, a)
+		}
+	}
+	for _, b := range other.Branches {
+		merged := false
+		for _, existing := range t.Branches {
+			if existing.Op.equal(b.Op) {
+				existing.Timestamp.merge(b.Timestamp)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			t.Branches = append(t.Branches, b)
+		}
+	}
+}
+
+// walk calls fn for the timestamp and every timestamp below it
+func (t *OTSTimestamp) walk(fn func(*OTSTimestamp) error) error {
+	if err := fn(t); err != nil {
+		return err
+	}
+	for _, b := range t.Branches {
+		if err := b.Timestamp.walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsComplete checks whether the timestamp has at least one Bitcoin attestation
+func (t *OTSTimestamp) IsComplete() bool {
+	complete := false
+	t.walk(func(node *OTSTimestamp) error {
+		for _, a := range node.Attestations {
+			if _, ok := a.BitcoinHeight(); ok {
+				complete = true
+			}
+		}
+		return nil
+	})
+	return complete
+}
+
+func (t *OTSTimestamp) serialize(w *bytes.Buffer) {
+	items := len(t.Attestations) + len(t.Branches)
+	n := 0
+	for _, a := range t.Attestations {
+		if n++; n < items {
+			w.WriteByte(otsTagFork)
+		}
+		w.WriteByte(otsTagAttestation)
+		w.Write(a.Tag[:])
+		writeVarBytes(w, a.Payload)
+	}
+	for _, b := range t.Branches {
+		if n++; n < items {
+			w.WriteByte(otsTagFork)
+		}
+		w.WriteByte(b.Op.Tag)
+		if b.Op.hasArg() {
+			writeVarBytes(w, b.Op.Arg)
+		}
+		b.Timestamp.serialize(w)
+	}
+}
+
+// parseOTSTimestamp parses a serialized timestamp of msg
+func parseOTSTimestamp(r io.ByteReader, msg []byte, depth int) (*OTSTimestamp, error) {
+	if depth > otsMaxDepth {
+		return nil, errors.New("the OpenTimestamps proof is nested too deeply")
+	}
+	t := &OTSTimestamp{Msg: msg}
+
+	parseItem := func(tag byte) error {
+		if tag == otsTagAttestation {
+			var a OTSAttestation
+			for i := range a.Tag {
+				b, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				a.Tag[i] = b
+			}
+			payload, err := readVarBytes(r, otsMaxPayloadLength)
+			if err != nil {
+				return err
+			}
+			a.Payload = payload
+			t.Attestations = append(t.Attestations, a)
+			return nil
+		}
+
+		op := OTSOp{Tag: tag}
+		if op.hasArg() {
+			arg, err := readVarBytes(r, otsMaxMsgLength)
+			if err != nil {
+				return err
+			}
+			op.Arg = arg
+		}
+		result, err := op.Apply(msg)
+		if err != nil {
+			return err
+		}
+		child, err := parseOTSTimestamp(r, result, depth+1)
+		if err != nil {
+			return err
+		}
+		t.Branches = append(t.Branches, OTSBranch{Op: op, Timestamp: child})
+		return nil
+	}
+
+	for {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if tag != otsTagFork {
+			return t, parseItem(tag)
+		}
+		if tag, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		if err := parseItem(tag); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// OpenTimestampsProof is a detached OpenTimestamps proof of a SHA-256 digest
+type OpenTimestampsProof struct {
+	Timestamp *OTSTimestamp
+}
+
+// Digest returns the digest the proof timestamps
+func (p *OpenTimestampsProof) Digest() []byte {
+	return p.Timestamp.Msg
+}
+
+// ParseOpenTimestampsProof parses a detached timestamp file (.ots)
+func ParseOpenTimestampsProof(data []byte) (*OpenTimestampsProof, error) {
+	if !bytes.HasPrefix(data, otsHeaderMagic) {
+		return nil, errors.New("not an OpenTimestamps proof")
+	}
+	r := bytes.NewReader(data[len(otsHeaderMagic):])
+	version, err := readVarUint(r)
+	if err != nil {
+		return nil, err
+	}
+	if version != otsMajorVersion {
+		return nil, fmt.Errorf("unsupported OpenTimestamps proof version %d", version)
+	}
+	if op, err := r.ReadByte(); err != nil || op != otsOpSHA256 {
+		return nil, errors.New("only OpenTimestamps proofs of SHA-256 digests are supported")
+	}
+	digest := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, digest); err != nil {
+		return nil, err
+	}
+	timestamp, err := parseOTSTimestamp(r, digest, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenTimestamps proof: %v", err)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("invalid OpenTimestamps proof: trailing data")
+	}
+	return &OpenTimestampsProof{Timestamp: timestamp}, nil
+}
+
+// Bytes serializes the proof as a detached timestamp file (.ots)
+func (p *OpenTimestampsProof) Bytes() []byte {
+	var w bytes.Buffer
+	w.Write(otsHeaderMagic)
+	writeVarUint(&w, otsMajorVersion)
+	w.WriteByte(otsOpSHA256)
+	w.Write(p.Timestamp.Msg)
+	p.Timestamp.serialize(&w)
+	return w.Bytes()
+}
+
+// HeaderLookup retrieves block headers of the best chain by height
+type HeaderLookup interface {
+	HeaderAt(height int) (BlockHeader, error)
+}
+
+// Verify checks every Bitcoin attestation of the proof against the block headers, and returns the
+// time of the earliest attesting block: the digest existed before that time.
+// It fails with ErrTimestampPending if there is no Bitcoin attestation yet.
+func (p *OpenTimestampsProof) Verify(headers HeaderLookup) (time.Time, error) {
+	var attested time.Time
+	err := p.Timestamp.walk(func(node *OTSTimestamp) error {
+		for _, a := range node.Attestations {
+			height, ok := a.BitcoinHeight()
+			if !ok {
+				continue
+			}
+			header, err := headers.HeaderAt(height)
+			if err != nil {
+				return fmt.Errorf("could not get the block at height %d: %v", height, err)
+			}
+			if !bytes.Equal(node.Msg, header.MerkleRoot[:]) {
+				return fmt.Errorf("the OpenTimestamps proof does not match the merkle root of the block at height %d", height)
+			}
+			if attested.IsZero() || header.Timestamp().Before(attested) {
+				attested = header.Timestamp()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if attested.IsZero() {
+		return time.Time{}, ErrTimestampPending
+	}
+	return attested, nil
+}
+
+// StampOpenTimestamps submits a digest to the calendar servers and returns the resulting pending proof.
+// A random nonce is appended to the digest first, so calendars do not learn it.
+func StampOpenTimestamps(digest []byte, calendars []string) (*OpenTimestampsProof, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	root := &OTSTimestamp{Msg: digest}
+	nonced, err := root.add(OTSOp{Tag: otsOpAppend, Arg: nonce})
+	if err != nil {
+		return nil, err
+	}
+	commitment, err := nonced.add(OTSOp{Tag: otsOpSHA256})
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]string, 0)
+	for _, calendar := range calendars {
+		calendar = strings.TrimSuffix(calendar, "/")
+		resp, err := http.Post(calendar+"/digest", "application/octet-stream", bytes.NewReader(commitment.Msg))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		stamp, err := readCalendarTimestamp(resp, commitment.Msg)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", calendar, err))
+			continue
+		}
+		commitment.merge(stamp)
+	}
+	if len(commitment.Attestations) == 0 && len(commitment.Branches) == 0 {
+		return nil, fmt.Errorf("no calendar could timestamp the digest: %v", errs)
+	}
+	return &OpenTimestampsProof{Timestamp: root}, nil
+}
+
+// Upgrade asks the calendars of the pending attestations for their Bitcoin attestations. Pending
+// attestations that could be upgraded are dropped. It returns whether the proof changed.
+func (p *OpenTimestampsProof) Upgrade() (bool, error) {
+	changed := false
+	err := p.Timestamp.walk(func(node *OTSTimestamp) error {
+		pending := make([]OTSAttestation, 0, len(node.Attestations))
+		upgrades := make([]*OTSTimestamp, 0)
+		for _, a := range node.Attestations {
+			uri, ok := a.PendingURI()
+			if !ok {
+				pending = append(pending, a)
+				continue
+			}
+			resp, err := http.Get(fmt.Sprintf("%s/timestamp/%s", strings.TrimSuffix(uri, "/"), hex.EncodeToString(node.Msg)))
+			if err != nil {
+				return err
+			}
+			if resp.StatusCode == http.StatusNotFound {
+				// not committed yet
+				resp.Body.Close()
+				pending = append(pending, a)
+				continue
+			}
+			upgraded, err := readCalendarTimestamp(resp, node.Msg)
+			if err != nil {
+				return fmt.Errorf("%s: %v", uri, err)
+			}
+			if !upgraded.IsComplete() {
+				pending = append(pending, a)
+			}
+			upgrades = append(upgrades, upgraded)
+		}
+		node.Attestations = pending
+		for _, upgraded := range upgrades {
+			node.merge(upgraded)
+			changed = true
+		}
+		return nil
+	})
+	return changed, err
+}
+
+func readCalendarTimestamp(resp *http.Response, msg []byte) (*OTSTimestamp, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got code %v", resp.StatusCode)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, 10000))
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(content)
+	stamp, err := parseOTSTimestamp(r, msg, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %v", err)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("invalid timestamp: trailing data")
+	}
+	return stamp, nil
+}
+
+// NewPendingAttestation returns the attestation a calendar server gives to a digest it will commit later
+func NewPendingAttestation(uri string) OTSAttestation {
+	var payload bytes.Buffer
+	writeVarBytes(&payload, []byte(uri))
+	return OTSAttestation{Tag: otsPendingAttestationTag, Payload: payload.Bytes()}
+}
+
+// NewBitcoinAttestation returns the attestation that a message is the merkle root of the block at height
+func NewBitcoinAttestation(height int) OTSAttestation {
+	var payload bytes.Buffer
+	writeVarUint(&payload, uint64(height))
+	return OTSAttestation{Tag: otsBitcoinAttestationTag, Payload: payload.Bytes()}
+}
+
+// SerializeOTSTimestamp serializes a timestamp without the detached file header, as calendar servers do
+func SerializeOTSTimestamp(t *OTSTimestamp) []byte {
+	var w bytes.Buffer
+	t.serialize(&w)
+	return w.Bytes()
+}
+
+func writeVarUint(w *bytes.Buffer, v uint64) {
+	for v >= 0x80 {
+		w.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	w.WriteByte(byte(v))
+}
+
+func writeVarBytes(w *bytes.Buffer, b []byte) {
+	writeVarUint(w, uint64(len(b)))
+	w.Write(b)
+}
+
+func readVarUint(r io.ByteReader) (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("varuint overflow")
+}
+
+func readVarBytes(r io.ByteReader, maxLength int) ([]byte, error) {
+	length, err := readVarUint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(maxLength) {
+		return nil, fmt.Errorf("varbytes of %d bytes is over the %d bytes limit", length, maxLength)
+	}
+	b := make([]byte, length)
+	for i := range b {
+		if b[i], err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,184 @@
+package canarytail_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// calendarServer is a stand-in OpenTimestamps calendar. Once committed, the digests it received end up
+// in the merkle root of a block at height 2.
+type calendarServer struct {
+	*httptest.Server
+	mu         sync.Mutex
+	pending    map[string]bool
+	committed  bool
+	merkleRoot []byte
+}
+
+func newCalendarServer(t *testing.T) *calendarServer {
+	cal := &calendarServer{pending: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/digest", func(w http.ResponseWriter, r *http.Request) {
+		digest, _ := ioutil.ReadAll(r.Body)
+		stamp := &canarytail.OTSTimestamp{Msg: digest}
+		prepended := &canarytail.OTSTimestamp{Msg: append([]byte("calendar"), digest...)}
+		sum := sha256.Sum256(prepended.Msg)
+		hashed := &canarytail.OTSTimestamp{Msg: sum[:], Attestations: []canarytail.OTSAttestation{canarytail.NewPendingAttestation(cal.URL)}}
+		prepended.Branches = []canarytail.OTSBranch{{Op: canarytail.OTSOp{Tag: 0x08}, Timestamp: hashed}}
+		stamp.Branches = []canarytail.OTSBranch{{Op: canarytail.OTSOp{Tag: 0xf1, Arg: []byte("calendar")}, Timestamp: prepended}}
+
+		cal.mu.Lock()
+		cal.pending[hex.EncodeToString(hashed.Msg)] = true
+		cal.mu.Unlock()
+		w.Write(canarytail.SerializeOTSTimestamp(stamp))
+	})
+	mux.HandleFunc("/timestamp/", func(w http.ResponseWriter, r *http.Request) {
+		commitment := strings.TrimPrefix(r.URL.Path, "/timestamp/")
+		cal.mu.Lock()
+		defer cal.mu.Unlock()
+		if !cal.pending[commitment] || !cal.committed {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		msg, _ := hex.DecodeString(commitment)
+		stamp := &canarytail.OTSTimestamp{Msg: msg}
+		appended := append(append([]byte{}, msg...), []byte("block")...)
+		sum := sha256.Sum256(appended)
+		cal.merkleRoot = sum[:]
+		stamp.Branches = []canarytail.OTSBranch{{
+			Op: canarytail.OTSOp{Tag: 0xf0, Arg: []byte("block")},
+			Timestamp: &canarytail.OTSTimestamp{Msg: appended, Branches: []canarytail.OTSBranch{{
+				Op:        canarytail.OTSOp{Tag: 0x08},
+				Timestamp: &canarytail.OTSTimestamp{Msg: sum[:], Attestations: []canarytail.OTSAttestation{canarytail.NewBitcoinAttestation(2)}},
+			}}},
+		}}
+		w.Write(canarytail.SerializeOTSTimestamp(stamp))
+	})
+	cal.Server = httptest.NewServer(mux)
+	t.Cleanup(cal.Close)
+	return cal
+}
+
+func TestOpenTimestamps(t *testing.T) {
+	genesis := regtestGenesis(t)
+	block := mineHeader(t, genesis, uint32(time.Now().Add(-10*time.Minute).Unix()), 0)
+	c := testCanary(t, nil, withFreshness(hex.EncodeToString(block.Hash())))
+	c.FreshnessProof = &canarytail.FreshnessProof{Header: hex.EncodeToString(block.Bytes())}
+
+	cal1, cal2 := newCalendarServer(t), newCalendarServer(t)
+	proof, err := canarytail.StampOpenTimestamps(c.Digest(), []string{cal1.URL, cal2.URL, "http://127.0.0.1:1"})
+	require.NoError(t, err)
+	c.SetOpenTimestampsProof(proof)
+
+	parsed, err := c.OpenTimestampsProof()
+	require.NoError(t, err)
+	assert.Equal(t, proof.Bytes(), parsed.Bytes())
+	assert.False(t, parsed.Timestamp.IsComplete())
+
+	// nothing to upgrade until the calendar commits
+	changed, err := parsed.Upgrade()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	cal1.mu.Lock()
+	cal1.committed = true
+	cal1.mu.Unlock()
+	changed, err = parsed.Upgrade()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, parsed.Timestamp.IsComplete())
+	c.SetOpenTimestampsProof(parsed)
+
+	// the calendar commits the digest in the block following the freshness block
+	attesting := mineHeader(t, block, uint32(time.Now().Unix()), 0)
+	copy(attesting.MerkleRoot[:], cal1.merkleRoot)
+	for attesting.CheckProofOfWork(canarytail.RegressionNetParams.PowLimit) != nil {
+		attesting.Nonce++
+	}
+	chain, err := canarytail.ImportHeaderChain(canarytail.RegressionNetParams, 0, []canarytail.BlockHeader{genesis, block, attesting})
+	require.NoError(t, err)
+
+	attested, err := c.VerifyOpenTimestamps(chain)
+	require.NoError(t, err)
+	assert.Equal(t, attesting.Timestamp(), attested)
+
+	opts := canarytail.ValidateOptions{Offline: true, Params: canarytail.RegressionNetParams, Headers: chain}
+	ok, err := c.ValidateWithOptions(opts)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	t.Run("attestation of another block", func(t *testing.T) {
+		other, err := canarytail.ImportHeaderChain(canarytail.RegressionNetParams, 0, []canarytail.BlockHeader{genesis, block, mineHeader(t, block, uint32(time.Now().Unix()), 1)})
+		require.NoError(t, err)
+		ok, err := c.ValidateWithOptions(canarytail.ValidateOptions{Offline: true, Params: canarytail.RegressionNetParams, Headers: other})
+		assert.False(t, ok)
+		assert.Contains(t, err.Error(), "merkle root")
+	})
+
+	t.Run("proof of another canary", func(t *testing.T) {
+		tampered := c
+		tampered.Claim.Mirrors = []string{"https://example.com"}
+		_, err := tampered.OpenTimestampsProof()
+		assert.Error(t, err)
+	})
+
+	t.Run("without headers", func(t *testing.T) {
+		opts := canarytail.ValidateOptions{Offline: true, Params: canarytail.RegressionNetParams}
+		ok, err := c.ValidateWithOptions(opts)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		// the proof must still be a proof of the canary
+		other, err := canarytail.StampOpenTimestamps(make([]byte, 32), []string{cal2.URL})
+		require.NoError(t, err)
+		forged := c
+		forged.SetOpenTimestampsProof(other)
+		ok, err = forged.ValidateWithOptions(opts)
+		assert.False(t, ok)
+		assert.Contains(t, err.Error(), "OpenTimestamps")
+	})
+
+	t.Run("pending proofs are accepted", func(t *testing.T) {
+		pending := c
+		pending.SetOpenTimestampsProof(proof)
+		_, err := pending.VerifyOpenTimestamps(chain)
+		assert.Equal(t, canarytail.ErrTimestampPending, err)
+		ok, err := pending.ValidateWithOptions(opts)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
+
+func TestParseOpenTimestampsProof(t *testing.T) {
+	_, err := canarytail.ParseOpenTimestampsProof([]byte("not a proof"))
+	assert.Error(t, err)
+
+	digest := sha256.Sum256([]byte("canary"))
+	proof := &canarytail.OpenTimestampsProof{Timestamp: &canarytail.OTSTimestamp{
+		Msg:          digest[:],
+		Attestations: []canarytail.OTSAttestation{canarytail.NewPendingAttestation("https://calendar.example.com")},
+	}}
+	data := proof.Bytes()
+	parsed, err := canarytail.ParseOpenTimestampsProof(data)
+	require.NoError(t, err)
+	uri, ok := parsed.Timestamp.Attestations[0].PendingURI()
+	assert.True(t, ok)
+	assert.Equal(t, "https://calendar.example.com", uri)
+
+	_, err = canarytail.ParseOpenTimestampsProof(data[:len(data)-1])
+	assert.Error(t, err, "truncated proof")
+	_, err = canarytail.ParseOpenTimestampsProof(append(data, 0))
+	assert.Error(t, err, "trailing data")
+}
@@ -42,23 +42,52 @@ func readFile(path string) (canary Canary, err error) {
 }
 
 func readHTTP(url string) (canary Canary, err error) {
-	resp, err := http.Get(url)
+	contents, err := fetchHTTP(url, "canary")
 	if err != nil {
 		return
 	}
+
+	return readBytes(contents)
+}
+
+func fetchHTTP(url, what string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("Could not retrieve canary, got code %v", resp.StatusCode)
-		return
+		return nil, fmt.Errorf("Could not retrieve %v, got code %v", what, resp.StatusCode)
 	}
+	return ioutil.ReadAll(resp.Body)
+}
 
-	contents, err := ioutil.ReadAll(resp.Body)
+// ReadRevocations parses a list of revocations, or a single one, as published alongside a canary, from
+// a URL or a local path
+func ReadRevocations(url string) ([]Revocation, error) {
+	var contents []byte
+	var err error
+	if isHTTP(url) {
+		contents, err = fetchHTTP(url, "revocations")
+	} else {
+		contents, err = ioutil.ReadFile(url)
+	}
 	if err != nil {
-		return
+		return nil, err
 	}
-
-	return readBytes(contents)
+	// a single revocation is accepted too
+	var revocations []Revocation
+	if trimmed := strings.TrimSpace(string(contents)); strings.HasPrefix(trimmed, "{") {
+		revocations = make([]Revocation, 1)
+		err = json.Unmarshal(contents, &revocations[0])
+	} else {
+		err = json.Unmarshal(contents, &revocations)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid revocations in %v: %v", url, err)
+	}
+	return revocations, nil
 }
 
 func readBytes(contents []byte) (canary Canary, err error) {
@@ -0,0 +1,163 @@
+//go:build pkcs11
+// +build pkcs11
+
+package canarytail
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS#11 v3.0 Edwards curve constants, missing from the pkcs11 package
+const (
+	ckkECEdwards = 0x40
+	ckmEdDSA     = 0x1057
+)
+
+// PKCS11KeyStore signs canaries with Ed25519 keys held by a PKCS#11 token, such as an HSM or SoftHSM.
+// The keys of a domain are looked up by their label, DOMAIN/ROLE (e.g. example.com/signing).
+type PKCS11KeyStore struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	// a session can only run one operation at a time
+	mu sync.Mutex
+}
+
+// NewPKCS11KeyStore loads the PKCS#11 module and logs in the token with the given label
+func NewPKCS11KeyStore(module, tokenLabel, pin string) (KeyStore, error) {
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return nil, fmt.Errorf("Could not load the PKCS#11 module %v", module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("Could not initialize the PKCS#11 module %v: %v", module, err)
+	}
+	s := &PKCS11KeyStore{ctx: ctx}
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || strings.TrimSpace(info.Label) != tokenLabel {
+			continue
+		}
+		if s.session, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION); err != nil {
+			s.Close()
+			return nil, err
+		}
+		if err := ctx.Login(s.session, pkcs11.CKU_USER, pin); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("Could not log in the PKCS#11 token %v: %v", tokenLabel, err)
+		}
+		return s, nil
+	}
+	s.Close()
+	return nil, fmt.Errorf("no PKCS#11 token labelled %q", tokenLabel)
+}
+
+// Close logs out and unloads the module
+func (s *PKCS11KeyStore) Close() error {
+	if s.session != 0 {
+		s.ctx.Logout(s.session)
+		s.ctx.CloseSession(s.session)
+	}
+	s.ctx.Finalize()
+	s.ctx.Destroy()
+	return nil
+}
+
+// findKey finds the object of a class labelled DOMAIN/ROLE
+func (s *PKCS11KeyStore) findKey(class uint, domain string, role KeyRole) (pkcs11.ObjectHandle, error) {
+	label := domain + "/" + string(role)
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, err
+	}
+	objects, _, err := s.ctx.FindObjects(s.session, 1)
+	s.ctx.FindObjectsFinal(s.session)
+	if err != nil {
+		return 0, err
+	}
+	if len(objects) == 0 {
+		return 0, fmt.Errorf("no Ed25519 key labelled %q in the PKCS#11 token", label)
+	}
+	return objects[0], nil
+}
+
+// PublicKey reads the public key of a domain from the token
+func (s *PKCS11KeyStore) PublicKey(domain string, role KeyRole) (ed25519.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, err := s.findKey(pkcs11.CKO_PUBLIC_KEY, domain, role)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := s.ctx.GetAttributeValue(s.session, object, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+	if err != nil {
+		return nil, err
+	}
+	point := attrs[0].Value
+	// the point is DER encoded as an OCTET STRING by most tokens, and raw by some
+	if len(point) != ed25519.PublicKeySize {
+		var raw []byte
+		if _, err := asn1.Unmarshal(point, &raw); err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key in the PKCS#11 token")
+		}
+		point = raw
+	}
+	return point, nil
+}
+
+// Signer returns a signer using the private key of a domain, which never leaves the token
+func (s *PKCS11KeyStore) Signer(domain string, role KeyRole) (crypto.Signer, error) {
+	publicKey, err := s.PublicKey(domain, role)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, err := s.findKey(pkcs11.CKO_PRIVATE_KEY, domain, role)
+	if err != nil {
+		return nil, err
+	}
+	return &pkcs11Signer{store: s, object: object, publicKey: publicKey}, nil
+}
+
+type pkcs11Signer struct {
+	store     *PKCS11KeyStore
+	object    pkcs11.ObjectHandle
+	publicKey ed25519.PublicKey
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+func (s *pkcs11Signer) Sign(rand io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	if err := s.store.ctx.SignInit(s.store.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(ckmEdDSA, nil)}, s.object); err != nil {
+		return nil, err
+	}
+	signature, err := s.store.ctx.Sign(s.store.session, message)
+	if err != nil {
+		return nil, err
+	}
+	return verifiedSignature(s.publicKey, message, signature)
+}
@@ -0,0 +1,11 @@
+//go:build !pkcs11
+// +build !pkcs11
+
+package canarytail
+
+import "errors"
+
+// NewPKCS11KeyStore is only available when built with the pkcs11 tag, as the PKCS#11 bindings need cgo
+func NewPKCS11KeyStore(module, tokenLabel, pin string) (KeyStore, error) {
+	return nil, errors.New("PKCS#11 support is not built in, rebuild with -tags pkcs11")
+}
@@ -0,0 +1,106 @@
+//go:build pkcs11
+// +build pkcs11
+
+package canarytail_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+	"github.com/miekg/pkcs11"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPKCS11KeyStore runs against an initialized token, e.g. with SoftHSM 2.5 or later:
+//
+//	softhsm2-util --init-token --free --label canarytail --pin 1234 --so-pin 1234
+//	PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so PKCS11_TOKEN=canarytail PKCS11_PIN=1234 go test -tags pkcs11
+func TestPKCS11KeyStore(t *testing.T) {
+	module, token, pin := os.Getenv("PKCS11_MODULE"), os.Getenv("PKCS11_TOKEN"), os.Getenv("PKCS11_PIN")
+	if module == "" || token == "" {
+		t.Skip("PKCS11_MODULE and PKCS11_TOKEN are not set")
+	}
+	generatePKCS11Key(t, module, token, pin, "test/"+string(canarytail.SigningKeyRole))
+
+	store, err := canarytail.NewPKCS11KeyStore(module, token, pin)
+	require.NoError(t, err)
+	defer store.(interface{ Close() error }).Close()
+
+	publicKey, err := store.PublicKey("test", canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	signer, err := store.Signer("test", canarytail.SigningKeyRole)
+	require.NoError(t, err)
+
+	now := time.Now()
+	c := canarytail.Canary{
+		Version: canarytail.StandardVersion,
+		Claim: canarytail.CanaryClaim{
+			Domain:     "test",
+			MinSigners: 1,
+			PublicKeys: []canarytail.PublicKey{{Role: canarytail.RoleAuthor, Key: canarytail.FormatKey(publicKey), Required: true}},
+			Release:    now.Format(canarytail.TimestampLayout),
+			Expiry:     now.Add(time.Hour).Format(canarytail.TimestampLayout),
+			Codes:      canarytail.AllCodes(),
+		},
+	}
+	require.NoError(t, c.Sign(signer))
+	assert.True(t, c.ValidateSignatures(publicKey))
+
+	_, err = store.Signer("test", canarytail.PanicKeyRole)
+	assert.Error(t, err)
+}
+
+// generatePKCS11Key generates an Ed25519 key pair in the token, replacing any key with the same label
+func generatePKCS11Key(t *testing.T, module, token, pin, label string) {
+	ctx := pkcs11.New(module)
+	require.NotNil(t, ctx)
+	require.NoError(t, ctx.Initialize())
+	defer ctx.Destroy()
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(true)
+	require.NoError(t, err)
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || strings.TrimSpace(info.Label) != token {
+			continue
+		}
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		require.NoError(t, err)
+		defer ctx.CloseSession(session)
+		require.NoError(t, ctx.Login(session, pkcs11.CKU_USER, pin))
+		defer ctx.Logout(session)
+
+		require.NoError(t, ctx.FindObjectsInit(session, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, label)}))
+		objects, _, err := ctx.FindObjects(session, 10)
+		require.NoError(t, err)
+		ctx.FindObjectsFinal(session)
+		for _, o := range objects {
+			ctx.DestroyObject(session, o)
+		}
+
+		// CKM_EC_EDWARDS_KEY_PAIR_GEN, on the curve with OID 1.3.101.112
+		_, _, err = ctx.GenerateKeyPair(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(0x1055, nil)},
+			[]*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+				pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, []byte{0x06, 0x03, 0x2b, 0x65, 0x70}),
+				pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+			},
+			[]*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+				pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+				pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+				pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+				pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+				pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+			})
+		require.NoError(t, err)
+		return
+	}
+	t.Fatalf("no token labelled %q", token)
+}
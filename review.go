@@ -0,0 +1,117 @@
+package canarytail
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxReviewedLifetime is the longest a canary may be valid for before signers are warned about it
+const MaxReviewedLifetime = 366 * 24 * time.Hour
+
+// FormatDays formats a duration in days, or in hours and minutes below two days
+func FormatDays(d time.Duration) string {
+	if d < 48*time.Hour && d > -48*time.Hour {
+		return d.Round(time.Minute).String()
+	}
+	return fmt.Sprintf("%.1f days", d.Hours()/24)
+}
+
+// ClaimChange is a field of the claim changed since a previous claim, by its JSON name, with its values
+// in JSON
+type ClaimChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// DiffClaims lists the fields changed from the previous claim to the claim, sorted by name
+func DiffClaims(previous, claim CanaryClaim) ([]ClaimChange, error) {
+	before, err := StructToMap(previous)
+	if err != nil {
+		return nil, err
+	}
+	after, err := StructToMap(claim)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]ClaimChange, 0)
+	// the codes are a set, in no particular order
+	beforeCodes, afterCodes := append([]string{}, previous.Codes...), append([]string{}, claim.Codes...)
+	sort.Strings(beforeCodes)
+	sort.Strings(afterCodes)
+	before["codes"], after["codes"] = beforeCodes, afterCodes
+	for field := range mergeKeys(before, after) {
+		if reflect.DeepEqual(before[field], after[field]) {
+			continue
+		}
+		b, _ := json.Marshal(before[field])
+		a, _ := json.Marshal(after[field])
+		changes = append(changes, ClaimChange{Field: field, Before: string(b), After: string(a)})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// CheckSignatures checks every signature of the canary is valid, and made by the key of a signer, a
+// panic key or the delegated key of the canary
+func (c Canary) CheckSignatures() error {
+	for key := range c.Signatures {
+		_, listed := c.Claim.signer(key)
+		if !listed && key != c.Claim.PanicKey && (c.Delegation == nil || key != c.Delegation.Key) {
+			return fmt.Errorf("the canary is signed by %v, which is not one of its signers", key)
+		}
+		pubKey, err := ParsePublicKey(key)
+		if err != nil || !c.ValidateSignatures(pubKey) {
+			return fmt.Errorf("Signature verification failed for key %s", key)
+		}
+	}
+	return nil
+}
+
+// ReviewWarnings lists what a signer should look at twice before signing the canary at now: a panic
+// signature, dates out of place, and compared with the previous canary the signer signed, if any, codes
+// restored after they were tripped or a longer lifetime
+func (c Canary) ReviewWarnings(previous *Canary, now time.Time) []string {
+	warnings := make([]string, 0)
+	if c.ValidateSignatures(c.PanicKey()) {
+		warnings = append(warnings, "the canary is signed with the panic key")
+	}
+	lifetime := c.ExiprationTimestamp().Sub(c.ReleaseTimestamp())
+	if !c.ExiprationTimestamp().After(now) {
+		warnings = append(warnings, fmt.Sprintf("the canary expired on %v", c.Claim.Expiry))
+	} else if lifetime > MaxReviewedLifetime {
+		warnings = append(warnings, fmt.Sprintf("the canary is valid for %v, until %v", FormatDays(lifetime), c.Claim.Expiry))
+	}
+	if c.ReleaseTimestamp().Sub(now) > time.Hour {
+		warnings = append(warnings, fmt.Sprintf("the canary is released in the future, on %v", c.Claim.Release))
+	}
+	if previous == nil {
+		return warnings
+	}
+
+	if previous.Claim.Domain != c.Claim.Domain {
+		warnings = append(warnings, fmt.Sprintf("the canary is for %v, the one you signed last for %v", c.Claim.Domain, previous.Claim.Domain))
+	}
+	restored := make([]string, 0)
+	for _, code := range previous.MissingCodes() {
+		for _, current := range c.Claim.Codes {
+			if code == current {
+				restored = append(restored, code)
+			}
+		}
+	}
+	if len(restored) > 0 {
+		warnings = append(warnings, fmt.Sprintf("the codes %v were tripped in the canary you signed last, and are restored", strings.Join(restored, ", ")))
+	}
+	if previousLifetime := previous.ExiprationTimestamp().Sub(previous.ReleaseTimestamp()); lifetime > 2*previousLifetime {
+		warnings = append(warnings, fmt.Sprintf("the canary is valid for %v, the one you signed last for %v", FormatDays(lifetime), FormatDays(previousLifetime)))
+	}
+	if c.ReleaseTimestamp().Before(previous.ReleaseTimestamp()) {
+		warnings = append(warnings, fmt.Sprintf("the canary is released before the one you signed last, on %v", previous.Claim.Release))
+	}
+	return warnings
+}
@@ -0,0 +1,66 @@
+package canarytail_test
+
+import (
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffClaims(t *testing.T) {
+	keys := rotationKeys(t, 4)
+	now := time.Now()
+	previous := testCanary(t, keys, releasedAt(now.Add(-24*time.Hour)))
+	previous.Claim.Codes = []string{"war", "gag"}
+	claim := previous.Claim
+	claim.Codes = []string{"gag", "war"}
+	changes, err := canarytail.DiffClaims(previous.Claim, claim)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Equal(t, []string{"gag", "war"}, claim.Codes)
+
+	claim.Release = now.Format(canarytail.TimestampLayout)
+	claim.Codes = []string{"gag"}
+	changes, err = canarytail.DiffClaims(previous.Claim, claim)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, canarytail.ClaimChange{Field: "codes", Before: `["gag","war"]`, After: `["gag"]`}, changes[0])
+	assert.Equal(t, "release", changes[1].Field)
+}
+
+func TestReviewWarnings(t *testing.T) {
+	keys := rotationKeys(t, 4)
+	now := time.Now()
+	previous := testCanary(t, keys, releasedAt(now.Add(-24*time.Hour)))
+	previous.Claim.Expiry = now.Add(6 * 24 * time.Hour).Format(canarytail.TimestampLayout)
+	previous.Claim.Codes = canarytail.InverseCodes([]string{"war"})
+
+	canary := testCanary(t, keys, releasedAt(now))
+	canary.Claim.Expiry = now.Add(7 * 24 * time.Hour).Format(canarytail.TimestampLayout)
+	canary.Claim.Codes = canarytail.AllCodes()
+	warnings := canary.ReviewWarnings(&previous, now)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "war")
+
+	// a canary valid far longer than the previous one
+	canary.Claim.Codes = previous.Claim.Codes
+	canary.Claim.Expiry = now.Add(400 * 24 * time.Hour).Format(canarytail.TimestampLayout)
+	assert.Len(t, canary.ReviewWarnings(&previous, now), 2)
+	assert.Len(t, canary.ReviewWarnings(nil, now), 1)
+}
+
+func TestCheckSignatures(t *testing.T) {
+	keys := rotationKeys(t, 5)
+	canary := testCanary(t, keys[:4])
+	assert.NoError(t, canary.CheckSignatures())
+
+	// signatures of keys that are not signers, or no longer match the claim, are refused
+	require.NoError(t, canary.Sign(keys[4]))
+	assert.Error(t, canary.CheckSignatures())
+	canary = testCanary(t, keys[:4])
+	canary.Claim.Mirrors = []string{"https://example.org"}
+	assert.Error(t, canary.CheckSignatures())
+}
@@ -0,0 +1,217 @@
+package canarytail
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Revocation records that keys of the signers of a canary, or keys they delegated to, must not be
+// trusted anymore, in canaries released from its effective time on. The effective time may precede the
+// revocation, when a key was compromised before it was found out. A revocation is signed by the author of the canary, or by a
+// quorum of its other signers.
+type Revocation struct {
+	Domain string `json:"domain"`
+	// Keys are the revoked public keys
+	Keys      []string `json:"keys"`
+	Effective string   `json:"effective"`
+	Reason    string   `json:"reason,omitempty"`
+	// Signatures are the base64 encoded signatures of the revocation, by public key of the signer
+	Signatures map[string]string `json:"signatures"`
+}
+
+// NewRevocation instantiates an unsigned revocation of keys of the signers of a domain
+func NewRevocation(domain string, keys []string, effective time.Time, reason string) *Revocation {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+	return &Revocation{
+		Domain:     domain,
+		Keys:       keys,
+		Effective:  effective.UTC().Format(TimestampLayout),
+		Reason:     reason,
+		Signatures: make(map[string]string),
+	}
+}
+
+// message is what the signers of the revocation sign
+func (r Revocation) message() []byte {
+	lines := append([]string{"canarytail revocation", r.Domain, r.Effective, r.Reason}, r.Keys...)
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// Sign adds the signature of a signer to the revocation
+func (r *Revocation) Sign(signer crypto.Signer) error {
+	publicKey, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("revocations are signed with Ed25519 keys, not %T", signer.Public())
+	}
+	signature, err := signer.Sign(rand.Reader, r.message(), crypto.Hash(0))
+	if err != nil {
+		return fmt.Errorf("Could not sign the revocation: %v", err)
+	}
+	if r.Signatures == nil {
+		r.Signatures = make(map[string]string)
+	}
+	r.Signatures[FormatKey(publicKey)] = base64.StdEncoding.EncodeToString(signature)
+	return nil
+}
+
+// EffectiveTimestamp parses the time the revocation takes effect
+func (r Revocation) EffectiveTimestamp() time.Time {
+	t, _ := time.Parse(TimestampLayout, r.Effective)
+	return t
+}
+
+// Revokes tells whether the revocation revokes a key in canaries released at the given time
+func (r Revocation) Revokes(key string, release time.Time) bool {
+	return containsString(r.Keys, key) && !release.Before(r.EffectiveTimestamp())
+}
+
+// Verify checks the revocation is signed by the author of the canary, or by at least MinSigners (and
+// no fewer than 2) of its other signers whose keys it does not revoke
+func (r Revocation) Verify(c Canary) error {
+	if r.Domain != c.Claim.Domain {
+		return fmt.Errorf("the revocation is for %v, not %v", r.Domain, c.Claim.Domain)
+	}
+	if len(r.Keys) == 0 {
+		return errors.New("the revocation revokes no key")
+	}
+	if _, err := time.Parse(TimestampLayout, r.Effective); err != nil {
+		return fmt.Errorf("invalid effective date %q in the revocation", r.Effective)
+	}
+
+	quorum := c.Claim.MinSigners
+	if quorum < 2 {
+		quorum = 2
+	}
+	signers := 0
+	for _, k := range c.Claim.PublicKeys {
+		encoded, ok := r.Signatures[k.Key]
+		if !ok || containsString(r.Keys, k.Key) {
+			continue
+		}
+		publicKey, err := ParsePublicKey(k.Key)
+		if err != nil {
+			return err
+		}
+		signature, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || !ed25519.Verify(publicKey, r.message(), signature) {
+			return fmt.Errorf("invalid signature of the revocation by %q", k.Name)
+		}
+		if k.Role == RoleAuthor {
+			return nil
+		}
+		signers++
+	}
+	if signers < quorum {
+		return fmt.Errorf("the revocation is signed by %d of the other signers of the canary, and neither by its author nor by the %d needed", signers, quorum)
+	}
+	return nil
+}
+
+// SignatureStatus tells what became of the signature of a listed signer of a canary
+type SignatureStatus string
+
+// The statuses of a signature: a signature from a revoked key is reported apart from a missing one, a
+// signature by a delegated key in place of the signer apart from its own, and a signature by the own
+// panic key of the signer, whatever else it signed, as the signer raising the panic
+const (
+	SignatureValid     SignatureStatus = "valid"
+	SignatureInvalid   SignatureStatus = "invalid"
+	SignatureMissing   SignatureStatus = "missing"
+	SignatureRevoked   SignatureStatus = "revoked"
+	SignatureDelegated SignatureStatus = "delegated"
+	SignaturePanic     SignatureStatus = "panic"
+)
+
+// SignerReport is the status of the signature of a listed signer of a canary
+type SignerReport struct {
+	Signer PublicKey
+	Status SignatureStatus
+	// Revocation is the revocation of the key of the signer, if it is revoked
+	Revocation *Revocation
+	// Delegation is the delegation of the key that signed in place of the signer, if any
+	Delegation *Delegation
+}
+
+// revocation returns the valid revocation of a key for the canary, if any
+func (v *CanaryValidator) revocation(key string) (*Revocation, error) {
+	release := v.Canary.ReleaseTimestamp()
+	for i := range v.Revocations {
+		r := &v.Revocations[i]
+		if !r.Revokes(key, release) {
+			continue
+		}
+		if err := r.Verify(v.Canary); err != nil {
+			return nil, fmt.Errorf("invalid revocation of %v: %v", key, err)
+		}
+		return r, nil
+	}
+	return nil, nil
+}
+
+// Report reports the status of the signature of every signer listed in the canary. It fails when the
+// canary comes with a revocation or a delegation it cannot verify.
+func (v *CanaryValidator) Report() ([]SignerReport, error) {
+	reports := make([]SignerReport, 0, len(v.Canary.Claim.PublicKeys))
+	for _, k := range v.Canary.Claim.PublicKeys {
+		report := SignerReport{Signer: k, Status: SignatureMissing}
+		revocation, err := v.revocation(k.Key)
+		if err != nil {
+			return nil, err
+		}
+		report.Revocation = revocation
+		if _, ok := v.Canary.Signatures[k.Key]; ok {
+			publicKey, _ := ParsePublicKey(k.Key)
+			switch {
+			case revocation != nil:
+				report.Status = SignatureRevoked
+			case v.Canary.ValidateSignatures(publicKey):
+				report.Status = SignatureValid
+			default:
+				report.Status = SignatureInvalid
+			}
+		} else if revocation == nil && k.Role == RoleAuthor {
+			delegated, revoked, err := v.delegatedSignature(k.Key)
+			if err != nil {
+				return nil, err
+			}
+			if delegated {
+				report.Status, report.Delegation = SignatureDelegated, v.Canary.Delegation
+			}
+			// a revoked delegated key no longer signs in place of the author
+			if revoked != nil {
+				report.Status, report.Revocation = SignatureRevoked, revoked
+			}
+		}
+		if panicked, err := v.signerPanic(k); err != nil {
+			return nil, err
+		} else if panicked {
+			report.Status = SignaturePanic
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// signerPanic tells whether the signer signed the canary with its own panic key, unless that key is revoked
+func (v *CanaryValidator) signerPanic(k PublicKey) (bool, error) {
+	if _, signed := v.Canary.Signatures[k.PanicKey]; k.PanicKey == "" || !signed {
+		return false, nil
+	}
+	revocation, err := v.revocation(k.PanicKey)
+	if err != nil || revocation != nil {
+		return false, err
+	}
+	panicKey, err := ParsePublicKey(k.PanicKey)
+	if err != nil || len(panicKey) != ed25519.PublicKeySize {
+		return false, fmt.Errorf("invalid panic key of the signer %q", k.Name)
+	}
+	return v.Canary.ValidateSignatures(panicKey), nil
+}
@@ -0,0 +1,92 @@
+package canarytail_test
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevocation(t *testing.T) {
+	keys := rotationKeys(t, 4)
+	bobKey := canarytail.FormatKey(keys[2].Public().(ed25519.PublicKey))
+	now := time.Now()
+	canary := testCanary(t, keys, releasedAt(now))
+
+	// the author alone may revoke
+	revocation := canarytail.NewRevocation("example.com", []string{bobKey}, now.Add(-time.Hour), "laptop seized")
+	require.NoError(t, revocation.Sign(keys[0]))
+	assert.NoError(t, revocation.Verify(canary))
+	assert.True(t, revocation.Revokes(bobKey, now))
+	assert.False(t, revocation.Revokes(bobKey, now.Add(-2*time.Hour)))
+
+	// other signers need a quorum, not counting the revoked keys
+	revocation = canarytail.NewRevocation("example.com", []string{bobKey}, now.Add(-time.Hour), "")
+	require.NoError(t, revocation.Sign(keys[1]))
+	require.NoError(t, revocation.Sign(keys[2]))
+	assert.Error(t, revocation.Verify(canary))
+	require.NoError(t, revocation.Sign(keys[3]))
+	assert.NoError(t, revocation.Verify(canary))
+
+	// a tampered revocation is refused
+	revocation.Effective = now.Add(-48 * time.Hour).Format(canarytail.TimestampLayout)
+	assert.Error(t, revocation.Verify(canary))
+}
+
+func TestValidatorReportsRevokedSignatures(t *testing.T) {
+	keys := rotationKeys(t, 4)
+	bobKey := canarytail.FormatKey(keys[2].Public().(ed25519.PublicKey))
+	now := time.Now()
+	canary := testCanary(t, keys, releasedAt(now))
+	delete(canary.Signatures, canarytail.FormatKey(keys[3].Public().(ed25519.PublicKey)))
+
+	revocation := canarytail.NewRevocation("example.com", []string{bobKey}, now.Add(-time.Hour), "laptop seized")
+	require.NoError(t, revocation.Sign(keys[0]))
+	validator := canarytail.NewCanaryValidator(canary)
+	validator.Revocations = []canarytail.Revocation{*revocation}
+
+	report, err := validator.Report()
+	require.NoError(t, err)
+	statuses := make(map[string]canarytail.SignatureStatus)
+	for _, r := range report {
+		statuses[r.Signer.Name] = r.Status
+	}
+	assert.Equal(t, map[string]canarytail.SignatureStatus{
+		"author": canarytail.SignatureValid,
+		"alice":  canarytail.SignatureValid,
+		"bob":    canarytail.SignatureRevoked,
+		"carol":  canarytail.SignatureMissing,
+	}, statuses)
+
+	// canaries released before the revocation takes effect still trust the key
+	validator.Canary = testCanary(t, keys, releasedAt(now.Add(-2*time.Hour)))
+	report, err = validator.Report()
+	require.NoError(t, err)
+	assert.Equal(t, canarytail.SignatureValid, report[2].Status)
+
+	// a revocation signed by a single cosigner is not enforced
+	forged := canarytail.NewRevocation("example.com", []string{bobKey}, now.Add(-time.Hour), "")
+	require.NoError(t, forged.Sign(keys[1]))
+	validator = canarytail.NewCanaryValidator(canary)
+	validator.Revocations = []canarytail.Revocation{*forged}
+	_, err = validator.Report()
+	assert.Error(t, err)
+}
+
+func TestValidatorRefusesRevokedRequiredSigner(t *testing.T) {
+	keys := rotationKeys(t, 4)
+	now := time.Now()
+	canary := testCanary(t, keys, releasedAt(now))
+	canary.Claim.PublicKeys[2].Required = true
+
+	revocation := canarytail.NewRevocation("example.com", []string{canary.Claim.PublicKeys[2].Key}, now.Add(-time.Hour), "")
+	require.NoError(t, revocation.Sign(keys[0]))
+	canary.Revocations = []canarytail.Revocation{*revocation}
+	ok, err := canarytail.NewCanaryValidator(canary).Validate()
+	assert.False(t, ok)
+	assert.Contains(t, err.Error(), "revoked")
+}
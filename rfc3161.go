@@ -0,0 +1,384 @@
+package canarytail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// RFC 3161 trusted timestamps, see https://tools.ietf.org/html/rfc3161
+//
+// A timestamp authority (TSA) countersigns the canary Digest with the current time. The token is a CMS
+// SignedData (https://tools.ietf.org/html/rfc5652) whose content is a TSTInfo structure.
+
+var (
+	oidSHA256            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidRSAEncryption     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSAWithSHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidEd25519           = asn1.ObjectIdentifier{1, 3, 101, 112}
+	oidSignedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidAttrContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+// timestampSkew is how much earlier than the canary release a TSA may date its token
+const timestampSkew = 5 * time.Minute
+
+// TimeStampReq is an RFC 3161 timestamp request
+type TimeStampReq struct {
+	Version        int
+	MessageImprint MessageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+	Extensions     []pkix.Extension      `asn1:"optional,tag:0"`
+}
+
+// MessageImprint is the hash of the timestamped data
+type MessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+// TimeStampResp is an RFC 3161 timestamp response
+type TimeStampResp struct {
+	Status         PKIStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// PKIStatusInfo is the status of a timestamp response
+type PKIStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+// TSTInfo is the content signed by the TSA
+type TSTInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint MessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time        `asn1:"generalized"`
+	Accuracy       Accuracy         `asn1:"optional"`
+	Ordering       bool             `asn1:"optional,default:false"`
+	Nonce          *big.Int         `asn1:"optional"`
+	TSA            asn1.RawValue    `asn1:"optional,tag:0"`
+	Extensions     []pkix.Extension `asn1:"optional,tag:1"`
+}
+
+// Accuracy is the uncertainty of the TSA time
+type Accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// Duration converts the accuracy to a time.Duration
+func (a Accuracy) Duration() time.Duration {
+	return time.Duration(a.Seconds)*time.Second + time.Duration(a.Millis)*time.Millisecond + time.Duration(a.Micros)*time.Microsecond
+}
+
+// ContentInfo is a CMS content
+type ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+// SignedData is a CMS SignedData
+type SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo EncapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []SignerInfo  `asn1:"set"`
+}
+
+// EncapsulatedContentInfo is the signed content of a CMS SignedData
+type EncapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"optional,explicit,tag:0"`
+}
+
+// SignerInfo is a signature of a CMS SignedData
+type SignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// IssuerAndSerialNumber identifies a certificate
+type IssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// Attribute is a CMS signed attribute
+type Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// TimestampToken is a parsed and verified RFC 3161 timestamp token
+type TimestampToken struct {
+	Info         TSTInfo
+	Certificates []*x509.Certificate
+	// Signer is the certificate of the TSA that signed the token
+	Signer *x509.Certificate
+}
+
+// hashForOID maps a digest algorithm to its hash function
+func hashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm %v", oid)
+}
+
+// signatureAlgorithm maps the algorithms of a SignerInfo to the x509 signature algorithm
+func signatureAlgorithm(digest, signature asn1.ObjectIdentifier) (x509.SignatureAlgorithm, error) {
+	hash, err := hashForOID(digest)
+	if err != nil {
+		return x509.UnknownSignatureAlgorithm, err
+	}
+	switch {
+	case signature.Equal(oidEd25519):
+		return x509.PureEd25519, nil
+	case signature.Equal(oidRSAEncryption):
+		return map[crypto.Hash]x509.SignatureAlgorithm{
+			crypto.SHA256: x509.SHA256WithRSA, crypto.SHA384: x509.SHA384WithRSA, crypto.SHA512: x509.SHA512WithRSA,
+		}[hash], nil
+	case signature.Equal(oidSHA256WithRSA):
+		return x509.SHA256WithRSA, nil
+	case signature.Equal(oidSHA384WithRSA):
+		return x509.SHA384WithRSA, nil
+	case signature.Equal(oidSHA512WithRSA):
+		return x509.SHA512WithRSA, nil
+	case signature.Equal(oidECDSAWithSHA256):
+		return x509.ECDSAWithSHA256, nil
+	case signature.Equal(oidECDSAWithSHA384):
+		return x509.ECDSAWithSHA384, nil
+	case signature.Equal(oidECDSAWithSHA512):
+		return x509.ECDSAWithSHA512, nil
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported signature algorithm %v", signature)
+}
+
+// ParseTimestampToken parses a DER encoded timestamp token and verifies the TSA signature over it.
+// The certificate chain of the TSA is not verified, see VerifyChain.
+func ParseTimestampToken(der []byte) (*TimestampToken, error) {
+	var content ContentInfo
+	if rest, err := asn1.Unmarshal(der, &content); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("invalid timestamp token: %v", err)
+	}
+	if !content.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("the timestamp token is not a CMS SignedData")
+	}
+	var signed SignedData
+	if _, err := asn1.Unmarshal(content.Content.Bytes, &signed); err != nil {
+		return nil, fmt.Errorf("invalid timestamp token signed data: %v", err)
+	}
+	if !signed.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, errors.New("the timestamp token does not contain a TSTInfo")
+	}
+
+	token := &TimestampToken{}
+	if _, err := asn1.Unmarshal(signed.EncapContentInfo.EContent, &token.Info); err != nil {
+		return nil, fmt.Errorf("invalid timestamp token info: %v", err)
+	}
+	if len(signed.Certificates.Bytes) > 0 {
+		certs, err := x509.ParseCertificates(signed.Certificates.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp token certificates: %v", err)
+		}
+		token.Certificates = certs
+	}
+
+	if len(signed.SignerInfos) != 1 {
+		return nil, fmt.Errorf("a timestamp token must have one signer, got %d", len(signed.SignerInfos))
+	}
+	signer := signed.SignerInfos[0]
+	if token.Signer = findSigner(signer.SID, token.Certificates); token.Signer == nil {
+		return nil, errors.New("the certificate of the timestamp token signer is missing")
+	}
+	if err := verifySignerInfo(signer, signed.EncapContentInfo.EContent, token.Signer); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// findSigner finds the certificate a SignerInfo identifies, by issuer and serial number or by subject key id
+func findSigner(sid asn1.RawValue, certs []*x509.Certificate) *x509.Certificate {
+	if sid.Class == asn1.ClassContextSpecific && sid.Tag == 0 {
+		for _, cert := range certs {
+			if bytes.Equal(cert.SubjectKeyId, sid.Bytes) {
+				return cert
+			}
+		}
+		return nil
+	}
+	var ias IssuerAndSerialNumber
+	if _, err := asn1.Unmarshal(sid.FullBytes, &ias); err != nil {
+		return nil
+	}
+	for _, cert := range certs {
+		if cert.SerialNumber.Cmp(ias.SerialNumber) == 0 && bytes.Equal(cert.RawIssuer, ias.Issuer.FullBytes) {
+			return cert
+		}
+	}
+	return nil
+}
+
+// verifySignerInfo checks the signed attributes match the content, and the signature over them
+func verifySignerInfo(signer SignerInfo, content []byte, cert *x509.Certificate) error {
+	if len(signer.SignedAttrs.Bytes) == 0 {
+		return errors.New("the timestamp token has no signed attributes")
+	}
+	hash, err := hashForOID(signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	var attrs []Attribute
+	if _, err := asn1.UnmarshalWithParams(signer.SignedAttrs.FullBytes, &attrs, "set,tag:0"); err != nil {
+		return fmt.Errorf("invalid signed attributes: %v", err)
+	}
+	h := hash.New()
+	h.Write(content)
+	contentTypeOK, digestOK := false, false
+	for _, attr := range attrs {
+		if len(attr.Values) != 1 {
+			continue
+		}
+		switch {
+		case attr.Type.Equal(oidAttrContentType):
+			var contentType asn1.ObjectIdentifier
+			_, err := asn1.Unmarshal(attr.Values[0].FullBytes, &contentType)
+			contentTypeOK = err == nil && contentType.Equal(oidTSTInfo)
+		case attr.Type.Equal(oidAttrMessageDigest):
+			var digest []byte
+			_, err := asn1.Unmarshal(attr.Values[0].FullBytes, &digest)
+			digestOK = err == nil && bytes.Equal(digest, h.Sum(nil))
+		}
+	}
+	if !contentTypeOK || !digestOK {
+		return errors.New("the signed attributes of the timestamp token do not match its content")
+	}
+
+	algorithm, err := signatureAlgorithm(signer.DigestAlgorithm.Algorithm, signer.SignatureAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	// the signature covers the DER encoding of the attributes as a SET, not as the implicit [0]
+	signedAttrs := append([]byte{0x31}, signer.SignedAttrs.FullBytes[1:]...)
+	if err := cert.CheckSignature(algorithm, signedAttrs, signer.Signature); err != nil {
+		return fmt.Errorf("invalid timestamp token signature: %v", err)
+	}
+	return nil
+}
+
+// VerifyChain verifies the certificate of the TSA chains up to roots, and is allowed to sign timestamps
+func (t *TimestampToken) VerifyChain(roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range t.Certificates {
+		intermediates.AddCert(cert)
+	}
+	_, err := t.Signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   t.Info.GenTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	})
+	return err
+}
+
+// VerifyImprint checks the token timestamps the given SHA-256 digest
+func (t *TimestampToken) VerifyImprint(digest []byte) error {
+	if !t.Info.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) || !bytes.Equal(t.Info.MessageImprint.HashedMessage, digest) {
+		return errors.New("the timestamp token is not a timestamp of this digest")
+	}
+	return nil
+}
+
+// RequestTimestamp asks a TSA to timestamp a SHA-256 digest and returns the DER encoded token, after
+// checking it is a timestamp of the digest
+func RequestTimestamp(tsaURL string, digest []byte) ([]byte, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(TimeStampReq{
+		Version: 1,
+		MessageImprint: MessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Post(tsaURL, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Could not retrieve timestamp, got code %v", resp.StatusCode)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	var tsResp TimeStampResp
+	if _, err := asn1.Unmarshal(content, &tsResp); err != nil {
+		return nil, fmt.Errorf("invalid timestamp response: %v", err)
+	}
+	// 0 is granted, 1 granted with modifications
+	if tsResp.Status.Status > 1 {
+		return nil, fmt.Errorf("the TSA rejected the request with status %d: %v", tsResp.Status.Status, tsResp.Status.StatusString)
+	}
+
+	token, err := ParseTimestampToken(tsResp.TimeStampToken.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := token.VerifyImprint(digest); err != nil {
+		return nil, err
+	}
+	if token.Info.Nonce == nil || token.Info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("the timestamp token does not answer our request")
+	}
+	return tsResp.TimeStampToken.FullBytes, nil
+}
@@ -0,0 +1,191 @@
+package canarytail_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testOIDSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	testOIDECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	testOIDSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	testOIDTSTInfo         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	testOIDContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	testOIDMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+// tsaServer is a stand-in RFC 3161 timestamp authority, with its own root certificate
+type tsaServer struct {
+	*httptest.Server
+	Roots *x509.CertPool
+	// Offset shifts the time the TSA puts in its tokens
+	Offset time.Duration
+
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTSAServer(t *testing.T) *tsaServer {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test TSA Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test TSA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	tsa := &tsaServer{Roots: x509.NewCertPool(), cert: cert, key: key}
+	tsa.Roots.AddCert(ca)
+	tsa.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var req canarytail.TimeStampReq
+		if _, err := asn1.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp, err := asn1.Marshal(canarytail.TimeStampResp{
+			Status:         canarytail.PKIStatusInfo{Status: 0},
+			TimeStampToken: asn1.RawValue{FullBytes: tsa.token(t, req.MessageImprint.HashedMessage, req.Nonce)},
+		})
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		w.Write(resp)
+	}))
+	t.Cleanup(tsa.Close)
+	return tsa
+}
+
+// token builds a timestamp token of digest, signed by the TSA
+func (tsa *tsaServer) token(t *testing.T, digest []byte, nonce *big.Int) []byte {
+	info, err := asn1.Marshal(canarytail.TSTInfo{
+		Version: 1,
+		Policy:  asn1.ObjectIdentifier{1, 2, 3, 4},
+		MessageImprint: canarytail.MessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: testOIDSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		GenTime:      time.Now().Add(tsa.Offset).UTC().Truncate(time.Second),
+		Accuracy:     canarytail.Accuracy{Seconds: 1},
+		Nonce:        nonce,
+	})
+	require.NoError(t, err)
+
+	contentType, _ := asn1.Marshal(testOIDTSTInfo)
+	infoDigest := sha256.Sum256(info)
+	messageDigest, _ := asn1.Marshal(infoDigest[:])
+	attrs, err := asn1.MarshalWithParams([]canarytail.Attribute{
+		{Type: testOIDContentType, Values: []asn1.RawValue{{FullBytes: contentType}}},
+		{Type: testOIDMessageDigest, Values: []asn1.RawValue{{FullBytes: messageDigest}}},
+	}, "set")
+	require.NoError(t, err)
+	attrsDigest := sha256.Sum256(attrs)
+	signature, err := tsa.key.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	require.NoError(t, err)
+
+	sid, err := asn1.Marshal(canarytail.IssuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: tsa.cert.RawIssuer}, SerialNumber: tsa.cert.SerialNumber})
+	require.NoError(t, err)
+	signed, err := asn1.Marshal(canarytail.SignedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: testOIDSHA256}},
+		EncapContentInfo: canarytail.EncapsulatedContentInfo{EContentType: testOIDTSTInfo, EContent: info},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: tsa.cert.Raw},
+		SignerInfos: []canarytail.SignerInfo{{
+			Version:            1,
+			SID:                asn1.RawValue{FullBytes: sid},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: testOIDSHA256},
+			SignedAttrs:        asn1.RawValue{FullBytes: append([]byte{0xa0}, attrs[1:]...)},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: testOIDECDSAWithSHA256},
+			Signature:          signature,
+		}},
+	})
+	require.NoError(t, err)
+	token, err := asn1.Marshal(canarytail.ContentInfo{
+		ContentType: testOIDSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed},
+	})
+	require.NoError(t, err)
+	return token
+}
+
+func TestTimestampToken(t *testing.T) {
+	tsa := newTSAServer(t)
+	c := testCanary(t, nil)
+
+	token, err := canarytail.RequestTimestamp(tsa.URL, c.Digest())
+	require.NoError(t, err)
+	c.SetTimestampToken(token)
+
+	timestamp, err := c.VerifyTimestampToken(tsa.Roots)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), timestamp, 5*time.Second)
+
+	t.Run("untrusted TSA", func(t *testing.T) {
+		_, err := c.VerifyTimestampToken(newTSAServer(t).Roots)
+		assert.Error(t, err)
+		_, err = c.VerifyTimestampToken(nil)
+		assert.NoError(t, err, "the TSA is not checked without roots")
+	})
+
+	t.Run("token of another canary", func(t *testing.T) {
+		tampered := c
+		tampered.Claim.Mirrors = []string{"https://example.com"}
+		_, err := tampered.VerifyTimestampToken(tsa.Roots)
+		assert.Error(t, err)
+	})
+
+	t.Run("tampered token", func(t *testing.T) {
+		tampered := append([]byte{}, token...)
+		tampered[len(tampered)-1] ^= 1
+		_, err := canarytail.ParseTimestampToken(tampered)
+		assert.Error(t, err)
+	})
+
+	t.Run("backdated token", func(t *testing.T) {
+		backdated := c
+		tsa.Offset = -time.Hour
+		backdated.SetTimestampToken(tsa.token(t, backdated.Digest(), nil))
+		tsa.Offset = 0
+		_, err := backdated.VerifyTimestampToken(tsa.Roots)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,124 @@
+package canarytail
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// KeyRotation records the replacement of the author key of a canary. It is signed by both the old and
+// the new key, so that a validator trusting the old key can follow it to the new one.
+type KeyRotation struct {
+	Domain string `json:"domain"`
+	OldKey string `json:"old_key"`
+	NewKey string `json:"new_key"`
+	Time   string `json:"time"`
+	// OldSignature and NewSignature are the base64 encoded signatures of the rotation by both keys
+	OldSignature string `json:"old_signature"`
+	NewSignature string `json:"new_signature"`
+}
+
+// message is what both keys sign
+func (r KeyRotation) message() []byte {
+	return []byte(strings.Join([]string{"canarytail key rotation", r.Domain, r.OldKey, r.NewKey, r.Time}, "\n"))
+}
+
+// NewKeyRotation rotates the author key of a domain from the key of oldSigner to the key of newSigner
+func NewKeyRotation(domain string, oldSigner, newSigner crypto.Signer, t time.Time) (*KeyRotation, error) {
+	oldKey, ok := oldSigner.Public().(ed25519.PublicKey)
+	newKey, newOk := newSigner.Public().(ed25519.PublicKey)
+	if !ok || !newOk {
+		return nil, errors.New("keys are rotated between Ed25519 keys")
+	}
+	r := &KeyRotation{
+		Domain: domain,
+		OldKey: FormatKey(oldKey),
+		NewKey: FormatKey(newKey),
+		Time:   t.UTC().Format(TimestampLayout),
+	}
+	if r.OldKey == r.NewKey {
+		return nil, errors.New("the new key is the old key")
+	}
+	for _, s := range []struct {
+		signer    crypto.Signer
+		signature *string
+	}{{oldSigner, &r.OldSignature}, {newSigner, &r.NewSignature}} {
+		signature, err := s.signer.Sign(rand.Reader, r.message(), crypto.Hash(0))
+		if err != nil {
+			return nil, fmt.Errorf("Could not sign the key rotation: %v", err)
+		}
+		*s.signature = base64.StdEncoding.EncodeToString(signature)
+	}
+	return r, nil
+}
+
+// Verify checks the rotation is signed by both its keys
+func (r KeyRotation) Verify() error {
+	if _, err := time.Parse(TimestampLayout, r.Time); err != nil {
+		return fmt.Errorf("invalid rotation time %q", r.Time)
+	}
+	for _, s := range []struct{ key, signature string }{{r.OldKey, r.OldSignature}, {r.NewKey, r.NewSignature}} {
+		publicKey, err := ParsePublicKey(s.key)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid key %q in the rotation", s.key)
+		}
+		signature, err := base64.StdEncoding.DecodeString(s.signature)
+		if err != nil || !ed25519.Verify(publicKey, r.message(), signature) {
+			return fmt.Errorf("the rotation from %v to %v is not signed by %v", r.OldKey, r.NewKey, s.key)
+		}
+	}
+	return nil
+}
+
+// AuthorKey returns the key of the author of the canary, the first key with the author role
+func (c Canary) AuthorKey() string {
+	for _, k := range c.Claim.PublicKeys {
+		if k.Role == RoleAuthor {
+			return k.Key
+		}
+	}
+	return ""
+}
+
+// VerifyRotations checks every rotation of the canary is a valid rotation of its domain
+func (c Canary) VerifyRotations() error {
+	for _, r := range c.Rotations {
+		if r.Domain != c.Claim.Domain {
+			return fmt.Errorf("the rotation from %v to %v is for %v", r.OldKey, r.NewKey, r.Domain)
+		}
+		if err := r.Verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FollowRotations follows the rotations of the canary from a pinned author key, in order, and returns
+// the rotations leading to the author key of the canary. It fails if the author key cannot be reached.
+func (c Canary) FollowRotations(pinned string) ([]KeyRotation, error) {
+	if err := c.VerifyRotations(); err != nil {
+		return nil, err
+	}
+	var chain []KeyRotation
+	key, last := pinned, time.Time{}
+	for _, r := range c.Rotations {
+		if r.OldKey != key {
+			continue
+		}
+		t, _ := time.Parse(TimestampLayout, r.Time)
+		if t.Before(last) {
+			return nil, fmt.Errorf("the rotation from %v to %v is older than the one before it", r.OldKey, r.NewKey)
+		}
+		chain = append(chain, r)
+		key, last = r.NewKey, t
+	}
+	if key != c.AuthorKey() {
+		return nil, fmt.Errorf("the author key %v is not the pinned key %v, nor rotated from it", c.AuthorKey(), pinned)
+	}
+	return chain, nil
+}
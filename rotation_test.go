@@ -0,0 +1,142 @@
+package canarytail_test
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rotationKeys(t *testing.T, n int) []ed25519.PrivateKey {
+	keys := make([]ed25519.PrivateKey, n)
+	for i := range keys {
+		_, privateKey, err := canarytail.GenerateKeyPair()
+		require.NoError(t, err)
+		keys[i] = privateKey
+	}
+	return keys
+}
+
+// testCanary returns a canary of example.com released now, valid for an hour with every code and a
+// fresh panic key, signed by keys once amended. The first key is the author's and the next ones are the
+// cosigners alice, bob and carol, two signers at least; without keys, a fresh author key signs alone.
+func testCanary(t *testing.T, keys []ed25519.PrivateKey, amend ...func(*canarytail.Canary)) canarytail.Canary {
+	if len(keys) == 0 {
+		keys = rotationKeys(t, 1)
+	}
+	panicKey, _, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+
+	c := canarytail.Canary{
+		Version: canarytail.StandardVersion,
+		Claim: canarytail.CanaryClaim{
+			Domain:     "example.com",
+			MinSigners: 1,
+			PanicKey:   canarytail.FormatKey(panicKey),
+			Codes:      canarytail.AllCodes(),
+		},
+	}
+	releasedAt(time.Now())(&c)
+	withCosigners(keys...)(&c)
+	for _, f := range amend {
+		f(&c)
+	}
+	for _, k := range keys {
+		require.NoError(t, c.Sign(k))
+	}
+	return c
+}
+
+// withCosigners lists keys as signers of a test canary that do not sign it: given the author key
+// alone, testCanary(t, keys[:1], withCosigners(keys[1:]...)) is signed by the author alone
+func withCosigners(keys ...ed25519.PrivateKey) func(*canarytail.Canary) {
+	return func(c *canarytail.Canary) {
+		for _, k := range keys {
+			signer := canarytail.PublicKey{Role: canarytail.RoleAuthor, Name: "author", Key: canarytail.FormatKey(k.Public().(ed25519.PublicKey)), Required: true}
+			if n := len(c.Claim.PublicKeys); n > 0 {
+				signer = canarytail.PublicKey{Role: canarytail.RoleCosigner, Name: []string{"alice", "bob", "carol"}[n-1], Key: signer.Key}
+				c.Claim.MinSigners = 2
+			}
+			c.Claim.PublicKeys = append(c.Claim.PublicKeys, signer)
+		}
+	}
+}
+
+// releasedAt amends a test canary to be released at release, valid for an hour
+func releasedAt(release time.Time) func(*canarytail.Canary) {
+	return func(c *canarytail.Canary) {
+		c.Claim.Release = release.Format(canarytail.TimestampLayout)
+		c.Claim.Expiry = release.Add(time.Hour).Format(canarytail.TimestampLayout)
+	}
+}
+
+// withFreshness amends a test canary to claim freshness
+func withFreshness(freshness string) func(*canarytail.Canary) {
+	return func(c *canarytail.Canary) { c.Claim.Freshness = freshness }
+}
+
+func TestKeyRotation(t *testing.T) {
+	keys := rotationKeys(t, 2)
+	rotation, err := canarytail.NewKeyRotation("example.com", keys[0], keys[1], time.Now())
+	require.NoError(t, err)
+	assert.NoError(t, rotation.Verify())
+
+	// both keys must sign
+	forged := *rotation
+	forged.NewSignature = forged.OldSignature
+	assert.Error(t, forged.Verify())
+	forged = *rotation
+	forged.Domain = "example.org"
+	assert.Error(t, forged.Verify())
+
+	_, err = canarytail.NewKeyRotation("example.com", keys[0], keys[0], time.Now())
+	assert.Error(t, err)
+}
+
+func TestFollowRotations(t *testing.T) {
+	keys := rotationKeys(t, 4)
+	key := func(i int) string { return canarytail.FormatKey(keys[i].Public().(ed25519.PublicKey)) }
+	now := time.Now()
+	first, err := canarytail.NewKeyRotation("example.com", keys[0], keys[1], now.Add(-time.Hour))
+	require.NoError(t, err)
+	second, err := canarytail.NewKeyRotation("example.com", keys[1], keys[2], now)
+	require.NoError(t, err)
+	// rotated is a canary of the latest key, keys[2], listing rotations
+	rotated := func(rotations ...canarytail.KeyRotation) canarytail.Canary {
+		return testCanary(t, keys[2:3], func(c *canarytail.Canary) { c.Rotations = rotations })
+	}
+
+	canary := rotated(*first, *second)
+	chain, err := canary.FollowRotations(key(0))
+	require.NoError(t, err)
+	assert.Len(t, chain, 2)
+	chain, err = canary.FollowRotations(key(1))
+	require.NoError(t, err)
+	assert.Len(t, chain, 1)
+	chain, err = canary.FollowRotations(key(2))
+	require.NoError(t, err)
+	assert.Len(t, chain, 0)
+
+	// a key not linked to the pinned one is refused
+	_, err = canary.FollowRotations(key(3))
+	assert.Error(t, err)
+	_, err = rotated(*second).FollowRotations(key(0))
+	assert.Error(t, err)
+
+	// as is a chain with a rotation signed by another key
+	forged, err := canarytail.NewKeyRotation("example.com", keys[3], keys[2], now)
+	require.NoError(t, err)
+	forged.OldKey = key(1)
+	_, err = rotated(*first, *forged).FollowRotations(key(0))
+	assert.Error(t, err)
+
+	// or a rotation of another domain
+	other, err := canarytail.NewKeyRotation("example.org", keys[1], keys[2], now)
+	require.NoError(t, err)
+	_, err = rotated(*first, *other).FollowRotations(key(0))
+	assert.Error(t, err)
+}
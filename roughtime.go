@@ -0,0 +1,444 @@
+package canarytail
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Roughtime secure time, see https://roughtime.googlesource.com/roughtime/+/HEAD/PROTOCOL.md
+//
+// Servers sign the time they receive a request at, with its uncertainty radius. The nonce of each
+// request commits to the previous response, so a chain of responses also proves the order in which
+// the servers answered.
+
+const (
+	roughtimeRequestSize       = 1024
+	roughtimeMaxResponseSize   = 4096
+	roughtimeResponseContext   = "RoughTime v1 response signature\x00"
+	roughtimeDelegationContext = "RoughTime v1 delegation signature--\x00"
+)
+
+// RoughtimeServer is a Roughtime server and its long-term public key
+type RoughtimeServer struct {
+	Name      string
+	Address   string
+	PublicKey ed25519.PublicKey
+}
+
+// DefaultRoughtimeServers are public Roughtime servers run by independent operators
+var DefaultRoughtimeServers = []RoughtimeServer{
+	mustRoughtimeServer("Google", "roughtime.sandbox.google.com:2002", "etPaaIxcBMY1oUeGpwvPMCJMwlRVNxv51KK/tktoJTQ="),
+	mustRoughtimeServer("Cloudflare", "roughtime.cloudflare.com:2002", "gD63hSj3ScS+wuOeGrubXlq35N1c5Lby/S+T7MNTjxo="),
+	mustRoughtimeServer("int08h", "roughtime.int08h.com:2002", "AW5uAoTSTDfG5NfY1bTh08GUnOqlRb+HVhbJ3ODJvsE="),
+}
+
+func mustRoughtimeServer(name, address, publicKey string) RoughtimeServer {
+	server, err := ParseRoughtimeServer(address + "=" + publicKey)
+	if err != nil {
+		panic(err)
+	}
+	server.Name = name
+	return server
+}
+
+// ParseRoughtimeServer parses a server specification in the form ADDRESS=PUBLICKEY, where PUBLICKEY is
+// the base64 encoded Ed25519 key of the server
+func ParseRoughtimeServer(spec string) (RoughtimeServer, error) {
+	i := strings.Index(spec, "=")
+	if i <= 0 {
+		return RoughtimeServer{}, fmt.Errorf("invalid Roughtime server %q, expected ADDRESS=PUBLICKEY", spec)
+	}
+	key, err := base64.StdEncoding.DecodeString(spec[i+1:])
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return RoughtimeServer{}, fmt.Errorf("invalid public key for Roughtime server %q", spec[:i])
+	}
+	return RoughtimeServer{Name: spec[:i], Address: spec[:i], PublicKey: key}, nil
+}
+
+// RoughtimeMessage is a Roughtime message, mapping 4 byte tags to their values
+type RoughtimeMessage map[string][]byte
+
+// roughtimeTag orders the tags of a message
+func roughtimeTag(tag string) uint32 {
+	return binary.LittleEndian.Uint32([]byte(tag))
+}
+
+// ParseRoughtimeMessage parses a Roughtime message
+func ParseRoughtimeMessage(data []byte) (RoughtimeMessage, error) {
+	if len(data) < 4 || len(data)%4 != 0 {
+		return nil, errors.New("invalid Roughtime message length")
+	}
+	n := int(binary.LittleEndian.Uint32(data))
+	if n == 0 || n > len(data)/8 {
+		return nil, fmt.Errorf("invalid number of tags in Roughtime message: %d", n)
+	}
+	offsets, tags, values := data[4:4*n], data[4*n:8*n], data[8*n:]
+
+	msg := make(RoughtimeMessage, n)
+	start, previous := 0, uint32(0)
+	for i := 0; i < n; i++ {
+		end := len(values)
+		if i < n-1 {
+			end = int(binary.LittleEndian.Uint32(offsets[4*i:]))
+		}
+		tag := tags[4*i : 4*i+4]
+		if end < start || end > len(values) || end%4 != 0 {
+			return nil, fmt.Errorf("invalid offset of Roughtime tag %q", tag)
+		}
+		if i > 0 && binary.LittleEndian.Uint32(tag) <= previous {
+			return nil, errors.New("the tags of the Roughtime message are not sorted")
+		}
+		msg[string(tag)] = values[start:end]
+		start, previous = end, binary.LittleEndian.Uint32(tag)
+	}
+	return msg, nil
+}
+
+// Bytes serializes the message. Values must be a multiple of 4 bytes long.
+func (m RoughtimeMessage) Bytes() []byte {
+	tags := make([]string, 0, len(m))
+	for tag := range m {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return roughtimeTag(tags[i]) < roughtimeTag(tags[j]) })
+
+	header := make([]byte, 4*len(tags), 8*len(tags))
+	binary.LittleEndian.PutUint32(header, uint32(len(tags)))
+	offset := 0
+	for i, tag := range tags[:len(tags)-1] {
+		offset += len(m[tag])
+		binary.LittleEndian.PutUint32(header[4*(i+1):], uint32(offset))
+	}
+	var values []byte
+	for _, tag := range tags {
+		header = append(header, tag...)
+		values = append(values, m[tag]...)
+	}
+	return append(header, values...)
+}
+
+// get returns the value of tag, checking its size when size is not 0
+func (m RoughtimeMessage) get(tag string, size int) ([]byte, error) {
+	value, ok := m[tag]
+	if !ok {
+		return nil, fmt.Errorf("missing %q in Roughtime message", strings.TrimRight(tag, "\x00\xff"))
+	}
+	if size > 0 && len(value) != size {
+		return nil, fmt.Errorf("invalid %q in Roughtime message", strings.TrimRight(tag, "\x00\xff"))
+	}
+	return value, nil
+}
+
+// roughtimeTime converts a Roughtime timestamp, in microseconds since the epoch
+func roughtimeTime(value []byte) time.Time {
+	us := binary.LittleEndian.Uint64(value)
+	return time.Unix(int64(us/1e6), int64(us%1e6)*1e3)
+}
+
+// RoughtimeRequest builds the request for a nonce
+func RoughtimeRequest(nonce []byte) []byte {
+	// 16 bytes of header for the two tags
+	return RoughtimeMessage{
+		"NONC":    nonce,
+		"PAD\xff": make([]byte, roughtimeRequestSize-16-len(nonce)),
+	}.Bytes()
+}
+
+// RoughtimeLeaf hashes a nonce into a leaf of the Merkle tree a server signs
+func RoughtimeLeaf(nonce []byte) []byte {
+	h := sha512.Sum512(append([]byte{0}, nonce...))
+	return h[:]
+}
+
+// roughtimeNode hashes two nodes of the Merkle tree
+func roughtimeNode(left, right []byte) []byte {
+	h := sha512.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// VerifyRoughtimeResponse verifies the response of a server to a nonce, and returns the time the
+// server signed with its radius of uncertainty
+func VerifyRoughtimeResponse(publicKey ed25519.PublicKey, nonce, response []byte) (time.Time, time.Duration, error) {
+	msg, err := ParseRoughtimeMessage(response)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	// the long-term key delegates to an online key for a time window
+	certBytes, err := msg.get("CERT", 0)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	cert, err := ParseRoughtimeMessage(certBytes)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	deleBytes, err := cert.get("DELE", 0)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	certSig, err := cert.get("SIG\x00", ed25519.SignatureSize)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if !ed25519.Verify(publicKey, append([]byte(roughtimeDelegationContext), deleBytes...), certSig) {
+		return time.Time{}, 0, errors.New("invalid delegation signature")
+	}
+	dele, err := ParseRoughtimeMessage(deleBytes)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	delegatedKey, err := dele.get("PUBK", ed25519.PublicKeySize)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	minTime, err := dele.get("MINT", 8)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	maxTime, err := dele.get("MAXT", 8)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	// the online key signs the time and the root of a Merkle tree of the nonces it answers
+	srepBytes, err := msg.get("SREP", 0)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	sig, err := msg.get("SIG\x00", ed25519.SignatureSize)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if !ed25519.Verify(delegatedKey, append([]byte(roughtimeResponseContext), srepBytes...), sig) {
+		return time.Time{}, 0, errors.New("invalid response signature")
+	}
+	srep, err := ParseRoughtimeMessage(srepBytes)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	root, err := srep.get("ROOT", sha512.Size)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	midpoint, err := srep.get("MIDP", 8)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	radius, err := srep.get("RADI", 4)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	index, err := msg.get("INDX", 4)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	path, err := msg.get("PATH", 0)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if len(path)%sha512.Size != 0 {
+		return time.Time{}, 0, errors.New("invalid Merkle path in Roughtime response")
+	}
+	hash, i := RoughtimeLeaf(nonce), binary.LittleEndian.Uint32(index)
+	for ; len(path) > 0; path = path[sha512.Size:] {
+		if i&1 == 0 {
+			hash = roughtimeNode(hash, path[:sha512.Size])
+		} else {
+			hash = roughtimeNode(path[:sha512.Size], hash)
+		}
+		i >>= 1
+	}
+	if !bytes.Equal(hash, root) {
+		return time.Time{}, 0, errors.New("the Roughtime response does not answer our nonce")
+	}
+
+	t := roughtimeTime(midpoint)
+	if t.Before(roughtimeTime(minTime)) || t.After(roughtimeTime(maxTime)) {
+		return time.Time{}, 0, errors.New("the delegated key was used outside of its validity")
+	}
+	return t, time.Duration(binary.LittleEndian.Uint32(radius)) * time.Microsecond, nil
+}
+
+// query sends a request to the server and waits for its response
+func (s RoughtimeServer) query(nonce []byte, timeout time.Duration) (response []byte, sent, received time.Time, err error) {
+	conn, err := net.DialTimeout("udp", s.Address, timeout)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	sent = time.Now()
+	if _, err := conn.Write(RoughtimeRequest(nonce)); err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+	buf := make([]byte, roughtimeMaxResponseSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+	return buf[:n], sent, time.Now(), nil
+}
+
+// ClockReading is a time with known uncertainty bounds: the actual time lies between Earliest and Latest
+type ClockReading struct {
+	Earliest time.Time
+	Latest   time.Time
+	// Source tells where the reading comes from
+	Source string
+}
+
+// LocalTime reads the local clock, which is trusted without uncertainty
+func LocalTime() ClockReading {
+	now := time.Now()
+	return ClockReading{Earliest: now, Latest: now, Source: "local clock"}
+}
+
+// Midpoint is the most likely time of the reading
+func (r ClockReading) Midpoint() time.Time {
+	return r.Earliest.Add(r.Radius())
+}
+
+// Radius is the uncertainty of the reading around its Midpoint
+func (r ClockReading) Radius() time.Duration {
+	return r.Latest.Sub(r.Earliest) / 2
+}
+
+// Offset returns how far t falls outside of the bounds of the reading: negative when t is too early,
+// positive when it is too late, and 0 when the reading agrees with t
+func (r ClockReading) Offset(t time.Time) time.Duration {
+	if t.Before(r.Earliest) {
+		return t.Sub(r.Earliest)
+	}
+	if t.After(r.Latest) {
+		return t.Sub(r.Latest)
+	}
+	return 0
+}
+
+// RoughtimeClient reads the time from several Roughtime servers, at least Required of which must answer
+// and agree
+type RoughtimeClient struct {
+	Servers  []RoughtimeServer
+	Required int
+	Timeout  time.Duration
+}
+
+// NewRoughtimeClient instantiates a RoughtimeClient requiring required of the given servers to agree
+func NewRoughtimeClient(required int, servers ...RoughtimeServer) (*RoughtimeClient, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("Roughtime needs at least one server")
+	}
+	if required < 1 || required > len(servers) {
+		return nil, fmt.Errorf("the quorum must be between 1 and %d, got %d", len(servers), required)
+	}
+	return &RoughtimeClient{Servers: servers, Required: required, Timeout: 5 * time.Second}, nil
+}
+
+// roughtimeInterval is the time told by a server, brought forward to now with the local monotonic clock
+type roughtimeInterval struct {
+	earliest, latest time.Time
+}
+
+// Now queries the servers in turn, chaining the nonces of the requests, and returns the time range the
+// most servers agree on
+func (c *RoughtimeClient) Now() (ClockReading, error) {
+	type answer struct {
+		midpoint       time.Time
+		radius         time.Duration
+		sent, received time.Time
+	}
+	var answers []answer
+	var failures []string
+	previous := []byte{}
+	for _, server := range c.Servers {
+		blind := make([]byte, 64)
+		if _, err := rand.Read(blind); err != nil {
+			return ClockReading{}, err
+		}
+		nonce := sha512.Sum512(append(previous, blind...))
+		response, sent, received, err := server.query(nonce[:], c.Timeout)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", server.Name, err))
+			continue
+		}
+		midpoint, radius, err := VerifyRoughtimeResponse(server.PublicKey, nonce[:], response)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", server.Name, err))
+			continue
+		}
+		previous = response
+		answers = append(answers, answer{midpoint, radius, sent, received})
+	}
+	if len(answers) < c.Required {
+		return ClockReading{}, fmt.Errorf("only %d of the %d Roughtime servers answered, %d required: %s",
+			len(answers), len(c.Servers), c.Required, strings.Join(failures, "; "))
+	}
+
+	// the server read its clock between the moment the request was sent and the response received
+	intervals := make([]roughtimeInterval, len(answers))
+	for i, a := range answers {
+		intervals[i] = roughtimeInterval{
+			earliest: a.midpoint.Add(-a.radius).Add(time.Since(a.received)),
+			latest:   a.midpoint.Add(a.radius).Add(time.Since(a.sent)),
+		}
+	}
+	agreeing, earliest, latest := marzullo(intervals)
+	if agreeing < c.Required {
+		return ClockReading{}, fmt.Errorf("the Roughtime servers disagree on the time, at most %d of them agree, %d required", agreeing, c.Required)
+	}
+	return ClockReading{
+		Earliest: earliest,
+		Latest:   latest,
+		Source:   fmt.Sprintf("%d of %d Roughtime servers", agreeing, len(c.Servers)),
+	}, nil
+}
+
+// marzullo finds the smallest interval the most intervals overlap on, and how many do
+func marzullo(intervals []roughtimeInterval) (int, time.Time, time.Time) {
+	type edge struct {
+		t     time.Time
+		start bool
+	}
+	edges := make([]edge, 0, 2*len(intervals))
+	for _, interval := range intervals {
+		edges = append(edges, edge{interval.earliest, true}, edge{interval.latest, false})
+	}
+	// at equal times, intervals start before others end so that touching intervals overlap
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].t.Equal(edges[j].t) {
+			return edges[i].start && !edges[j].start
+		}
+		return edges[i].t.Before(edges[j].t)
+	})
+
+	best, count := 0, 0
+	var earliest, latest time.Time
+	for i, e := range edges {
+		if !e.start {
+			count--
+			continue
+		}
+		count++
+		if count > best {
+			// the overlap lasts until the next edge
+			best, earliest, latest = count, e.t, edges[i+1].t
+		}
+	}
+	return best, earliest, latest
+}
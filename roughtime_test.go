@@ -0,0 +1,135 @@
+package canarytail_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRoughtimeServer starts a stand-in Roughtime server whose clock is offset from the local one
+func newRoughtimeServer(t *testing.T, offset time.Duration) canarytail.RoughtimeServer {
+	rootPublic, rootPrivate, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	onlinePublic, onlinePrivate, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	microseconds := func(t time.Time) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(t.UnixNano()/1e3))
+		return b
+	}
+	dele := canarytail.RoughtimeMessage{
+		"PUBK": onlinePublic,
+		"MINT": microseconds(time.Now().Add(-24 * time.Hour)),
+		"MAXT": microseconds(time.Now().Add(24 * time.Hour)),
+	}.Bytes()
+	cert := canarytail.RoughtimeMessage{
+		"DELE":    dele,
+		"SIG\x00": ed25519.Sign(rootPrivate, append([]byte("RoughTime v1 delegation signature--\x00"), dele...)),
+	}.Bytes()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := canarytail.ParseRoughtimeMessage(buf[:n])
+			if err != nil || n < 1024 {
+				continue
+			}
+			radius := make([]byte, 4)
+			binary.LittleEndian.PutUint32(radius, 1000000)
+			// a tree of a single nonce: its root is the leaf
+			srep := canarytail.RoughtimeMessage{
+				"ROOT": canarytail.RoughtimeLeaf(req["NONC"]),
+				"MIDP": microseconds(time.Now().Add(offset)),
+				"RADI": radius,
+			}.Bytes()
+			conn.WriteTo(canarytail.RoughtimeMessage{
+				"SIG\x00": ed25519.Sign(onlinePrivate, append([]byte("RoughTime v1 response signature\x00"), srep...)),
+				"SREP":    srep,
+				"CERT":    cert,
+				"PATH":    nil,
+				"INDX":    make([]byte, 4),
+			}.Bytes(), addr)
+		}
+	}()
+
+	server, err := canarytail.ParseRoughtimeServer(conn.LocalAddr().String() + "=" + base64.StdEncoding.EncodeToString(rootPublic))
+	require.NoError(t, err)
+	return server
+}
+
+func TestRoughtimeMessage(t *testing.T) {
+	msg := canarytail.RoughtimeMessage{"NONC": make([]byte, 64), "PAD\xff": make([]byte, 8), "SIG\x00": []byte("sign")}
+	data := msg.Bytes()
+	parsed, err := canarytail.ParseRoughtimeMessage(data)
+	require.NoError(t, err)
+	assert.Equal(t, msg, parsed)
+	// SIG sorts first, as tags are compared as little-endian integers
+	assert.Equal(t, "SIG\x00", string(data[12:16]))
+
+	assert.Len(t, canarytail.RoughtimeRequest(make([]byte, 64)), 1024)
+
+	_, err = canarytail.ParseRoughtimeMessage(data[:len(data)-2])
+	assert.Error(t, err)
+}
+
+func TestRoughtimeClient(t *testing.T) {
+	honest1, honest2 := newRoughtimeServer(t, 0), newRoughtimeServer(t, 0)
+	liar := newRoughtimeServer(t, -48*time.Hour)
+
+	t.Run("agreement", func(t *testing.T) {
+		client, err := canarytail.NewRoughtimeClient(2, honest1, liar, honest2)
+		require.NoError(t, err)
+		reading, err := client.Now()
+		require.NoError(t, err)
+		assert.Equal(t, time.Duration(0), reading.Offset(time.Now()))
+		assert.WithinDuration(t, time.Now(), reading.Midpoint(), 2*time.Second)
+		assert.True(t, reading.Radius() < 2*time.Second)
+		assert.Equal(t, "2 of 3 Roughtime servers", reading.Source)
+	})
+
+	t.Run("disagreement", func(t *testing.T) {
+		client, err := canarytail.NewRoughtimeClient(2, honest1, liar)
+		require.NoError(t, err)
+		_, err = client.Now()
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong public key", func(t *testing.T) {
+		impostor := honest1
+		impostor.PublicKey = honest2.PublicKey
+		client, err := canarytail.NewRoughtimeClient(1, impostor)
+		require.NoError(t, err)
+		_, err = client.Now()
+		assert.Contains(t, err.Error(), "invalid delegation signature")
+	})
+
+	t.Run("the local clock is off", func(t *testing.T) {
+		client, err := canarytail.NewRoughtimeClient(1, newRoughtimeServer(t, 2*time.Hour))
+		require.NoError(t, err)
+		reading, err := client.Now()
+		require.NoError(t, err)
+		assert.True(t, reading.Offset(time.Now()) < -time.Hour)
+
+		// the canary expires within the hour, so it is stale by the Roughtime clock
+		c := testCanary(t, nil)
+		ok, err := c.ValidateWithOptions(canarytail.ValidateOptions{Offline: true, Time: &reading})
+		assert.False(t, ok)
+		assert.Contains(t, err.Error(), "expired")
+	})
+}
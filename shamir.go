@@ -0,0 +1,173 @@
+package canarytail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Shamir's secret sharing over GF(2^8), splitting a secret into shares so that any threshold of them
+// rebuild it, while fewer reveal nothing about it. It is used to split the panic key between people.
+
+const secretShareVersion = 1
+
+// SecretShare is a share of a secret
+type SecretShare struct {
+	Threshold int
+	// Index is the x coordinate of the share, from 1
+	Index byte
+	// SecretID identifies the secret, so that shares of different secrets are not mixed up
+	SecretID [4]byte
+	Value    []byte
+}
+
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// String encodes the share in groups of 5 base32 characters, with a checksum
+func (s SecretShare) String() string {
+	payload := append([]byte{secretShareVersion, byte(s.Threshold), s.Index}, s.SecretID[:]...)
+	payload = append(payload, s.Value...)
+	checksum := sha256.Sum256(payload)
+	encoded := shareEncoding.EncodeToString(append(payload, checksum[:4]...))
+
+	var groups []string
+	for len(encoded) > 5 {
+		groups = append(groups, encoded[:5])
+		encoded = encoded[5:]
+	}
+	return strings.Join(append(groups, encoded), "-")
+}
+
+// ParseSecretShare decodes a share encoded with String, checking its checksum. Anything before a
+// colon is a label and ignored.
+func ParseSecretShare(s string) (SecretShare, error) {
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		s = s[i+1:]
+	}
+	s = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(s)))
+	data, err := shareEncoding.DecodeString(s)
+	if err != nil || len(data) < 3+4+1+4 {
+		return SecretShare{}, errors.New("invalid share, check it was copied correctly")
+	}
+	payload, checksum := data[:len(data)-4], data[len(data)-4:]
+	expected := sha256.Sum256(payload)
+	if !bytes.Equal(checksum, expected[:4]) {
+		return SecretShare{}, errors.New("the checksum of the share does not match, check it was copied correctly")
+	}
+	if payload[0] != secretShareVersion {
+		return SecretShare{}, fmt.Errorf("unsupported share version %d", payload[0])
+	}
+	share := SecretShare{Threshold: int(payload[1]), Index: payload[2], Value: payload[7:]}
+	copy(share.SecretID[:], payload[3:7])
+	return share, nil
+}
+
+// SplitSecret splits a secret into n shares, any threshold of which rebuild it
+func SplitSecret(secret []byte, n, threshold int) ([]SecretShare, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("invalid %d of %d sharing, expected 2 <= threshold <= shares <= 255", threshold, n)
+	}
+	shares := make([]SecretShare, n)
+	// the ID is random: derived from the secret, it would give some of it away with every share
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	for i := range shares {
+		shares[i] = SecretShare{Threshold: threshold, Index: byte(i + 1), SecretID: id, Value: make([]byte, len(secret))}
+	}
+
+	// every byte of the secret is the constant term of its own random polynomial of degree threshold-1
+	coefficients := make([]byte, threshold)
+	for b, s := range secret {
+		coefficients[0] = s
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i].Value[b] = gfPolynomial(coefficients, shares[i].Index)
+		}
+	}
+	return shares, nil
+}
+
+// CombineShares rebuilds a secret from at least threshold of its shares. A share altered but for its
+// checksum rebuilds another secret: check the secret, e.g. against its public key.
+func CombineShares(shares []SecretShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares given")
+	}
+	first := shares[0]
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if s.SecretID != first.SecretID || s.Threshold != first.Threshold || len(s.Value) != len(first.Value) {
+			return nil, errors.New("the shares are not all shares of the same secret")
+		}
+		if s.Index == 0 || seen[s.Index] {
+			return nil, fmt.Errorf("the share %d is given twice", s.Index)
+		}
+		seen[s.Index] = true
+	}
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("%d shares are needed, only %d given", first.Threshold, len(shares))
+	}
+	shares = shares[:first.Threshold]
+
+	// Lagrange interpolation at x = 0
+	secret := make([]byte, len(first.Value))
+	for i, si := range shares {
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(sj.Index, sj.Index^si.Index))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(basis, si.Value[b])
+		}
+	}
+	return secret, nil
+}
+
+// GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1, through log and exp tables of the generator 3
+var gfExp, gfLog = func() (exp [510]byte, log [256]byte) {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = x, x
+		log[x] = byte(i)
+		// multiply by 3: x*2 + x, reducing modulo the polynomial
+		double := x << 1
+		if x&0x80 != 0 {
+			double ^= 0x1b
+		}
+		x ^= double
+	}
+	return
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfPolynomial evaluates the polynomial with the coefficients at x, by Horner's method
+func gfPolynomial(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coefficients[i]
+	}
+	return y
+}
@@ -0,0 +1,77 @@
+package canarytail_test
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretSharing(t *testing.T) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	require.NoError(t, err)
+
+	shares, err := canarytail.SplitSecret(secret, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+
+	// any 3 of the 5 shares rebuild the secret
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			for c := b + 1; c < 5; c++ {
+				combined, err := canarytail.CombineShares([]canarytail.SecretShare{shares[c], shares[a], shares[b]})
+				require.NoError(t, err)
+				assert.Equal(t, secret, combined)
+			}
+		}
+	}
+
+	_, err = canarytail.CombineShares(shares[:2])
+	assert.EqualError(t, err, "3 shares are needed, only 2 given")
+	_, err = canarytail.CombineShares([]canarytail.SecretShare{shares[0], shares[1], shares[1]})
+	assert.Error(t, err)
+
+	others, err := canarytail.SplitSecret(secret[:16], 5, 3)
+	require.NoError(t, err)
+	_, err = canarytail.CombineShares([]canarytail.SecretShare{shares[0], shares[1], others[2]})
+	assert.Contains(t, err.Error(), "not all shares of the same secret")
+
+	forged := shares[2]
+	forged.Value = append([]byte{forged.Value[0] ^ 1}, forged.Value[1:]...)
+	combined, err := canarytail.CombineShares([]canarytail.SecretShare{shares[0], shares[1], forged})
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, combined)
+
+	// the shares tell nothing of the secret, not even through their ID
+	again, err := canarytail.SplitSecret(secret, 5, 3)
+	require.NoError(t, err)
+	assert.NotEqual(t, shares[0].SecretID, again[0].SecretID)
+
+	_, err = canarytail.SplitSecret(secret, 3, 4)
+	assert.Error(t, err)
+}
+
+func TestSecretShareEncoding(t *testing.T) {
+	shares, err := canarytail.SplitSecret(make([]byte, 32), 3, 2)
+	require.NoError(t, err)
+	encoded := shares[1].String()
+
+	parsed, err := canarytail.ParseSecretShare("example.com panic share 2 of 3: " + strings.ToLower(encoded))
+	require.NoError(t, err)
+	assert.Equal(t, shares[1], parsed)
+
+	// a typo is caught by the checksum
+	typo := []byte(encoded)
+	if typo[0] == 'A' {
+		typo[0] = 'B'
+	} else {
+		typo[0] = 'A'
+	}
+	_, err = canarytail.ParseSecretShare(string(typo))
+	assert.Contains(t, err.Error(), "checksum")
+}
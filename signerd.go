@@ -0,0 +1,366 @@
+package canarytail
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// The signer daemon holds the keys of canaries in a separate process, and signs canaries for local
+// clients over a unix socket, as long as they pass its policy. Its API is HTTP:
+//
+//	GET  /public?domain=DOMAIN&role=ROLE   returns the public key of a domain
+//	POST /sign                             signs the canary of a SignRequest and returns a SignResponse
+
+// SignerPolicy limits what the signer daemon signs
+type SignerPolicy struct {
+	// Domains are the domains whose canaries may be signed. Their keys are loaded when the daemon starts.
+	Domains []string `json:"domains"`
+	// Roles are the keys that may be used (default: signing and panic)
+	Roles []KeyRole `json:"roles,omitempty"`
+	// MaxExpiryMinutes is how long after their release canaries may expire (default: no limit)
+	MaxExpiryMinutes int `json:"max_expiry_minutes,omitempty"`
+	// MaxReleaseSkewMinutes is how far the release of canaries may be from the time they are signed
+	// (default: 10 minutes)
+	MaxReleaseSkewMinutes int `json:"max_release_skew_minutes,omitempty"`
+}
+
+// LoadSignerPolicy reads a policy in JSON
+func LoadSignerPolicy(path string) (*SignerPolicy, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &SignerPolicy{}
+	if err := json.Unmarshal(content, policy); err != nil {
+		return nil, fmt.Errorf("invalid signer policy in %v: %v", path, err)
+	}
+	return policy, nil
+}
+
+// Check tells why a canary may not be signed for a domain with a role, if so. The signers, the panic
+// key and the tripped codes of the canary are pinned to the previous canary signed for the domain, if
+// any: only the author changes them, outside of the daemon.
+func (p *SignerPolicy) Check(domain string, role KeyRole, c *Canary, previous *Canary, now time.Time) error {
+	if !containsString(p.Domains, domain) {
+		return fmt.Errorf("the domain %v is not allowed", domain)
+	}
+	if c.Claim.Domain != domain {
+		return fmt.Errorf("the canary is for %v, not %v", c.Claim.Domain, domain)
+	}
+	roles := p.Roles
+	if len(roles) == 0 {
+		roles = []KeyRole{SigningKeyRole, PanicKeyRole}
+	}
+	allowed := false
+	for _, r := range roles {
+		allowed = allowed || r == role
+	}
+	if !allowed {
+		return fmt.Errorf("the %v key is not allowed", role)
+	}
+
+	release, err := time.Parse(TimestampLayout, c.Claim.Release)
+	if err != nil {
+		return fmt.Errorf("invalid release date %q", c.Claim.Release)
+	}
+	expiry, err := time.Parse(TimestampLayout, c.Claim.Expiry)
+	if err != nil {
+		return fmt.Errorf("invalid expiry date %q", c.Claim.Expiry)
+	}
+	skew := time.Duration(p.MaxReleaseSkewMinutes) * time.Minute
+	if skew == 0 {
+		skew = 10 * time.Minute
+	}
+	if release.Sub(now) > skew || now.Sub(release) > skew {
+		return fmt.Errorf("the canary is released %v, more than %v away from now", c.Claim.Release, skew)
+	}
+	if !expiry.After(release) {
+		return errors.New("the canary expires before its release")
+	}
+	if maxExpiry := time.Duration(p.MaxExpiryMinutes) * time.Minute; maxExpiry > 0 && expiry.Sub(release) > maxExpiry {
+		return fmt.Errorf("the canary expires more than %v after its release", maxExpiry)
+	}
+
+	if previous == nil {
+		return nil
+	}
+	if c.ReleaseTimestamp().Before(previous.ReleaseTimestamp()) {
+		return fmt.Errorf("the canary is released before the previous canary, on %v", previous.Claim.Release)
+	}
+	if c.Claim.PanicKey != previous.Claim.PanicKey {
+		return errors.New("the canary changes the panic key")
+	}
+	if c.Claim.MinSigners != previous.Claim.MinSigners || !reflect.DeepEqual(c.Claim.PublicKeys, previous.Claim.PublicKeys) {
+		return errors.New("the canary changes the signers")
+	}
+	for _, code := range previous.MissingCodes() {
+		if containsString(c.Claim.Codes, code) {
+			return fmt.Errorf("the canary restores the code %v, tripped in the previous canary", code)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SignRequest asks the signer daemon to sign a canary
+type SignRequest struct {
+	Domain string  `json:"domain"`
+	Role   KeyRole `json:"role"`
+	Canary Canary  `json:"canary"`
+}
+
+// SignResponse holds the signatures of the canary, under the public key of the domain
+type SignResponse struct {
+	PublicKey  string              `json:"public_key"`
+	Signatures *CanarySignatureSet `json:"signatures"`
+}
+
+// AuditEntry is a line of the audit log of the signer daemon, in JSON
+type AuditEntry struct {
+	Time      string   `json:"time"`
+	Domain    string   `json:"domain"`
+	Role      KeyRole  `json:"role"`
+	PublicKey string   `json:"public_key,omitempty"`
+	Release   string   `json:"release"`
+	Expiry    string   `json:"expiry"`
+	Codes     []string `json:"codes"`
+	Signed    bool     `json:"signed"`
+	Reason    string   `json:"reason,omitempty"`
+}
+
+// SignerServer is the signer daemon, signing with the keys of a KeyStore
+type SignerServer struct {
+	Keys   KeyStore
+	Policy SignerPolicy
+	// Audit receives an AuditEntry for every sign request
+	Audit io.Writer
+	// Previous are the last canaries signed for the domains, the next ones are checked against
+	Previous map[string]*Canary
+	// Signed, when set, is called with every canary signed, e.g. to keep it across restarts
+	Signed func(Canary)
+
+	mu      sync.Mutex // guards Previous, so that sign requests are checked in turn
+	auditMu sync.Mutex
+}
+
+// Serve answers the requests of the clients connecting to the listener
+func (s *SignerServer) Serve(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/public", s.handlePublic)
+	mux.HandleFunc("/sign", s.handleSign)
+	return http.Serve(l, mux)
+}
+
+func (s *SignerServer) handlePublic(w http.ResponseWriter, r *http.Request) {
+	domain, role := r.URL.Query().Get("domain"), KeyRole(r.URL.Query().Get("role"))
+	if !containsString(s.Policy.Domains, domain) {
+		http.Error(w, fmt.Sprintf("the domain %v is not allowed", domain), http.StatusForbidden)
+		return
+	}
+	publicKey, err := s.Keys.PublicKey(domain, role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(SignResponse{PublicKey: FormatKey(publicKey)})
+}
+
+func (s *SignerServer) handleSign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "expected POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var req SignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid sign request: %v", err), http.StatusBadRequest)
+		return
+	}
+	entry := AuditEntry{
+		Time:    time.Now().UTC().Format(TimestampLayout),
+		Domain:  req.Domain,
+		Role:    req.Role,
+		Release: req.Canary.Claim.Release,
+		Expiry:  req.Canary.Claim.Expiry,
+		Codes:   req.Canary.Claim.Codes,
+	}
+
+	resp, status, err := s.sign(&req)
+	if err != nil {
+		entry.Reason = err.Error()
+		s.audit(entry)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	entry.Signed, entry.PublicKey = true, resp.PublicKey
+	s.audit(entry)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *SignerServer) sign(req *SignRequest) (*SignResponse, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Policy.Check(req.Domain, req.Role, &req.Canary, s.Previous[req.Domain], time.Now()); err != nil {
+		return nil, http.StatusForbidden, err
+	}
+	signer, err := s.Keys.Signer(req.Domain, req.Role)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+	// only the claims are signed, whatever signatures came with the canary
+	canary := req.Canary
+	canary.Signatures = nil
+	if err := canary.Sign(signer); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if s.Previous == nil {
+		s.Previous = make(map[string]*Canary)
+	}
+	s.Previous[req.Domain] = &canary
+	if s.Signed != nil {
+		s.Signed(canary)
+	}
+	publicKey := FormatKey(signer.Public().(ed25519.PublicKey))
+	return &SignResponse{PublicKey: publicKey, Signatures: canary.Signatures[publicKey]}, http.StatusOK, nil
+}
+
+func (s *SignerServer) audit(entry AuditEntry) {
+	if s.Audit == nil {
+		return
+	}
+	line, _ := json.Marshal(entry)
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	s.Audit.Write(append(line, '\n'))
+}
+
+// SignerClient is the KeyStore of a signer daemon listening on a unix socket
+type SignerClient struct {
+	Socket string
+	client *http.Client
+}
+
+// NewSignerClient instantiates a client of the signer daemon listening on socket
+func NewSignerClient(socket string) *SignerClient {
+	dialer := &net.Dialer{}
+	return &SignerClient{
+		Socket: socket,
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}},
+	}
+}
+
+// do sends a request to the daemon, and decodes its response
+func (c *SignerClient) do(method, path string, body interface{}) (*SignResponse, error) {
+	var reqBody io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(content)
+	}
+	req, err := http.NewRequest(method, "http://signer"+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not reach the signer daemon at %v: %v", c.Socket, err)
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the signer daemon refused: %s", bytes.TrimSpace(content))
+	}
+	signed := &SignResponse{}
+	if err := json.Unmarshal(content, signed); err != nil {
+		return nil, fmt.Errorf("invalid response of the signer daemon: %v", err)
+	}
+	return signed, nil
+}
+
+// PublicKey asks the daemon for the public key of a domain
+func (c *SignerClient) PublicKey(domain string, role KeyRole) (ed25519.PublicKey, error) {
+	resp, err := c.do(http.MethodGet, "/public?"+url.Values{"domain": {domain}, "role": {string(role)}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := ParsePublicKey(resp.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("the signer daemon returned an invalid public key")
+	}
+	return publicKey, nil
+}
+
+// Signer returns a CanarySigner sending canaries to the daemon
+func (c *SignerClient) Signer(domain string, role KeyRole) (crypto.Signer, error) {
+	publicKey, err := c.PublicKey(domain, role)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteSigner{client: c, domain: domain, role: role, publicKey: publicKey}, nil
+}
+
+type remoteSigner struct {
+	client    *SignerClient
+	domain    string
+	role      KeyRole
+	publicKey ed25519.PublicKey
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign is not supported: the daemon only signs whole canaries, so that its policy applies
+func (s *remoteSigner) Sign(rand io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("the signer daemon only signs canaries")
+}
+
+// SignCanary has the daemon sign the canary, and checks the signatures it returns
+func (s *remoteSigner) SignCanary(c *Canary) error {
+	resp, err := s.client.do(http.MethodPost, "/sign", SignRequest{Domain: s.domain, Role: s.role, Canary: *c})
+	if err != nil {
+		return err
+	}
+	publicKey := FormatKey(s.publicKey)
+	if resp.PublicKey != publicKey || resp.Signatures == nil {
+		return errors.New("the signer daemon signed with another key")
+	}
+	if c.Signatures == nil {
+		c.Signatures = make(map[string]*CanarySignatureSet)
+	}
+	c.Signatures[publicKey] = resp.Signatures
+	if !c.ValidateSignatures(s.publicKey) {
+		delete(c.Signatures, publicKey)
+		return errors.New("the signer daemon returned invalid signatures")
+	}
+	return nil
+}
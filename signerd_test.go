@@ -0,0 +1,188 @@
+package canarytail_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryKeyStore holds the keys of domains in memory
+type memoryKeyStore map[string]ed25519.PrivateKey
+
+func (m memoryKeyStore) Signer(domain string, role canarytail.KeyRole) (crypto.Signer, error) {
+	key, ok := m[domain+"/"+string(role)]
+	if !ok {
+		return nil, errors.New("no such key")
+	}
+	return key, nil
+}
+
+func (m memoryKeyStore) PublicKey(domain string, role canarytail.KeyRole) (ed25519.PublicKey, error) {
+	signer, err := m.Signer(domain, role)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public().(ed25519.PublicKey), nil
+}
+
+// syncBuffer is a buffer safe to write to from the server while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSpace(b.buf.String()), "\n")
+}
+
+// serveSigner runs the signer daemon on a unix socket until the test ends, and returns a client of it
+func serveSigner(t *testing.T, server *canarytail.SignerServer) *canarytail.SignerClient {
+	socket := filepath.Join(t.TempDir(), "signer.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go server.Serve(listener)
+	return canarytail.NewSignerClient(socket)
+}
+
+func TestSignerDaemon(t *testing.T) {
+	publicKey, privateKey, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+	_, panicKey, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+	audit := &syncBuffer{}
+	server := &canarytail.SignerServer{
+		Keys: memoryKeyStore{"example.com/signing": privateKey, "example.com/panic": panicKey, "other/signing": privateKey},
+		Policy: canarytail.SignerPolicy{
+			Domains:          []string{"example.com"},
+			Roles:            []canarytail.KeyRole{canarytail.SigningKeyRole},
+			MaxExpiryMinutes: 120,
+		},
+		Audit: audit,
+	}
+
+	client := serveSigner(t, server)
+	storedKey, err := client.PublicKey("example.com", canarytail.SigningKeyRole)
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, storedKey)
+	signer, err := client.Signer("example.com", canarytail.SigningKeyRole)
+	require.NoError(t, err)
+
+	t.Run("signs within the policy", func(t *testing.T) {
+		c := testCanary(t, nil)
+		require.NoError(t, c.Sign(signer))
+		assert.True(t, c.ValidateSignatures(publicKey))
+		// the signatures of the other signers are kept
+		assert.Len(t, c.Signatures, 2)
+	})
+
+	t.Run("expires too late", func(t *testing.T) {
+		c := testCanary(t, nil, func(c *canarytail.Canary) {
+			c.Claim.Expiry = time.Now().Add(3 * time.Hour).Format(canarytail.TimestampLayout)
+		})
+		err := c.Sign(signer)
+		assert.Contains(t, err.Error(), "expires more than 2h0m0s after its release")
+		assert.False(t, c.ValidateSignatures(publicKey))
+	})
+
+	t.Run("backdated", func(t *testing.T) {
+		c := testCanary(t, nil, func(c *canarytail.Canary) {
+			c.Claim.Release = time.Now().Add(-time.Hour).Format(canarytail.TimestampLayout)
+		})
+		assert.Contains(t, c.Sign(signer).Error(), "away from now")
+	})
+
+	t.Run("another domain", func(t *testing.T) {
+		c := testCanary(t, nil, func(c *canarytail.Canary) { c.Claim.Domain = "other" })
+		assert.Contains(t, c.Sign(signer).Error(), "the canary is for other")
+		_, err := client.Signer("other", canarytail.SigningKeyRole)
+		assert.Contains(t, err.Error(), "not allowed")
+	})
+
+	t.Run("role not allowed", func(t *testing.T) {
+		panicSigner, err := client.Signer("example.com", canarytail.PanicKeyRole)
+		require.NoError(t, err)
+		c := testCanary(t, nil)
+		assert.Contains(t, c.Sign(panicSigner).Error(), "the panic key is not allowed")
+	})
+
+	lines := audit.Lines()
+	require.Len(t, lines, 5)
+	var entry canarytail.AuditEntry
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.True(t, entry.Signed)
+	assert.Equal(t, canarytail.FormatKey(publicKey), entry.PublicKey)
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.False(t, entry.Signed)
+	assert.Contains(t, entry.Reason, "expires more than")
+}
+
+func TestSignerDaemonPinning(t *testing.T) {
+	publicKey, privateKey, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+	panicKey, _, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+	server := &canarytail.SignerServer{
+		Keys:   memoryKeyStore{"example.com/signing": privateKey},
+		Policy: canarytail.SignerPolicy{Domains: []string{"example.com"}},
+	}
+	var signed []canarytail.Canary
+	server.Signed = func(c canarytail.Canary) { signed = append(signed, c) }
+	signer, err := serveSigner(t, server).Signer("example.com", canarytail.SigningKeyRole)
+	require.NoError(t, err)
+
+	c := testCanary(t, nil, func(c *canarytail.Canary) {
+		c.Claim.PublicKeys = []canarytail.PublicKey{{Role: canarytail.RoleAuthor, Name: "daemon", Key: canarytail.FormatKey(publicKey), Required: true}}
+		c.Claim.PanicKey = canarytail.FormatKey(panicKey)
+	})
+	// signing requests are signed as whole canaries too
+	signature, err := canarytail.NewSigningRequest(c).Sign(signer)
+	require.NoError(t, err)
+	require.NoError(t, c.Merge(*signature))
+	assert.True(t, c.ValidateSignatures(publicKey))
+
+	tripped := c
+	tripped.Claim.Codes = canarytail.AllCodes()[1:]
+	require.NoError(t, tripped.Sign(signer))
+	require.Len(t, signed, 2)
+	assert.Equal(t, tripped.Claim, signed[1].Claim)
+
+	for reason, change := range map[string]func(*canarytail.CanaryClaim){
+		"restores the code":     func(claim *canarytail.CanaryClaim) { claim.Codes = canarytail.AllCodes() },
+		"changes the panic key": func(claim *canarytail.CanaryClaim) { claim.PanicKey = canarytail.FormatKey(publicKey) },
+		"changes the signers":   func(claim *canarytail.CanaryClaim) { claim.MinSigners = 2 },
+		"released before the previous canary": func(claim *canarytail.CanaryClaim) {
+			claim.Release = time.Now().Add(-5 * time.Minute).Format(canarytail.TimestampLayout)
+		},
+	} {
+		changed := tripped
+		changed.Claim.Codes = append([]string{}, tripped.Claim.Codes...)
+		change(&changed.Claim)
+		err := changed.Sign(signer)
+		if assert.Error(t, err, reason) {
+			assert.Contains(t, err.Error(), reason)
+		}
+	}
+	assert.Len(t, signed, 2)
+}
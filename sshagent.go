@@ -0,0 +1,87 @@
+package canarytail
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// DialSSHAgent connects to the ssh-agent listening on a unix socket, usually $SSH_AUTH_SOCK
+func DialSSHAgent(socket string) (agent.ExtendedAgent, io.Closer, error) {
+	if socket == "" {
+		return nil, nil, errors.New("no ssh-agent socket, is SSH_AUTH_SOCK set?")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not connect to ssh-agent: %v", err)
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// NewSSHAgentSigner returns a signer using the Ed25519 key of an ssh-agent with the given fingerprint,
+// in the SHA256:... or legacy MD5 form. Without fingerprint, the agent must hold a single Ed25519 key.
+func NewSSHAgentSigner(a agent.Agent, fingerprint string) (crypto.Signer, error) {
+	keys, err := a.List()
+	if err != nil {
+		return nil, fmt.Errorf("Could not list the keys of ssh-agent: %v", err)
+	}
+
+	var found []*sshAgentSigner
+	for _, k := range keys {
+		if k.Type() != ssh.KeyAlgoED25519 {
+			continue
+		}
+		if fingerprint != "" && fingerprint != ssh.FingerprintSHA256(k) && strings.TrimPrefix(fingerprint, "MD5:") != ssh.FingerprintLegacyMD5(k) {
+			continue
+		}
+		sshKey, err := ssh.ParsePublicKey(k.Marshal())
+		if err != nil {
+			return nil, err
+		}
+		publicKey, ok := sshKey.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey)
+		if !ok {
+			continue
+		}
+		found = append(found, &sshAgentSigner{agent: a, key: sshKey, publicKey: publicKey})
+	}
+
+	switch {
+	case len(found) == 1:
+		return found[0], nil
+	case fingerprint != "":
+		return nil, fmt.Errorf("ssh-agent holds no Ed25519 key with fingerprint %v", fingerprint)
+	case len(found) == 0:
+		return nil, errors.New("ssh-agent holds no Ed25519 key")
+	}
+	return nil, fmt.Errorf("ssh-agent holds %d Ed25519 keys, pick one by fingerprint", len(found))
+}
+
+type sshAgentSigner struct {
+	agent     agent.Agent
+	key       ssh.PublicKey
+	publicKey ed25519.PublicKey
+}
+
+func (s *sshAgentSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign asks the agent to sign the message. SSH Ed25519 signatures are plain Ed25519 signatures of the
+// message, so they validate as any canary signature.
+func (s *sshAgentSigner) Sign(rand io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	signature, err := s.agent.Sign(s.key, message)
+	if err != nil {
+		return nil, fmt.Errorf("Could not sign with ssh-agent: %v", err)
+	}
+	if signature.Format != ssh.KeyAlgoED25519 {
+		return nil, fmt.Errorf("ssh-agent returned a %v signature, not %v", signature.Format, ssh.KeyAlgoED25519)
+	}
+	return verifiedSignature(s.publicKey, message, signature.Blob)
+}
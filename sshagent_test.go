@@ -0,0 +1,85 @@
+package canarytail_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	canarytail "github.com/canarytail/client"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSSHAgent serves an in-memory keyring on a unix socket, as ssh-agent does
+func startSSHAgent(t *testing.T, keys ...interface{}) string {
+	keyring := agent.NewKeyring()
+	for _, k := range keys {
+		require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: k}))
+	}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	return socket
+}
+
+func TestSSHAgentSigner(t *testing.T) {
+	publicKey, privateKey, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+	_, otherKey, err := canarytail.GenerateKeyPair()
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	a, closer, err := canarytail.DialSSHAgent(startSSHAgent(t, privateKey, otherKey, rsaKey))
+	require.NoError(t, err)
+	defer closer.Close()
+
+	sshKey, err := ssh.NewPublicKey(publicKey)
+	require.NoError(t, err)
+	signer, err := canarytail.NewSSHAgentSigner(a, ssh.FingerprintSHA256(sshKey))
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, signer.Public())
+
+	now := time.Now()
+	c := canarytail.Canary{
+		Version: canarytail.StandardVersion,
+		Claim: canarytail.CanaryClaim{
+			Domain:     "test",
+			MinSigners: 1,
+			PublicKeys: []canarytail.PublicKey{{Role: canarytail.RoleAuthor, Key: canarytail.FormatKey(publicKey), Required: true}},
+			Release:    now.Format(canarytail.TimestampLayout),
+			Expiry:     now.Add(time.Hour).Format(canarytail.TimestampLayout),
+			Codes:      canarytail.AllCodes(),
+		},
+	}
+	require.NoError(t, c.Sign(signer))
+	assert.True(t, c.ValidateSignatures(publicKey))
+
+	legacy, err := canarytail.NewSSHAgentSigner(a, "MD5:"+ssh.FingerprintLegacyMD5(sshKey))
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, legacy.Public())
+
+	_, err = canarytail.NewSSHAgentSigner(a, "")
+	assert.Contains(t, err.Error(), "pick one by fingerprint")
+	_, err = canarytail.NewSSHAgentSigner(a, "SHA256:unknown")
+	assert.Contains(t, err.Error(), "no Ed25519 key")
+
+	_, _, err = canarytail.DialSSHAgent("")
+	assert.Error(t, err)
+}